@@ -27,9 +27,44 @@ import (
 	"google.golang.org/grpc"
 )
 
+// client abstracts the IAMPolicy API to allow multiple implementations.
+type client interface {
+	Get(ctx context.Context, resource string) (*pb.Policy, error)
+	Set(ctx context.Context, resource string, p *pb.Policy) error
+	Test(ctx context.Context, resource string, perms []string) ([]string, error)
+}
+
+// grpcClient implements client for the standard gRPC-based IAMPolicy service.
+type grpcClient struct {
+	c pb.IAMPolicyClient
+}
+
+func (g *grpcClient) Get(ctx context.Context, resource string) (*pb.Policy, error) {
+	return g.c.GetIamPolicy(ctx, &pb.GetIamPolicyRequest{Resource: resource})
+}
+
+func (g *grpcClient) Set(ctx context.Context, resource string, p *pb.Policy) error {
+	_, err := g.c.SetIamPolicy(ctx, &pb.SetIamPolicyRequest{
+		Resource: resource,
+		Policy:   p,
+	})
+	return err
+}
+
+func (g *grpcClient) Test(ctx context.Context, resource string, perms []string) ([]string, error) {
+	res, err := g.c.TestIamPermissions(ctx, &pb.TestIamPermissionsRequest{
+		Resource:    resource,
+		Permissions: perms,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Permissions, nil
+}
+
 // A Handle provides IAM operations for a resource.
 type Handle struct {
-	c        pb.IAMPolicyClient
+	c        client
 	resource string
 }
 
@@ -38,15 +73,24 @@ type Handle struct {
 // InternalNewHandle returns a Handle for resource.
 // The conn parameter refers to a server that must support the IAMPolicy service.
 func InternalNewHandle(conn *grpc.ClientConn, resource string) *Handle {
+	return InternalNewHandleClient(&grpcClient{c: pb.NewIAMPolicyClient(conn)}, resource)
+}
+
+// InternalNewHandleClient is for use by the Google Cloud Libraries only.
+//
+// InternalNewHandleClient returns a Handle for resource using the given
+// client implementation, for services that don't provide the IAMPolicy
+// service over gRPC.
+func InternalNewHandleClient(c client, resource string) *Handle {
 	return &Handle{
-		c:        pb.NewIAMPolicyClient(conn),
+		c:        c,
 		resource: resource,
 	}
 }
 
 // Policy retrieves the IAM policy for the resource.
 func (h *Handle) Policy(ctx context.Context) (*Policy, error) {
-	proto, err := h.c.GetIamPolicy(ctx, &pb.GetIamPolicyRequest{Resource: h.resource})
+	proto, err := h.c.Get(ctx, h.resource)
 	if err != nil {
 		return nil, err
 	}
@@ -58,23 +102,12 @@ func (h *Handle) Policy(ctx context.Context) (*Policy, error) {
 // If policy was created from a prior call to Get, then the modification will
 // only succeed if the policy has not changed since the Get.
 func (h *Handle) SetPolicy(ctx context.Context, policy *Policy) error {
-	_, err := h.c.SetIamPolicy(ctx, &pb.SetIamPolicyRequest{
-		Resource: h.resource,
-		Policy:   policy.InternalProto,
-	})
-	return err
+	return h.c.Set(ctx, h.resource, policy.InternalProto)
 }
 
 // TestPermissions returns the subset of permissions that the caller has on the resource.
 func (h *Handle) TestPermissions(ctx context.Context, permissions []string) ([]string, error) {
-	res, err := h.c.TestIamPermissions(ctx, &pb.TestIamPermissionsRequest{
-		Resource:    h.resource,
-		Permissions: permissions,
-	})
-	if err != nil {
-		return nil, err
-	}
-	return res.Permissions, nil
+	return h.c.Test(ctx, h.resource, permissions)
 }
 
 // A RoleName is a name representing a collection of permissions.
@@ -245,6 +245,38 @@ func (s *mockSubscriberServer) ModifyPushConfig(_ context.Context, req *pubsubpb
 	return s.resps[0].(*google_protobuf.Empty), nil
 }
 
+func (s *mockSubscriberServer) ListSnapshots(_ context.Context, req *pubsubpb.ListSnapshotsRequest) (*pubsubpb.ListSnapshotsResponse, error) {
+	s.reqs = append(s.reqs, req)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.resps[0].(*pubsubpb.ListSnapshotsResponse), nil
+}
+
+func (s *mockSubscriberServer) CreateSnapshot(_ context.Context, req *pubsubpb.CreateSnapshotRequest) (*pubsubpb.Snapshot, error) {
+	s.reqs = append(s.reqs, req)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.resps[0].(*pubsubpb.Snapshot), nil
+}
+
+func (s *mockSubscriberServer) DeleteSnapshot(_ context.Context, req *pubsubpb.DeleteSnapshotRequest) (*google_protobuf.Empty, error) {
+	s.reqs = append(s.reqs, req)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.resps[0].(*google_protobuf.Empty), nil
+}
+
+func (s *mockSubscriberServer) Seek(_ context.Context, req *pubsubpb.SeekRequest) (*pubsubpb.SeekResponse, error) {
+	s.reqs = append(s.reqs, req)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.resps[0].(*pubsubpb.SeekResponse), nil
+}
+
 // clientOpt is the option tests should use to connect to the test server.
 // It is initialized by TestMain.
 var clientOpt option.ClientOption
@@ -1200,3 +1232,240 @@ func TestSubscriberModifyPushConfigError(t *testing.T) {
 		t.Errorf("got error code %q, want %q", c, errCode)
 	}
 }
+func TestSubscriberListSnapshots(t *testing.T) {
+	var nextPageToken string = ""
+	var snapshotsElement *pubsubpb.Snapshot = &pubsubpb.Snapshot{}
+	var snapshots = []*pubsubpb.Snapshot{snapshotsElement}
+	var expectedResponse = &pubsubpb.ListSnapshotsResponse{
+		NextPageToken: nextPageToken,
+		Snapshots:     snapshots,
+	}
+
+	mockSubscriber.err = nil
+	mockSubscriber.reqs = nil
+
+	mockSubscriber.resps = append(mockSubscriber.resps[:0], expectedResponse)
+
+	var formattedProject string = SubscriberProjectPath("[PROJECT]")
+	var request = &pubsubpb.ListSnapshotsRequest{
+		Project: formattedProject,
+	}
+
+	c, err := NewSubscriberClient(context.Background(), clientOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.ListSnapshots(context.Background(), request).Next()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := request, mockSubscriber.reqs[0]; !proto.Equal(want, got) {
+		t.Errorf("wrong request %q, want %q", got, want)
+	}
+
+	want := (interface{})(expectedResponse.Snapshots[0])
+	got := (interface{})(resp)
+	var ok bool
+
+	switch want := (want).(type) {
+	case proto.Message:
+		ok = proto.Equal(want, got.(proto.Message))
+	default:
+		ok = want == got
+	}
+	if !ok {
+		t.Errorf("wrong response %q, want %q)", got, want)
+	}
+}
+
+func TestSubscriberListSnapshotsError(t *testing.T) {
+	errCode := codes.Internal
+	mockSubscriber.err = grpc.Errorf(errCode, "test error")
+
+	var formattedProject string = SubscriberProjectPath("[PROJECT]")
+	var request = &pubsubpb.ListSnapshotsRequest{
+		Project: formattedProject,
+	}
+
+	c, err := NewSubscriberClient(context.Background(), clientOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.ListSnapshots(context.Background(), request).Next()
+
+	if c := grpc.Code(err); c != errCode {
+		t.Errorf("got error code %q, want %q", c, errCode)
+	}
+	_ = resp
+}
+func TestSubscriberCreateSnapshot(t *testing.T) {
+	var name2 string = "name2-1052831874"
+	var topic string = "topic110546223"
+	var expectedResponse = &pubsubpb.Snapshot{
+		Name:  name2,
+		Topic: topic,
+	}
+
+	mockSubscriber.err = nil
+	mockSubscriber.reqs = nil
+
+	mockSubscriber.resps = append(mockSubscriber.resps[:0], expectedResponse)
+
+	var formattedName string = SubscriberSnapshotPath("[PROJECT]", "[SNAPSHOT]")
+	var formattedSubscription string = SubscriberSubscriptionPath("[PROJECT]", "[SUBSCRIPTION]")
+	var request = &pubsubpb.CreateSnapshotRequest{
+		Name:         formattedName,
+		Subscription: formattedSubscription,
+	}
+
+	c, err := NewSubscriberClient(context.Background(), clientOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.CreateSnapshot(context.Background(), request)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := request, mockSubscriber.reqs[0]; !proto.Equal(want, got) {
+		t.Errorf("wrong request %q, want %q", got, want)
+	}
+
+	if want, got := expectedResponse, resp; !proto.Equal(want, got) {
+		t.Errorf("wrong response %q, want %q)", got, want)
+	}
+}
+
+func TestSubscriberCreateSnapshotError(t *testing.T) {
+	errCode := codes.Internal
+	mockSubscriber.err = grpc.Errorf(errCode, "test error")
+
+	var formattedName string = SubscriberSnapshotPath("[PROJECT]", "[SNAPSHOT]")
+	var formattedSubscription string = SubscriberSubscriptionPath("[PROJECT]", "[SUBSCRIPTION]")
+	var request = &pubsubpb.CreateSnapshotRequest{
+		Name:         formattedName,
+		Subscription: formattedSubscription,
+	}
+
+	c, err := NewSubscriberClient(context.Background(), clientOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.CreateSnapshot(context.Background(), request)
+
+	if c := grpc.Code(err); c != errCode {
+		t.Errorf("got error code %q, want %q", c, errCode)
+	}
+	_ = resp
+}
+func TestSubscriberDeleteSnapshot(t *testing.T) {
+	var expectedResponse *google_protobuf.Empty = &google_protobuf.Empty{}
+
+	mockSubscriber.err = nil
+	mockSubscriber.reqs = nil
+
+	mockSubscriber.resps = append(mockSubscriber.resps[:0], expectedResponse)
+
+	var formattedSnapshot string = SubscriberSnapshotPath("[PROJECT]", "[SNAPSHOT]")
+	var request = &pubsubpb.DeleteSnapshotRequest{
+		Snapshot: formattedSnapshot,
+	}
+
+	c, err := NewSubscriberClient(context.Background(), clientOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.DeleteSnapshot(context.Background(), request)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := request, mockSubscriber.reqs[0]; !proto.Equal(want, got) {
+		t.Errorf("wrong request %q, want %q", got, want)
+	}
+
+}
+
+func TestSubscriberDeleteSnapshotError(t *testing.T) {
+	errCode := codes.Internal
+	mockSubscriber.err = grpc.Errorf(errCode, "test error")
+
+	var formattedSnapshot string = SubscriberSnapshotPath("[PROJECT]", "[SNAPSHOT]")
+	var request = &pubsubpb.DeleteSnapshotRequest{
+		Snapshot: formattedSnapshot,
+	}
+
+	c, err := NewSubscriberClient(context.Background(), clientOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.DeleteSnapshot(context.Background(), request)
+
+	if c := grpc.Code(err); c != errCode {
+		t.Errorf("got error code %q, want %q", c, errCode)
+	}
+}
+func TestSubscriberSeek(t *testing.T) {
+	var expectedResponse *pubsubpb.SeekResponse = &pubsubpb.SeekResponse{}
+
+	mockSubscriber.err = nil
+	mockSubscriber.reqs = nil
+
+	mockSubscriber.resps = append(mockSubscriber.resps[:0], expectedResponse)
+
+	var formattedSubscription string = SubscriberSubscriptionPath("[PROJECT]", "[SUBSCRIPTION]")
+	var request = &pubsubpb.SeekRequest{
+		Subscription: formattedSubscription,
+	}
+
+	c, err := NewSubscriberClient(context.Background(), clientOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Seek(context.Background(), request)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, got := request, mockSubscriber.reqs[0]; !proto.Equal(want, got) {
+		t.Errorf("wrong request %q, want %q", got, want)
+	}
+
+	if want, got := expectedResponse, resp; !proto.Equal(want, got) {
+		t.Errorf("wrong response %q, want %q)", got, want)
+	}
+}
+
+func TestSubscriberSeekError(t *testing.T) {
+	errCode := codes.Internal
+	mockSubscriber.err = grpc.Errorf(errCode, "test error")
+
+	var formattedSubscription string = SubscriberSubscriptionPath("[PROJECT]", "[SUBSCRIPTION]")
+	var request = &pubsubpb.SeekRequest{
+		Subscription: formattedSubscription,
+	}
+
+	c, err := NewSubscriberClient(context.Background(), clientOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Seek(context.Background(), request)
+
+	if c := grpc.Code(err); c != errCode {
+		t.Errorf("got error code %q, want %q", c, errCode)
+	}
+	_ = resp
+}
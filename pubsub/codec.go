@@ -0,0 +1,190 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+)
+
+// ContentTypeAttr is the Message attribute which holds the content type of the
+// Message's Data. It is set by Topic.PublishEncoded, and read by
+// Message.Decode.
+const ContentTypeAttr = "pubsub_content_type"
+
+// Content types of the built-in Codecs.
+const (
+	ContentTypeJSON  = "application/json"
+	ContentTypeProto = "application/x-protobuf"
+)
+
+// ContentTypeAvro is the content type of Messages encoded in the Avro binary
+// format. This package has no Avro Codec; see NewCodec for how to make one.
+const ContentTypeAvro = "avro/binary"
+
+// A Codec encodes values into Message data, and decodes them again.
+type Codec interface {
+	// ContentType returns the value of the ContentTypeAttr attribute of
+	// Messages encoded by the Codec.
+	ContentType() string
+
+	// Marshal encodes v.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into v.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec encodes values with encoding/json.
+	JSONCodec Codec = jsonCodec{}
+
+	// ProtoCodec encodes values, which must implement proto.Message, in the
+	// protocol buffer binary format.
+	ProtoCodec Codec = protoCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                        { return ContentTypeJSON }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type protoCodec struct{}
+
+func (protoCodec) ContentType() string { return ContentTypeProto }
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	pm, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("pubsub: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(pm)
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	pm, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("pubsub: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, pm)
+}
+
+// NewCodec returns a Codec with the given content type, which encodes and
+// decodes values with marshal and unmarshal. It adapts encodings which this
+// package does not implement, such as Avro (with ContentTypeAvro), whose
+// functions would come from an Avro library and typically close over the
+// writer's schema. Call
+// RegisterCodec with the result so that Message.Decode can decode Messages
+// with its content type.
+func NewCodec(contentType string, marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, v interface{}) error) Codec {
+	return &funcCodec{
+		contentType: contentType,
+		marshal:     marshal,
+		unmarshal:   unmarshal,
+	}
+}
+
+type funcCodec struct {
+	contentType string
+	marshal     func(v interface{}) ([]byte, error)
+	unmarshal   func(data []byte, v interface{}) error
+}
+
+func (c *funcCodec) ContentType() string                        { return c.contentType }
+func (c *funcCodec) Marshal(v interface{}) ([]byte, error)      { return c.marshal(v) }
+func (c *funcCodec) Unmarshal(data []byte, v interface{}) error { return c.unmarshal(data, v) }
+
+var (
+	codecsMu sync.RWMutex
+	// key: content type.
+	codecs = map[string]Codec{
+		ContentTypeJSON:  JSONCodec,
+		ContentTypeProto: ProtoCodec,
+	}
+)
+
+// RegisterCodec makes c available to Message.Decode for Messages with its
+// content type, replacing any Codec previously registered for that type.
+// JSONCodec and ProtoCodec are registered by default.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	codecs[c.ContentType()] = c
+}
+
+func lookupCodec(contentType string) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	return codecs[contentType]
+}
+
+// Decode decodes m's Data into v, using the Codec registered for the content
+// type in m's ContentTypeAttr attribute.
+func (m *Message) Decode(v interface{}) error {
+	ct, ok := m.Attributes[ContentTypeAttr]
+	if !ok {
+		return errors.New("pubsub: message has no content type attribute")
+	}
+	c := lookupCodec(ct)
+	if c == nil {
+		return fmt.Errorf("pubsub: no codec registered for content type %q", ct)
+	}
+	return c.Unmarshal(m.Data, v)
+}
+
+// PublishEncoded encodes v with c, and publishes it to the topic as a single
+// Message with the given attributes and the content type of c. If the topic
+// has a Validate function, v is validated first, and is not published if it
+// is invalid. PublishEncoded returns the server-assigned ID of the Message.
+func (t *Topic) PublishEncoded(ctx context.Context, c Codec, v interface{}, attrs map[string]string) (string, error) {
+	if t.Validate != nil {
+		if err := t.Validate(v); err != nil {
+			return "", err
+		}
+	}
+	data, err := c.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	a := make(map[string]string, len(attrs)+1)
+	for k, v := range attrs {
+		a[k] = v
+	}
+	a[ContentTypeAttr] = c.ContentType()
+	ids, err := t.Publish(ctx, &Message{Data: data, Attributes: a})
+	if err != nil {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// PublishJSON publishes v encoded as JSON. See PublishEncoded.
+func (t *Topic) PublishJSON(ctx context.Context, v interface{}, attrs map[string]string) (string, error) {
+	return t.PublishEncoded(ctx, JSONCodec, v, attrs)
+}
+
+// PublishProto publishes pm encoded in the protocol buffer binary format. See
+// PublishEncoded.
+func (t *Topic) PublishProto(ctx context.Context, pm proto.Message, attrs map[string]string) (string, error) {
+	return t.PublishEncoded(ctx, ProtoCodec, pm, attrs)
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	pb "google.golang.org/genproto/googleapis/pubsub/v1"
+)
+
+func TestPublishAndDecode(t *testing.T) {
+	ctx := context.Background()
+	s := &orderingService{}
+	topic := &Topic{s: s, name: "projects/p/topics/t"}
+
+	type record struct {
+		Name  string
+		Count int
+	}
+	if _, err := topic.PublishJSON(ctx, record{"a", 1}, map[string]string{"k": "v"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := topic.PublishProto(ctx, &pb.Topic{Name: "b"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(s.published), 2; got != want {
+		t.Fatalf("got %d publish calls, want %d", got, want)
+	}
+
+	m := s.published[0][0]
+	wantAttrs := map[string]string{"k": "v", ContentTypeAttr: ContentTypeJSON}
+	if !reflect.DeepEqual(m.Attributes, wantAttrs) {
+		t.Errorf("JSON attributes: got %v, want %v", m.Attributes, wantAttrs)
+	}
+	var r record
+	if err := m.Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if want := (record{"a", 1}); r != want {
+		t.Errorf("decoded JSON: got %+v, want %+v", r, want)
+	}
+
+	m = s.published[1][0]
+	if got := m.Attributes[ContentTypeAttr]; got != ContentTypeProto {
+		t.Errorf("proto content type: got %q, want %q", got, ContentTypeProto)
+	}
+	var tp pb.Topic
+	if err := m.Decode(&tp); err != nil {
+		t.Fatal(err)
+	}
+	if want := (&pb.Topic{Name: "b"}); !proto.Equal(&tp, want) {
+		t.Errorf("decoded proto: got %v, want %v", &tp, want)
+	}
+	if err := m.Decode(&r); err == nil {
+		t.Error("decoding proto into non-proto value: got nil, want error")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var v interface{}
+	if err := (&Message{Data: []byte("{}")}).Decode(&v); err == nil {
+		t.Error("no content type: got nil, want error")
+	}
+	m := &Message{Attributes: map[string]string{ContentTypeAttr: "text/unknown"}}
+	if err := m.Decode(&v); err == nil {
+		t.Error("unregistered content type: got nil, want error")
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	// A stand-in for an Avro library.
+	c := NewCodec(ContentTypeAvro,
+		func(v interface{}) ([]byte, error) { return []byte(v.(string)), nil },
+		func(data []byte, v interface{}) error {
+			*v.(*string) = string(data)
+			return nil
+		})
+	RegisterCodec(c)
+	defer func() {
+		codecsMu.Lock()
+		delete(codecs, ContentTypeAvro)
+		codecsMu.Unlock()
+	}()
+
+	s := &orderingService{}
+	topic := &Topic{s: s, name: "projects/p/topics/t"}
+	if _, err := topic.PublishEncoded(context.Background(), c, "x", nil); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := s.published[0][0].Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got != "x" {
+		t.Errorf("got %q, want %q", got, "x")
+	}
+}
+
+func TestPublishValidate(t *testing.T) {
+	s := &orderingService{}
+	invalid := errors.New("invalid")
+	topic := &Topic{
+		s:    s,
+		name: "projects/p/topics/t",
+		Validate: func(v interface{}) error {
+			if v.(int) < 0 {
+				return invalid
+			}
+			return nil
+		},
+	}
+	ctx := context.Background()
+	if _, err := topic.PublishJSON(ctx, -1, nil); err != invalid {
+		t.Errorf("got %v, want %v", err, invalid)
+	}
+	if _, err := topic.PublishJSON(ctx, 1, nil); err != nil {
+		t.Errorf("publishing valid value: %v", err)
+	}
+	if got, want := len(s.published), 1; got != want {
+		t.Errorf("got %d publish calls, want %d", got, want)
+	}
+}
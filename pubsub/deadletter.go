@@ -0,0 +1,120 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Attributes added to Messages which are republished to a dead-letter topic.
+// See DeadLetter.
+const (
+	// DeadLetterSubscriptionAttr holds the fully qualified name of the
+	// subscription from which the Message was dead-lettered.
+	DeadLetterSubscriptionAttr = "pubsub_dead_letter_subscription"
+
+	// DeadLetterMessageIDAttr holds the ID of the original Message.
+	DeadLetterMessageIDAttr = "pubsub_dead_letter_message_id"
+
+	// DeadLetterPublishTimeAttr holds the publish time of the original
+	// Message, in RFC 3339 format.
+	DeadLetterPublishTimeAttr = "pubsub_dead_letter_publish_time"
+
+	// DeadLetterDeliveryAttemptsAttr holds the number of times the original
+	// Message was delivered before it was dead-lettered.
+	DeadLetterDeliveryAttemptsAttr = "pubsub_dead_letter_delivery_attempts"
+)
+
+// Limits on the delivery counts kept by a deliveryTracker. A nacked Message
+// is usually redelivered promptly, but one that is redelivered to another
+// client, or whose deadline expires, may never be seen again, so counts which
+// are not updated are eventually discarded.
+const (
+	// maxTrackedDeliveries is the maximum number of counts kept. The
+	// least recently delivered Messages are forgotten first.
+	maxTrackedDeliveries = 100000
+
+	// deliveryRetention is how long a count is kept after the
+	// MessageIterator stops extending the deadline of its last delivery.
+	deliveryRetention = time.Hour
+)
+
+// deliveryTracker counts the number of times each Message has been delivered.
+// Messages are tracked by ID, since the ack ID changes on each delivery.
+type deliveryTracker struct {
+	mu sync.Mutex
+	// Values are the number of deliveries so far, as ints.
+	counts *lruCache
+}
+
+// newDeliveryTracker returns a deliveryTracker which keeps at most maxSize
+// counts, each for ttl after the last delivery of its Message.
+func newDeliveryTracker(maxSize int, ttl time.Duration) *deliveryTracker {
+	return &deliveryTracker{counts: newLRUCache(maxSize, ttl)}
+}
+
+// delivered records a delivery of the Message with the given ID, and returns
+// the number of times it has been delivered, including this one.
+func (d *deliveryTracker) delivered(msgID string) int {
+	if msgID == "" {
+		return 1
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := 1
+	if v, ok := d.counts.get(msgID); ok {
+		n += v.(int)
+	}
+	d.counts.put(msgID, n)
+	return n
+}
+
+// forget stops tracking the Message with the given ID.
+func (d *deliveryTracker) forget(msgID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.counts.delete(msgID)
+}
+
+// forgetAll stops tracking all Messages.
+func (d *deliveryTracker) forgetAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.counts.clear()
+}
+
+// deadLetter publishes a copy of m, annotated with the reason for its failure,
+// to the dead-letter topic.
+func (it *MessageIterator) deadLetter(m *Message) error {
+	attrs := make(map[string]string, len(m.Attributes)+4)
+	for k, v := range m.Attributes {
+		attrs[k] = v
+	}
+	attrs[DeadLetterSubscriptionAttr] = it.subName
+	attrs[DeadLetterMessageIDAttr] = m.ID
+	attrs[DeadLetterPublishTimeAttr] = m.PublishTime.Format(time.RFC3339Nano)
+	attrs[DeadLetterDeliveryAttemptsAttr] = strconv.Itoa(m.DeliveryAttempt)
+
+	_, err := it.dl.topic.Publish(it.ctx, &Message{
+		Data:       m.Data,
+		Attributes: attrs,
+	})
+	return err
+}
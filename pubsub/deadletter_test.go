@@ -0,0 +1,171 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// deadLetterService records the messages that are published, acked and nacked.
+type deadLetterService struct {
+	fetcherService
+	publishErr error
+
+	mu        sync.Mutex
+	published []*Message
+	acked     []string
+	nacked    []string
+}
+
+func (s *deadLetterService) publishMessages(ctx context.Context, topicName string, msgs []*Message) ([]string, error) {
+	if s.publishErr != nil {
+		return nil, s.publishErr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.published = append(s.published, msgs...)
+	return make([]string, len(msgs)), nil
+}
+
+func (s *deadLetterService) acknowledge(ctx context.Context, subName string, ackIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acked = append(s.acked, ackIDs...)
+	return nil
+}
+
+func (s *deadLetterService) modifyAckDeadline(ctx context.Context, subName string, deadline time.Duration, ackIDs []string) error {
+	if deadline != 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nacked = append(s.nacked, ackIDs...)
+	return nil
+}
+
+func (s *deadLetterService) splitAckIDs(ids []string) ([]string, []string) {
+	return ids, nil
+}
+
+func TestDeadLetter(t *testing.T) {
+	pubTime := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, test := range []struct {
+		publishErr          error
+		wantAcked, wantNack []string
+		wantPublished       int
+	}{
+		{nil, []string{"a2"}, []string{"a1"}, 1},
+		{errors.New("publish failed"), nil, []string{"a1", "a2"}, 0},
+	} {
+		s := &deadLetterService{
+			fetcherService: fetcherService{
+				results: []fetchResult{
+					{msgs: []*Message{{ackID: "a1", ID: "m", Data: []byte("x"), PublishTime: pubTime}}},
+					{msgs: []*Message{{ackID: "a2", ID: "m", Data: []byte("x"), PublishTime: pubTime, Attributes: map[string]string{"k": "v"}}}},
+				},
+			},
+			publishErr: test.publishErr,
+		}
+		dlTopic := &Topic{s: s, name: "projects/p/topics/dead"}
+		it := newMessageIterator(context.Background(), s, "projects/p/subscriptions/sub", &pullOptions{
+			ackDeadline:  10 * time.Second,
+			maxExtension: time.Hour,
+			maxPrefetch:  1,
+			deadLetter:   &deadLetter{topic: dlTopic, maxAttempts: 2},
+		})
+		for i := 1; i <= 2; i++ {
+			m, err := it.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.DeliveryAttempt != i {
+				t.Errorf("DeliveryAttempt: got %d, want %d", m.DeliveryAttempt, i)
+			}
+			m.Done(false)
+		}
+		it.Stop()
+
+		if !reflect.DeepEqual(s.acked, test.wantAcked) {
+			t.Errorf("publishErr=%v: acked %v, want %v", test.publishErr, s.acked, test.wantAcked)
+		}
+		if !reflect.DeepEqual(s.nacked, test.wantNack) {
+			t.Errorf("publishErr=%v: nacked %v, want %v", test.publishErr, s.nacked, test.wantNack)
+		}
+		if len(s.published) != test.wantPublished {
+			t.Fatalf("publishErr=%v: published %d messages, want %d", test.publishErr, len(s.published), test.wantPublished)
+		}
+		if test.wantPublished == 0 {
+			continue
+		}
+		wantAttrs := map[string]string{
+			"k":                            "v",
+			DeadLetterSubscriptionAttr:     "projects/p/subscriptions/sub",
+			DeadLetterMessageIDAttr:        "m",
+			DeadLetterPublishTimeAttr:      "2017-01-02T03:04:05Z",
+			DeadLetterDeliveryAttemptsAttr: "2",
+		}
+		if got := s.published[0].Attributes; !reflect.DeepEqual(got, wantAttrs) {
+			t.Errorf("dead-lettered attributes: got %v, want %v", got, wantAttrs)
+		}
+		if got := string(s.published[0].Data); got != "x" {
+			t.Errorf("dead-lettered data: got %q, want %q", got, "x")
+		}
+	}
+}
+
+func TestDeliveryTrackerBounds(t *testing.T) {
+	now := time.Unix(0, 0)
+	d := newDeliveryTracker(2, time.Minute)
+	d.counts.now = func() time.Time { return now }
+
+	for _, test := range []struct {
+		advance time.Duration
+		msgID   string
+		want    int
+	}{
+		{0, "a", 1},
+		{0, "a", 2},
+		{30 * time.Second, "a", 3}, // each delivery extends the count's lifetime
+		{50 * time.Second, "a", 4},
+		{time.Minute, "a", 1}, // expired
+		{0, "b", 1},
+		{0, "c", 1}, // evicts a, the least recently delivered
+		{0, "a", 1},
+		{0, "c", 2},
+	} {
+		now = now.Add(test.advance)
+		if got := d.delivered(test.msgID); got != test.want {
+			t.Errorf("at %v, %s: got %d deliveries, want %d", now.Sub(time.Unix(0, 0)), test.msgID, got, test.want)
+		}
+		if got := d.counts.len(); got > 2 {
+			t.Fatalf("got %d counts, want at most 2", got)
+		}
+	}
+
+	d.forgetAll()
+	if got := d.counts.len(); got != 0 {
+		t.Errorf("after forgetAll: got %d counts", got)
+	}
+	if got := d.delivered("c"); got != 1 {
+		t.Errorf("after forgetAll: got %d deliveries, want 1", got)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// A DedupStore records the IDs of Messages which have been processed, so that
+// redeliveries of them can be discarded. See the Dedup PullOption.
+//
+// A DedupStore may be shared by several MessageIterators, possibly in
+// different processes, and its methods may be called concurrently.
+type DedupStore interface {
+	// Seen reports whether the Message with the given ID has been recorded.
+	Seen(ctx context.Context, msgID string) (bool, error)
+
+	// Record records that the Message with the given ID has been processed.
+	Record(ctx context.Context, msgID string) error
+}
+
+type dedup struct {
+	store DedupStore
+}
+
+func (d dedup) setOptions(o *pullOptions) {
+	o.dedup = d.store
+}
+
+// Dedup returns a PullOption that discards redelivered Messages.
+//
+// Before a Message is returned from MessageIterator.Next, its ID is looked up
+// in store. If it is found, the Message is acknowledged instead of being
+// returned. The ID is recorded in store when Message.Done(true) is called.
+//
+// Messages are deduplicated only once they are done: a Message which is
+// redelivered while it is still being processed is returned again. If store
+// returns an error, the Message is returned as if it had not been seen.
+func Dedup(store DedupStore) PullOption {
+	return dedup{store: store}
+}
+
+// isDuplicate reports whether m has already been processed.
+func (it *MessageIterator) isDuplicate(m *Message) bool {
+	if m.ID == "" {
+		return false
+	}
+	// On error, prefer redelivery to dropping the Message.
+	seen, err := it.dedup.Seen(it.ctx, m.ID)
+	return err == nil && seen
+}
+
+// memoryDedupStore is a DedupStore which holds IDs in memory, evicting the
+// least recently recorded ones when full.
+type memoryDedupStore struct {
+	mu  sync.Mutex
+	ids *lruCache
+}
+
+// NewMemoryDedupStore returns a DedupStore which holds Message IDs in memory.
+//
+// At most maxSize IDs are held; when the limit is reached, the least recently
+// recorded ID is discarded. IDs are discarded ttl after they are recorded. A
+// maxSize or ttl of zero or less means no limit.
+//
+// The store is local to the process, so it only deduplicates Messages
+// delivered to MessageIterators which share it.
+func NewMemoryDedupStore(maxSize int, ttl time.Duration) DedupStore {
+	return &memoryDedupStore{ids: newLRUCache(maxSize, ttl)}
+}
+
+func (s *memoryDedupStore) Seen(ctx context.Context, msgID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.ids.get(msgID)
+	return ok, nil
+}
+
+func (s *memoryDedupStore) Record(ctx context.Context, msgID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ids.put(msgID, nil)
+	return nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestDedup(t *testing.T) {
+	s := &deadLetterService{
+		fetcherService: fetcherService{
+			results: []fetchResult{
+				{msgs: []*Message{{ackID: "a1", ID: "m1"}}},
+				{msgs: []*Message{{ackID: "a2", ID: "m1"}}},
+				{msgs: []*Message{{ackID: "a3", ID: "m2"}}},
+			},
+		},
+	}
+	store := NewMemoryDedupStore(10, time.Hour)
+	it := newMessageIterator(context.Background(), s, "projects/p/subscriptions/sub", &pullOptions{
+		ackDeadline:  10 * time.Second,
+		maxExtension: time.Hour,
+		maxPrefetch:  1,
+		dedup:        store,
+	})
+
+	m, err := it.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ackID != "a1" {
+		t.Fatalf("got %q, want a1", m.ackID)
+	}
+	m.Done(true)
+
+	// The redelivery of m1 is skipped.
+	m, err = it.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ackID != "a3" {
+		t.Fatalf("got %q, want a3", m.ackID)
+	}
+	m.Done(false)
+	it.Stop()
+
+	sort.Strings(s.acked)
+	if want := []string{"a1", "a2"}; !reflect.DeepEqual(s.acked, want) {
+		t.Errorf("acked %v, want %v", s.acked, want)
+	}
+	if want := []string{"a3"}; !reflect.DeepEqual(s.nacked, want) {
+		t.Errorf("nacked %v, want %v", s.nacked, want)
+	}
+	// A nacked Message is not recorded.
+	if seen, _ := store.Seen(context.Background(), "m2"); seen {
+		t.Error("nacked message m2 was recorded")
+	}
+}
+
+func TestMemoryDedupStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryDedupStore(2, time.Minute).(*memoryDedupStore)
+	s.ids.now = func() time.Time { return now }
+
+	seen := func(id string) bool {
+		ok, err := s.Seen(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	s.Record(ctx, "a")
+	s.Record(ctx, "b")
+	if !seen("a") || !seen("b") {
+		t.Fatal("recorded IDs not seen")
+	}
+	if seen("c") {
+		t.Error("unrecorded ID seen")
+	}
+
+	// Recording a third ID evicts the least recently recorded one.
+	s.Record(ctx, "a")
+	s.Record(ctx, "c")
+	if seen("b") {
+		t.Error("b was not evicted")
+	}
+	if !seen("a") || !seen("c") {
+		t.Error("a or c was evicted")
+	}
+
+	// IDs expire after the TTL.
+	now = now.Add(time.Minute)
+	if seen("a") || seen("c") {
+		t.Error("expired IDs seen")
+	}
+	if got := s.ids.len(); got != 0 {
+		t.Errorf("got %d entries after expiry, want 0", got)
+	}
+}
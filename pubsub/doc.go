@@ -79,6 +79,40 @@ below.
 Note: It is possible for Messages to be redelivered, even if Message.Done has
 been called. Client code must be robust to multiple deliveries of messages.
 
+Encoding Messages
+
+Values can be published with a standard encoding recorded in the message's
+ContentTypeAttr attribute, and decoded by the receiver:
+
+ id, err := topic.PublishJSON(ctx, order, nil)
+ ...
+ err = msg.Decode(&order)
+
+PublishProto works the same way. Other encodings, such as Avro, can be used
+by registering a Codec for them; NewCodec adapts the functions of a library
+which implements the encoding. See the NewCodec example for an Avro Codec.
+
+Ordering
+
+Messages which must be processed in sequence can be given the same OrderingKey.
+Set Topic.EnableMessageOrdering to publish them in order, and pass the
+OrderedDelivery option to Subscription.Pull to receive each one only after
+Message.Done has been called on its predecessor. Ordering is implemented by
+this package: the key is published in the OrderingKeyAttr attribute.
+
+Replaying Messages
+
+A subscription can be rewound so that previously acknowledged messages are
+delivered again. Seek to a point in time:
+
+ err := sub.SeekToTime(ctx, time.Now().Add(-time.Hour))
+
+or capture the state of a subscription in a snapshot, and later seek back to it:
+
+ snap, err := pubsubClient.CreateSnapshot(ctx, "snapshot-name", sub)
+ ...
+ err = sub.SeekToSnapshot(ctx, snap.Snapshot)
+
 Deadlines
 
 The default pubsub deadlines are suitable for most use cases, but may be
@@ -16,6 +16,8 @@ package pubsub_test
 
 import (
 	"fmt"
+	"log"
+	"net/http"
 	"time"
 
 	"cloud.google.com/go/pubsub"
@@ -119,6 +121,51 @@ func ExampleTopic_Publish() {
 	fmt.Printf("Published a message with a message ID: %s\n", msgIDs[0])
 }
 
+func ExampleTopic_Publish_ordering() {
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+
+	topic := client.Topic("topicName")
+	topic.EnableMessageOrdering = true
+	_, err = topic.Publish(ctx, &pubsub.Message{
+		Data:        []byte("account created"),
+		OrderingKey: "account-1234",
+	})
+	if _, ok := err.(pubsub.ErrPublishingPaused); ok {
+		// An earlier message for this key failed to publish. Resume
+		// publishing for the key, then republish the failed messages.
+		topic.ResumePublish("account-1234")
+	} else if err != nil {
+		// TODO: Handle error.
+	}
+}
+
+func ExampleNewCodec() {
+	// These would come from an Avro library, and encode values with the
+	// writer's schema.
+	var (
+		avroMarshal   func(v interface{}) ([]byte, error)
+		avroUnmarshal func(data []byte, v interface{}) error
+	)
+	avroCodec := pubsub.NewCodec(pubsub.ContentTypeAvro, avroMarshal, avroUnmarshal)
+	// Let Message.Decode decode Avro messages.
+	pubsub.RegisterCodec(avroCodec)
+
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	topic := client.Topic("topicName")
+	var order interface{} // TODO: Set to a value of the schema's type.
+	if _, err := topic.PublishEncoded(ctx, avroCodec, order, nil); err != nil {
+		// TODO: Handle error.
+	}
+}
+
 func ExampleTopic_Subscriptions() {
 	ctx := context.Background()
 	client, err := pubsub.NewClient(ctx, "project-id")
@@ -215,6 +262,23 @@ func ExampleSubscription_Pull_options() {
 	defer it.Stop()
 }
 
+func ExampleSubscription_Pull_deadLetter() {
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	sub := client.Subscription("subName")
+	// Messages which are nacked five times are moved to the "dead-letters"
+	// topic, instead of being redelivered forever.
+	it, err := sub.Pull(ctx, pubsub.DeadLetter(client.Topic("dead-letters"), 5))
+	if err != nil {
+		// TODO: Handle error.
+	}
+	// Ensure that the iterator is closed down cleanly.
+	defer it.Stop()
+}
+
 func ExampleSubscription_ModifyPushConfig() {
 	ctx := context.Background()
 	client, err := pubsub.NewClient(ctx, "project-id")
@@ -297,3 +361,170 @@ func ExampleMessageIterator_Stop_goroutine() *pubsub.MessageIterator {
 	}()
 	return it
 }
+
+func ExampleClient_CreateSnapshot() {
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	sub := client.Subscription("subName")
+	snapConfig, err := client.CreateSnapshot(ctx, "snapshotName", sub)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	_ = snapConfig // TODO: use the snapshot.
+}
+
+func ExampleClient_Snapshots() {
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	// List all snapshots for the project.
+	iter := client.Snapshots(ctx)
+	for {
+		snapConfig, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			// TODO: Handle error.
+		}
+		_ = snapConfig // TODO: use the snapshot.
+	}
+}
+
+func ExampleSubscription_SeekToTime() {
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	sub := client.Subscription("subName")
+	// Redeliver all messages published in the last hour.
+	if err := sub.SeekToTime(ctx, time.Now().Add(-time.Hour)); err != nil {
+		// TODO: Handle error.
+	}
+}
+
+func ExampleSubscription_SeekToSnapshot() {
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	sub := client.Subscription("subName")
+	snap := client.Snapshot("snapshotName")
+	if err := sub.SeekToSnapshot(ctx, snap); err != nil {
+		// TODO: Handle error.
+	}
+}
+
+func ExampleNewPushHandler() {
+	h := pubsub.NewPushHandler(func(ctx context.Context, subscription string, m *pubsub.Message) error {
+		log.Printf("message %s from %s: %q", m.ID, subscription, m.Data)
+		// Returning an error causes the message to be redelivered.
+		return nil
+	})
+	// Configure the push subscription with the endpoint
+	// "https://example.com/push?token=secret".
+	h.Token = "secret"
+	http.Handle("/push", h)
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
+
+func ExampleTopic_PublishJSON() {
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	type order struct {
+		ID       string
+		Quantity int
+	}
+	topic := client.Topic("topicName")
+	// Reject invalid values before they are published.
+	topic.Validate = func(v interface{}) error {
+		if o, ok := v.(order); ok && o.Quantity <= 0 {
+			return fmt.Errorf("invalid quantity %d", o.Quantity)
+		}
+		return nil
+	}
+	id, err := topic.PublishJSON(ctx, order{ID: "o1", Quantity: 3}, nil)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	fmt.Printf("Published a message with a message ID: %s\n", id)
+}
+
+func ExampleMessage_Decode() {
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	it, err := client.Subscription("subName").Pull(ctx)
+	if err != nil {
+		// TODO: Handle error.
+	}
+	defer it.Stop()
+	m, err := it.Next()
+	if err != nil {
+		// TODO: Handle error.
+	}
+	var order struct {
+		ID       string
+		Quantity int
+	}
+	// Decode uses the codec for the message's content type attribute.
+	if err := m.Decode(&order); err != nil {
+		// TODO: Handle error.
+	}
+	fmt.Println(order.ID, order.Quantity)
+	m.Done(true)
+}
+
+func ExampleSubscription_Pull_dedup() {
+	ctx := context.Background()
+	client, err := pubsub.NewClient(ctx, "project-id")
+	if err != nil {
+		// TODO: Handle error.
+	}
+	sub := client.Subscription("subName")
+	// Remember the IDs of up to 100000 processed messages for an hour, and
+	// skip redeliveries of them.
+	store := pubsub.NewMemoryDedupStore(100000, time.Hour)
+	it, err := sub.Pull(ctx, pubsub.Dedup(store))
+	if err != nil {
+		// TODO: Handle error.
+	}
+	defer it.Stop()
+	for {
+		m, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			// TODO: Handle error.
+			break
+		}
+		fmt.Println(string(m.Data))
+		m.Done(true)
+	}
+}
+
+func ExampleWithStats() {
+	ctx := context.Background()
+	st := pubsub.NewMemoryStats()
+	client, err := pubsub.NewClient(ctx, "project-id", pubsub.WithStats(st))
+	if err != nil {
+		// TODO: Handle error.
+	}
+	_ = client // TODO: Use client.
+	acks := st.Calls(pubsub.OpAck)
+	fmt.Printf("%d ack requests, %d failed\n", acks.Calls, acks.Errors)
+	fmt.Printf("%d messages outstanding\n", st.Outstanding())
+}
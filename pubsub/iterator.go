@@ -34,6 +34,19 @@ type MessageIterator struct {
 	nacker *bundler.Bundler
 	puller *puller
 
+	// dl and deliveries are only set if the DeadLetter option was supplied.
+	dl         *deadLetter
+	deliveries *deliveryTracker
+
+	// ord is only set if the OrderedDelivery option was supplied.
+	ord *orderer
+
+	// dedup is only set if the Dedup option was supplied.
+	dedup DedupStore
+
+	ctx     context.Context
+	subName string
+
 	// mu ensures that cleanup only happens once, and concurrent Stop
 	// invocations block until cleanup completes.
 	mu sync.Mutex
@@ -64,6 +77,7 @@ func newMessageIterator(ctx context.Context, s service, subName string, po *pull
 		ExtensionTick: kaTicker.C,
 		Deadline:      po.ackDeadline,
 		MaxExtension:  po.maxExtension,
+		Stats:         statsOf(s),
 	}
 
 	ack := &acker{
@@ -88,37 +102,67 @@ func newMessageIterator(ctx context.Context, s service, subName string, po *pull
 
 	pull := newPuller(s, subName, ctx, po.maxPrefetch, ka.Add, ka.Remove)
 
+	var deliveries *deliveryTracker
+	if po.deadLetter != nil {
+		deliveries = newDeliveryTracker(maxTrackedDeliveries, po.maxExtension+deliveryRetention)
+	}
+
 	ka.Start()
 	ack.Start()
-	return &MessageIterator{
-		kaTicker:  kaTicker,
-		ackTicker: ackTicker,
-		ka:        ka,
-		acker:     ack,
-		nacker:    nacker,
-		puller:    pull,
-		closed:    make(chan struct{}),
+	it := &MessageIterator{
+		kaTicker:   kaTicker,
+		ackTicker:  ackTicker,
+		ka:         ka,
+		acker:      ack,
+		nacker:     nacker,
+		puller:     pull,
+		dl:         po.deadLetter,
+		deliveries: deliveries,
+		dedup:      po.dedup,
+		ctx:        ctx,
+		subName:    subName,
+		closed:     make(chan struct{}),
 	}
+	if po.ordered {
+		it.ord = newOrderer(ka.Remove)
+		it.ord.start(pull.Next, it.closed)
+	}
+	return it
 }
 
 // Next returns the next Message to be processed.  The caller must call
 // Message.Done when finished with it.
 // Once Stop has been called, calls to Next will return iterator.Done.
 func (it *MessageIterator) Next() (*Message, error) {
-	m, err := it.puller.Next()
+	for {
+		var m *Message
+		var err error
+		if it.ord != nil {
+			m, err = it.ord.Next(it.closed)
+		} else {
+			m, err = it.puller.Next()
+		}
+		if err != nil {
+			select {
+			// If Stop has been called, we return Done regardless the value of err.
+			case <-it.closed:
+				return nil, iterator.Done
+			default:
+				return nil, err
+			}
+		}
 
-	if err == nil {
 		m.it = it
+		if it.dedup != nil && it.isDuplicate(m) {
+			// Acknowledge the duplicate so that it is not redelivered again.
+			it.finish(m, true)
+			continue
+		}
+		if it.deliveries != nil {
+			m.DeliveryAttempt = it.deliveries.delivered(m.ID)
+		}
 		return m, nil
 	}
-
-	select {
-	// If Stop has been called, we return Done regardless the value of err.
-	case <-it.closed:
-		return nil, iterator.Done
-	default:
-		return nil, err
-	}
 }
 
 // Client code must call Stop on a MessageIterator when finished with it.
@@ -146,6 +190,11 @@ func (it *MessageIterator) Stop() {
 	// to it.ka.
 	it.puller.Stop()
 
+	// Abandon any messages which are being held back to preserve ordering.
+	if it.ord != nil {
+		it.ord.Stop()
+	}
+
 	// Start acking messages as they arrive, ignoring ackTicker.  This will
 	// result in it.ka.Stop, below, returning as soon as possible.
 	it.acker.FastMode()
@@ -161,15 +210,47 @@ func (it *MessageIterator) Stop() {
 	it.nacker.Stop()
 	it.kaTicker.Stop()
 	it.ackTicker.Stop()
+
+	// No more Messages will be delivered, so their counts are not needed.
+	if it.deliveries != nil {
+		it.deliveries.forgetAll()
+	}
+}
+
+func (it *MessageIterator) done(m *Message, ack bool) {
+	if !ack && it.dl != nil && m.DeliveryAttempt >= it.dl.maxAttempts {
+		// If the Message can't be republished, fall back to a nack so
+		// that it is redelivered and we try again.
+		ack = it.deadLetter(m) == nil
+	}
+	if ack && it.deliveries != nil {
+		it.deliveries.forget(m.ID)
+	}
+	if ack && it.dedup != nil && m.ID != "" {
+		// If recording fails, the Message may be processed again if it is
+		// redelivered, which is no worse than not deduplicating.
+		_ = it.dedup.Record(it.ctx, m.ID)
+	}
+	it.finish(m, ack)
 }
 
-func (it *MessageIterator) done(ackID string, ack bool) {
+// finish acks or nacks m, and releases any Messages held back behind it.
+func (it *MessageIterator) finish(m *Message, ack bool) {
 	if ack {
-		it.acker.Ack(ackID)
+		it.acker.Ack(m.ackID)
 		// There's no need to call it.ka.Remove here, as acker will
 		// call it via its Notify function.
 	} else {
-		it.ka.Remove(ackID)
-		_ = it.nacker.Add(ackID, len(ackID)) // ignore error; this is just an optimization
+		it.nack(m.ackID)
 	}
+	if it.ord != nil {
+		for _, held := range it.ord.Release(m, ack) {
+			it.nack(held.ackID)
+		}
+	}
+}
+
+func (it *MessageIterator) nack(ackID string) {
+	it.ka.Remove(ackID)
+	_ = it.nacker.Add(ackID, len(ackID)) // ignore error; this is just an optimization
 }
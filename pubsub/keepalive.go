@@ -31,6 +31,7 @@ type keepAlive struct {
 	ExtensionTick <-chan time.Time // ExtensionTick supplies the frequency with which to make extension requests.
 	Deadline      time.Duration    // How long to extend messages for each time they are extended. Should be greater than ExtensionTick frequency.
 	MaxExtension  time.Duration    // How long to keep extending each message's ack deadline before automatically removing it.
+	Stats         Stats            // Receives the number of outstanding and expired messages. Optional.
 
 	mu sync.Mutex
 	// key: ackID; value: time at which ack deadline extension should cease.
@@ -43,6 +44,9 @@ type keepAlive struct {
 // Start initiates the deadline extension loop.  Stop must be called once keepAlive is no longer needed.
 func (ka *keepAlive) Start() {
 	ka.items = make(map[string]time.Time)
+	if ka.Stats == nil {
+		ka.Stats = noopStats{}
+	}
 	ka.dr = drain{Drained: make(chan struct{})}
 	ka.wg.Add(1)
 	go func() {
@@ -65,6 +69,9 @@ func (ka *keepAlive) Start() {
 				for _, id := range expired {
 					ka.Remove(id)
 				}
+				if len(expired) > 0 {
+					ka.Stats.RecordExpired(len(expired))
+				}
 			}
 		}
 	}()
@@ -76,6 +83,9 @@ func (ka *keepAlive) Add(ackID string) {
 	ka.mu.Lock()
 	defer ka.mu.Unlock()
 
+	if _, ok := ka.items[ackID]; !ok {
+		ka.Stats.AddOutstanding(1)
+	}
 	ka.items[ackID] = time.Now().Add(ka.MaxExtension)
 	ka.dr.SetPending(true)
 }
@@ -87,6 +97,9 @@ func (ka *keepAlive) Remove(ackID string) {
 
 	// Note: If users NACKs a message after it has been removed due to
 	// expiring, Remove will be called twice with same ack id.  This is OK.
+	if _, ok := ka.items[ackID]; ok {
+		ka.Stats.AddOutstanding(-1)
+	}
 	delete(ka.items, ackID)
 	ka.dr.SetPending(len(ka.items) != 0)
 }
@@ -0,0 +1,119 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"container/list"
+	"time"
+)
+
+// lruCache maps message IDs to values. It holds at most maxSize entries,
+// evicting the least recently stored ones first, and discards each entry ttl
+// after it was last stored. A maxSize or ttl of zero or less means no limit.
+//
+// An lruCache is not safe for concurrent use.
+type lruCache struct {
+	maxSize int
+	ttl     time.Duration
+	now     func() time.Time
+
+	// lru holds *lruEntry values, most recently stored first.
+	lru *list.List
+	// key: message ID.
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	msgID   string
+	value   interface{}
+	expires time.Time
+}
+
+func newLRUCache(maxSize int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the value stored for msgID, and whether there is one which has
+// not expired.
+func (c *lruCache) get(msgID string) (interface{}, bool) {
+	e, ok := c.entries[msgID]
+	if !ok {
+		return nil, false
+	}
+	le := e.Value.(*lruEntry)
+	if c.expired(le) {
+		c.remove(e)
+		return nil, false
+	}
+	return le.value, true
+}
+
+// put stores value for msgID, making it the most recently stored entry.
+func (c *lruCache) put(msgID string, value interface{}) {
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = c.now().Add(c.ttl)
+	}
+	if e, ok := c.entries[msgID]; ok {
+		le := e.Value.(*lruEntry)
+		le.value, le.expires = value, expires
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[msgID] = c.lru.PushFront(&lruEntry{msgID: msgID, value: value, expires: expires})
+
+	// Evict expired entries from the back of the list, then the least
+	// recently stored ones if we are still over the limit.
+	for e := c.lru.Back(); e != nil && c.expired(e.Value.(*lruEntry)); e = c.lru.Back() {
+		c.remove(e)
+	}
+	for c.maxSize > 0 && c.lru.Len() > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+// delete removes the entry for msgID, if there is one.
+func (c *lruCache) delete(msgID string) {
+	if e, ok := c.entries[msgID]; ok {
+		c.remove(e)
+	}
+}
+
+// clear removes all entries.
+func (c *lruCache) clear() {
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// len returns the number of entries, including expired ones which have not
+// been evicted yet.
+func (c *lruCache) len() int {
+	return c.lru.Len()
+}
+
+func (c *lruCache) expired(le *lruEntry) bool {
+	return !le.expires.IsZero() && !c.now().Before(le.expires)
+}
+
+func (c *lruCache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*lruEntry).msgID)
+}
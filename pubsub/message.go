@@ -35,6 +35,13 @@ type Message struct {
 	// is labelled with.
 	Attributes map[string]string
 
+	// OrderingKey identifies related messages for which publish order should
+	// be respected. Messages with the same OrderingKey are published in
+	// sequence if Topic.EnableMessageOrdering is set, and are returned one
+	// at a time by a MessageIterator created with the OrderedDelivery option.
+	// It is carried in the OrderingKeyAttr attribute.
+	OrderingKey string
+
 	// ackID is the identifier to acknowledge this message.
 	ackID string
 
@@ -43,12 +50,47 @@ type Message struct {
 	// This field is read-only.
 	PublishTime time.Time
 
+	// DeliveryAttempt is the number of times this Message has been returned
+	// by the MessageIterator, including this time. It is only populated for
+	// Messages obtained from a MessageIterator created with the DeadLetter
+	// option, and is zero otherwise.
+	// This field is read-only.
+	DeliveryAttempt int
+
 	calledDone bool
 
 	// The iterator that created this Message.
 	it *MessageIterator
 }
 
+// OrderingKeyAttr is the attribute in which the OrderingKey of a Message is
+// published. Ordering is implemented by this package, not by the service,
+// whose API has no field for the key. The attribute is removed from the
+// Attributes of received Messages.
+const OrderingKeyAttr = "pubsub_ordering_key"
+
+// publishedAttributes returns the attributes with which to publish m.
+func publishedAttributes(m *Message) map[string]string {
+	if m.OrderingKey == "" {
+		return m.Attributes
+	}
+	attrs := make(map[string]string, len(m.Attributes)+1)
+	for k, v := range m.Attributes {
+		attrs[k] = v
+	}
+	attrs[OrderingKeyAttr] = m.OrderingKey
+	return attrs
+}
+
+// takeOrderingKey removes OrderingKeyAttr from attrs, and returns its value.
+func takeOrderingKey(attrs map[string]string) string {
+	key, ok := attrs[OrderingKeyAttr]
+	if ok {
+		delete(attrs, OrderingKeyAttr)
+	}
+	return key
+}
+
 func toMessage(resp *pb.ReceivedMessage) (*Message, error) {
 	if resp.Message == nil {
 		return &Message{ackID: resp.AckId}, nil
@@ -63,6 +105,7 @@ func toMessage(resp *pb.ReceivedMessage) (*Message, error) {
 		Data:        resp.Message.Data,
 		Attributes:  resp.Message.Attributes,
 		ID:          resp.Message.MessageId,
+		OrderingKey: takeOrderingKey(resp.Message.Attributes),
 		PublishTime: pubTime,
 	}, nil
 }
@@ -70,15 +113,16 @@ func toMessage(resp *pb.ReceivedMessage) (*Message, error) {
 // Done completes the processing of a Message that was returned from a MessageIterator.
 // ack indicates whether the message should be acknowledged.
 // Client code must call Done when finished for each Message returned by an iterator.
-// Done may only be called on Messages returned by a MessageIterator.
+// Done has no effect on other Messages, such as those passed to
+// PushHandler.Receive.
 // If message acknowledgement fails, the Message will be redelivered.
 // Calls to Done have no effect after the first call.
 //
 // See MessageIterator.Next for an example.
 func (m *Message) Done(ack bool) {
-	if m.calledDone {
+	if m.calledDone || m.it == nil {
 		return
 	}
 	m.calledDone = true
-	m.it.done(m.ackID, ack)
+	m.it.done(m, ack)
 }
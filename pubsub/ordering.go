@@ -0,0 +1,304 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"google.golang.org/api/iterator"
+)
+
+// ErrPublishingPaused is returned by Topic.Publish when publishing of
+// messages with OrderingKey has been paused by an earlier failure. Call
+// Topic.ResumePublish to resume publishing for the key.
+type ErrPublishingPaused struct {
+	OrderingKey string
+}
+
+func (e ErrPublishingPaused) Error() string {
+	return fmt.Sprintf("pubsub: publishing for ordering key %q is paused; call Topic.ResumePublish to resume", e.OrderingKey)
+}
+
+// orderingKeyState serializes the publishing of messages with a single
+// ordering key.
+type orderingKeyState struct {
+	// mu is held for the duration of each publish request that contains
+	// messages with this key.
+	mu sync.Mutex
+
+	// paused is set when a publish request fails, and cleared by
+	// Topic.ResumePublish.
+	paused bool
+}
+
+// orderingKeys returns the distinct ordering keys of msgs, sorted.
+func orderingKeys(msgs []*Message) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range msgs {
+		if m.OrderingKey != "" && !seen[m.OrderingKey] {
+			seen[m.OrderingKey] = true
+			keys = append(keys, m.OrderingKey)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// keyState returns the state for key, creating it if necessary.
+func (t *Topic) keyState(key string) *orderingKeyState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.keys == nil {
+		t.keys = make(map[string]*orderingKeyState)
+	}
+	ks, ok := t.keys[key]
+	if !ok {
+		ks = &orderingKeyState{}
+		t.keys[key] = ks
+	}
+	return ks
+}
+
+// publishOrdered publishes msgs after acquiring the publishing lock of each of
+// their ordering keys, so that at most one request containing a given key is
+// in flight at a time. If the request fails, every key in it is paused.
+func (t *Topic) publishOrdered(keys []string, publish func() ([]string, error)) ([]string, error) {
+	// Keys are sorted, so acquiring the locks in order can't deadlock.
+	states := make([]*orderingKeyState, len(keys))
+	for i, key := range keys {
+		states[i] = t.keyState(key)
+		states[i].mu.Lock()
+		defer states[i].mu.Unlock()
+	}
+	for i, ks := range states {
+		if ks.paused {
+			return nil, ErrPublishingPaused{OrderingKey: keys[i]}
+		}
+	}
+	ids, err := publish()
+	if err != nil {
+		for _, ks := range states {
+			ks.paused = true
+		}
+		return nil, err
+	}
+	return ids, nil
+}
+
+// ResumePublish resumes publishing of messages with the given ordering key,
+// after it was paused by a failed call to Publish. Messages with the key that
+// were not successfully published must be published again by the caller.
+func (t *Topic) ResumePublish(orderingKey string) {
+	ks := t.keyState(orderingKey)
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.paused = false
+}
+
+type orderedDelivery bool
+
+func (od orderedDelivery) setOptions(o *pullOptions) {
+	o.ordered = bool(od)
+}
+
+// OrderedDelivery returns a PullOption that causes Messages with the same
+// OrderingKey to be returned from MessageIterator.Next one at a time: a
+// Message is not returned until Done has been called on the previous Message
+// with the same key. Messages with different keys, and Messages without an
+// OrderingKey, are not held back.
+//
+// When a Message is nacked, the Messages with the same key that are waiting to
+// be returned are nacked as well, so that they are redelivered after it.
+func OrderedDelivery() PullOption {
+	return orderedDelivery(true)
+}
+
+// pullResult is a value returned by puller.Next.
+type pullResult struct {
+	m   *Message
+	err error
+}
+
+// orderer holds back Messages whose ordering key already has a Message
+// outstanding, and releases them as outstanding Messages are completed.
+type orderer struct {
+	// abandon should be called for each Message that was fetched but will
+	// never be returned by Next.
+	abandon func(ackID string)
+
+	// pulled carries the results of puller.Next from the pump goroutine.
+	pulled chan pullResult
+	// readyc is signalled when ready becomes non-empty.
+	readyc chan struct{}
+	wg     sync.WaitGroup
+
+	mu sync.Mutex
+	// busy contains the keys for which a Message has been returned by Next
+	// and not yet completed.
+	busy map[string]bool
+	// held contains the Messages waiting for their key to become free, in
+	// the order in which they were received.
+	held map[string][]*Message
+	// ready contains the Messages which have been released, and whose key
+	// has already been marked busy on their behalf.
+	ready   []*Message
+	stopped bool
+}
+
+func newOrderer(abandon func(ackID string)) *orderer {
+	return &orderer{
+		abandon: abandon,
+		pulled:  make(chan pullResult),
+		readyc:  make(chan struct{}, 1),
+		busy:    make(map[string]bool),
+		held:    make(map[string][]*Message),
+	}
+}
+
+// start launches a goroutine which passes the results of next to Next, until
+// closed is closed.
+func (o *orderer) start(next func() (*Message, error), closed <-chan struct{}) {
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		for {
+			select {
+			case <-closed:
+				return
+			default:
+			}
+			m, err := next()
+			select {
+			case o.pulled <- pullResult{m, err}:
+			case <-closed:
+				if m != nil {
+					o.abandon(m.ackID)
+				}
+				return
+			}
+		}
+	}()
+}
+
+// Next returns the next Message whose key is not busy.
+func (o *orderer) Next(closed <-chan struct{}) (*Message, error) {
+	for {
+		if m := o.takeReady(); m != nil {
+			return m, nil
+		}
+		select {
+		case <-o.readyc:
+		case r := <-o.pulled:
+			if r.err != nil {
+				return nil, r.err
+			}
+			if o.admit(r.m) {
+				return r.m, nil
+			}
+		case <-closed:
+			return nil, iterator.Done
+		}
+	}
+}
+
+func (o *orderer) takeReady() *Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.ready) == 0 {
+		return nil
+	}
+	m := o.ready[0]
+	o.ready = o.ready[1:]
+	return m
+}
+
+// admit reports whether m may be returned immediately. If not, m is held until
+// its key becomes free.
+func (o *orderer) admit(m *Message) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.stopped {
+		o.abandon(m.ackID)
+		return false
+	}
+	if m.OrderingKey == "" {
+		return true
+	}
+	if o.busy[m.OrderingKey] {
+		o.held[m.OrderingKey] = append(o.held[m.OrderingKey], m)
+		return false
+	}
+	o.busy[m.OrderingKey] = true
+	return true
+}
+
+// Release is called when Done has been called on m. If m was acked, the next
+// held Message with the same key is made ready. If m was nacked, the held
+// Messages with the same key are returned so that they can be nacked too.
+func (o *orderer) Release(m *Message, ack bool) (nack []*Message) {
+	if m.OrderingKey == "" {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	key := m.OrderingKey
+	q := o.held[key]
+	if !ack || len(q) == 0 {
+		delete(o.held, key)
+		delete(o.busy, key)
+		return q
+	}
+	if len(q) == 1 {
+		delete(o.held, key)
+	} else {
+		o.held[key] = q[1:]
+	}
+	o.ready = append(o.ready, q[0])
+	select {
+	case o.readyc <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Stop waits for the pump goroutine to exit, and abandons all Messages that
+// have not yet been returned. The channel passed to start must be closed
+// before Stop is called.
+func (o *orderer) Stop() {
+	o.wg.Wait()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.stopped = true
+	for key, q := range o.held {
+		for _, m := range q {
+			o.abandon(m.ackID)
+		}
+		delete(o.held, key)
+	}
+	for _, m := range o.ready {
+		o.abandon(m.ackID)
+	}
+	o.ready = nil
+}
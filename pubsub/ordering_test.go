@@ -0,0 +1,201 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"golang.org/x/net/context"
+	pb "google.golang.org/genproto/googleapis/pubsub/v1"
+)
+
+// orderingService serves a single batch of messages, and then blocks until
+// its context is done. It records the ack IDs that are nacked.
+type orderingService struct {
+	service
+	msgs []*Message
+
+	// publishErr, if set, is returned by the next call to publishMessages.
+	publishErr error
+	published  [][]*Message
+
+	mu     sync.Mutex
+	nacked []string
+}
+
+func (s *orderingService) fetchMessages(ctx context.Context, subName string, maxMessages int32) ([]*Message, error) {
+	if msgs := s.msgs; msgs != nil {
+		s.msgs = nil
+		return msgs, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (s *orderingService) publishMessages(ctx context.Context, topicName string, msgs []*Message) ([]string, error) {
+	if err := s.publishErr; err != nil {
+		s.publishErr = nil
+		return nil, err
+	}
+	s.published = append(s.published, msgs)
+	return make([]string, len(msgs)), nil
+}
+
+func (s *orderingService) modifyAckDeadline(ctx context.Context, subName string, deadline time.Duration, ackIDs []string) error {
+	if deadline == 0 {
+		s.mu.Lock()
+		s.nacked = append(s.nacked, ackIDs...)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *orderingService) acknowledge(ctx context.Context, subName string, ackIDs []string) error {
+	return nil
+}
+
+func (s *orderingService) splitAckIDs(ids []string) ([]string, []string) {
+	return ids, nil
+}
+
+func TestOrderedPublish(t *testing.T) {
+	ctx := context.Background()
+	s := &orderingService{}
+	topic := &Topic{s: s, name: "projects/p/topics/t"}
+
+	if _, err := topic.Publish(ctx, &Message{OrderingKey: "k1"}); err == nil {
+		t.Error("publishing with an ordering key without EnableMessageOrdering: got nil, want error")
+	}
+	topic.EnableMessageOrdering = true
+
+	// publishMessages clears publishErr when it returns it.
+	wantErr := errors.New("publish failed")
+	s.publishErr = wantErr
+	if _, err := topic.Publish(ctx, &Message{OrderingKey: "k1"}, &Message{OrderingKey: "k2"}); err != wantErr {
+		t.Fatalf("got %v, want %v", err, wantErr)
+	}
+	for _, key := range []string{"k1", "k2"} {
+		_, err := topic.Publish(ctx, &Message{OrderingKey: key})
+		if want := (ErrPublishingPaused{OrderingKey: key}); err != want {
+			t.Errorf("publishing to paused key %q: got %v, want %v", key, err, want)
+		}
+	}
+	// Other keys, and messages without a key, are unaffected.
+	if _, err := topic.Publish(ctx, &Message{OrderingKey: "k3"}, &Message{}); err != nil {
+		t.Errorf("publishing to unpaused key: %v", err)
+	}
+	topic.ResumePublish("k1")
+	if _, err := topic.Publish(ctx, &Message{OrderingKey: "k1"}); err != nil {
+		t.Errorf("publishing to resumed key: %v", err)
+	}
+	if got, want := len(s.published), 2; got != want {
+		t.Errorf("got %d successful publish calls, want %d", got, want)
+	}
+}
+
+func TestOrderedDelivery(t *testing.T) {
+	s := &orderingService{
+		msgs: []*Message{
+			{ackID: "a", OrderingKey: "k1"},
+			{ackID: "b", OrderingKey: "k1"},
+			{ackID: "c", OrderingKey: "k2"},
+			{ackID: "d", OrderingKey: "k1"},
+		},
+	}
+	it := newMessageIterator(context.Background(), s, "subname", &pullOptions{
+		ackDeadline:  10 * time.Second,
+		maxExtension: time.Hour,
+		maxPrefetch:  4,
+		ordered:      true,
+	})
+	defer it.Stop()
+
+	next := func() *Message {
+		m, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	a := next()
+	c := next()
+	if a.ackID != "a" || c.ackID != "c" {
+		t.Fatalf("got %q, %q; want a, c", a.ackID, c.ackID)
+	}
+
+	// b must not be returned until a is done.
+	got := make(chan *Message, 1)
+	go func() {
+		m, err := it.Next()
+		if err != nil {
+			t.Error(err)
+		}
+		got <- m
+	}()
+	select {
+	case m := <-got:
+		t.Fatalf("got message %q before the previous message with its key was done", m.ackID)
+	case <-time.After(50 * time.Millisecond):
+	}
+	a.Done(true)
+	b := <-got
+	if b.ackID != "b" {
+		t.Fatalf("got %q, want b", b.ackID)
+	}
+
+	// Nacking b nacks d too, so that they are redelivered in order.
+	b.Done(false)
+	c.Done(true)
+	it.Stop()
+	sort.Strings(s.nacked)
+	if want := []string{"b", "d"}; !reflect.DeepEqual(s.nacked, want) {
+		t.Errorf("nacked %v, want %v", s.nacked, want)
+	}
+}
+
+func TestOrderingKeyAttr(t *testing.T) {
+	m := &Message{Attributes: map[string]string{"k": "v"}, OrderingKey: "key"}
+	attrs := publishedAttributes(m)
+	if want := map[string]string{"k": "v", OrderingKeyAttr: "key"}; !reflect.DeepEqual(attrs, want) {
+		t.Errorf("published attributes: got %v, want %v", attrs, want)
+	}
+	if _, ok := m.Attributes[OrderingKeyAttr]; ok {
+		t.Error("publishedAttributes modified the Message's Attributes")
+	}
+	if got := publishedAttributes(&Message{Attributes: m.Attributes}); !reflect.DeepEqual(got, m.Attributes) {
+		t.Errorf("published attributes without a key: got %v, want %v", got, m.Attributes)
+	}
+
+	pubTime, err := ptypes.TimestampProto(time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := toMessage(&pb.ReceivedMessage{
+		AckId:   "a",
+		Message: &pb.PubsubMessage{Attributes: attrs, PublishTime: pubTime},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.OrderingKey != "key" || !reflect.DeepEqual(got.Attributes, map[string]string{"k": "v"}) {
+		t.Errorf("received: got key %q and attributes %v", got.OrderingKey, got.Attributes)
+	}
+}
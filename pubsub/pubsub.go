@@ -61,7 +61,14 @@ func NewClient(ctx context.Context, projectID string, opts ...option.ClientOptio
 	} else {
 		o = []option.ClientOption{option.WithUserAgent(userAgent)}
 	}
-	o = append(o, opts...)
+	var st Stats
+	for _, opt := range opts {
+		if so, ok := opt.(statsOption); ok {
+			st = so.stats
+			continue
+		}
+		o = append(o, opt)
+	}
 	s, err := newPubSubService(ctx, o)
 	if err != nil {
 		return nil, fmt.Errorf("constructing pubsub client: %v", err)
@@ -69,7 +76,7 @@ func NewClient(ctx context.Context, projectID string, opts ...option.ClientOptio
 
 	c := &Client{
 		projectID: projectID,
-		s:         s,
+		s:         withStats(s, st),
 	}
 
 	return c, nil
@@ -0,0 +1,105 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// maxPushBodySize bounds the size of a push request. It allows for the
+// maximum message size after base64 encoding, plus attributes.
+const maxPushBodySize = 16 << 20
+
+// PushHandler is an http.Handler which receives Messages from a push
+// subscription. See PushConfig for how to configure a subscription to push
+// Messages to an endpoint.
+//
+// Each request is decoded into a Message and passed to Receive. If Receive
+// returns nil, the handler responds with a success status, and the Message is
+// acknowledged. Otherwise the handler responds with an error status, and the
+// Message will be redelivered.
+type PushHandler struct {
+	// Receive is called with the name of the subscription from which the
+	// Message was pushed, in the format "projects/<projid>/subscriptions/<name>",
+	// and the Message. Receive may be called concurrently from multiple
+	// goroutines.
+	//
+	// The return value of Receive determines whether m is acknowledged.
+	// Calling Message.Done on m has no effect.
+	Receive func(ctx context.Context, subscription string, m *Message) error
+
+	// Token, if non-empty, is a shared secret which must be supplied in the
+	// "token" query parameter of each request. Requests without it are
+	// rejected. Include the token in the PushConfig.Endpoint URL, for example
+	// "https://example.com/push?token=secret".
+	Token string
+}
+
+// NewPushHandler returns a PushHandler which calls f with each pushed Message.
+func NewPushHandler(f func(ctx context.Context, subscription string, m *Message) error) *PushHandler {
+	return &PushHandler{Receive: f}
+}
+
+// pushRequest is the JSON body of a request to a push endpoint. See
+// https://cloud.google.com/pubsub/docs/push#receive_push.
+type pushRequest struct {
+	Message struct {
+		Attributes  map[string]string `json:"attributes"`
+		Data        []byte            `json:"data"` // base64 encoded in the JSON
+		MessageID   string            `json:"messageId"`
+		PublishTime time.Time         `json:"publishTime"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// ServeHTTP implements http.Handler.
+func (h *PushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "pubsub: push requests must use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.Token != "" {
+		tok := r.URL.Query().Get("token")
+		if subtle.ConstantTimeCompare([]byte(tok), []byte(h.Token)) != 1 {
+			http.Error(w, "pubsub: invalid push token", http.StatusForbidden)
+			return
+		}
+	}
+	var req pushRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPushBodySize)).Decode(&req); err != nil {
+		http.Error(w, "pubsub: malformed push request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	m := &Message{
+		ID:          req.Message.MessageID,
+		Data:        req.Message.Data,
+		Attributes:  req.Message.Attributes,
+		PublishTime: req.Message.PublishTime,
+		OrderingKey: takeOrderingKey(req.Message.Attributes),
+	}
+	if err := h.Receive(requestContext(r), req.Subscription, m); err != nil {
+		// The error is not reported to the client, since it may contain
+		// details of the application which should not leave this process.
+		http.Error(w, "pubsub: message not processed", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
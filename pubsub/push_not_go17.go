@@ -0,0 +1,29 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !go1.7
+
+package pubsub
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// requestContext returns the context for r. Requests have no context before
+// Go 1.7, so the handler can't be cancelled when the client goes away.
+func requestContext(r *http.Request) context.Context {
+	return context.Background()
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const pushBody = `{
+  "message": {
+    "attributes": {"k": "v"},
+    "data": "aGVsbG8=",
+    "messageId": "136969346945",
+    "publishTime": "2017-05-01T12:34:56.789Z"
+  },
+  "subscription": "projects/p/subscriptions/s"
+}`
+
+func newPushRequest(t *testing.T, method, target, body string) *http.Request {
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestPushHandler(t *testing.T) {
+	var (
+		gotSub string
+		gotMsg *Message
+	)
+	h := NewPushHandler(func(ctx context.Context, sub string, m *Message) error {
+		gotSub, gotMsg = sub, m
+		return nil
+	})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newPushRequest(t, "POST", "/push", pushBody))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if want := "projects/p/subscriptions/s"; gotSub != want {
+		t.Errorf("subscription: got %q, want %q", gotSub, want)
+	}
+	want := &Message{
+		ID:          "136969346945",
+		Data:        []byte("hello"),
+		Attributes:  map[string]string{"k": "v"},
+		PublishTime: time.Date(2017, 5, 1, 12, 34, 56, 789e6, time.UTC),
+	}
+	if !reflect.DeepEqual(gotMsg, want) {
+		t.Errorf("message: got %+v, want %+v", gotMsg, want)
+	}
+}
+
+func TestPushHandlerDone(t *testing.T) {
+	// Done has no effect on pushed Messages, whose acknowledgement is
+	// determined by the return value of Receive.
+	h := NewPushHandler(func(ctx context.Context, sub string, m *Message) error {
+		m.Done(true)
+		return errors.New("receive failed")
+	})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newPushRequest(t, "POST", "/push", pushBody))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status: got %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestPushHandlerErrors(t *testing.T) {
+	receiveErr := errors.New("receive failed")
+	for _, test := range []struct {
+		desc       string
+		method     string
+		target     string
+		body       string
+		receiveErr error
+		want       int
+	}{
+		{"ok", "POST", "/push?token=tok", pushBody, nil, http.StatusNoContent},
+		{"wrong method", "GET", "/push?token=tok", "", nil, http.StatusMethodNotAllowed},
+		{"missing token", "POST", "/push", pushBody, nil, http.StatusForbidden},
+		{"wrong token", "POST", "/push?token=bad", pushBody, nil, http.StatusForbidden},
+		{"bad body", "POST", "/push?token=tok", "{", nil, http.StatusBadRequest},
+		{"bad data", "POST", "/push?token=tok", `{"message": {"data": "!"}}`, nil, http.StatusBadRequest},
+		{"receive error", "POST", "/push?token=tok", pushBody, receiveErr, http.StatusInternalServerError},
+	} {
+		called := false
+		h := NewPushHandler(func(context.Context, string, *Message) error {
+			called = true
+			return test.receiveErr
+		})
+		h.Token = "tok"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, newPushRequest(t, test.method, test.target, test.body))
+		if w.Code != test.want {
+			t.Errorf("%s: status: got %d, want %d", test.desc, w.Code, test.want)
+		}
+		if wantCalled := test.want == http.StatusNoContent || test.receiveErr != nil; called != wantCalled {
+			t.Errorf("%s: Receive called: got %t, want %t", test.desc, called, wantCalled)
+		}
+		if strings.Contains(w.Body.String(), "receive failed") {
+			t.Errorf("%s: response body leaks the Receive error: %q", test.desc, w.Body.String())
+		}
+	}
+}
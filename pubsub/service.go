@@ -21,6 +21,7 @@ import (
 
 	"cloud.google.com/go/iam"
 	vkit "cloud.google.com/go/pubsub/apiv1"
+	"github.com/golang/protobuf/ptypes"
 	"golang.org/x/net/context"
 	"google.golang.org/api/option"
 	pb "google.golang.org/genproto/googleapis/pubsub/v1"
@@ -31,6 +32,7 @@ import (
 const version = "0.2.0"
 
 type nextStringFunc func() (string, error)
+type nextSnapshotFunc func() (*SnapshotConfig, error)
 
 // service provides an internal abstraction to isolate the generated
 // PubSub API; most of this package uses this interface instead.
@@ -63,6 +65,15 @@ type service interface {
 	// acknowledge ACKs the IDs in ackIDs.
 	acknowledge(ctx context.Context, subName string, ackIDs []string) error
 
+	createSnapshot(ctx context.Context, snapName, subName string) (*SnapshotConfig, error)
+	deleteSnapshot(ctx context.Context, snapName string) error
+	listProjectSnapshots(ctx context.Context, projName string) nextSnapshotFunc
+
+	// seekToTime and seekToSnapshot reset the acknowledgement state of the
+	// messages in a subscription.
+	seekToTime(ctx context.Context, subName string, t time.Time) error
+	seekToSnapshot(ctx context.Context, subName, snapName string) error
+
 	iamHandle(resourceName string) *iam.Handle
 
 	close() error
@@ -269,7 +280,7 @@ func (s *apiService) publishMessages(ctx context.Context, topicName string, msgs
 	for i, msg := range msgs {
 		rawMsgs[i] = &pb.PubsubMessage{
 			Data:       msg.Data,
-			Attributes: msg.Attributes,
+			Attributes: publishedAttributes(msg),
 		}
 	}
 	resp, err := s.pubc.Publish(ctx, &pb.PublishRequest{
@@ -292,6 +303,66 @@ func (s *apiService) modifyPushConfig(ctx context.Context, subName string, conf
 	})
 }
 
+func (s *apiService) createSnapshot(ctx context.Context, snapName, subName string) (*SnapshotConfig, error) {
+	snap, err := s.subc.CreateSnapshot(ctx, &pb.CreateSnapshotRequest{
+		Name:         snapName,
+		Subscription: subName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.toSnapshotConfig(snap)
+}
+
+func (s *apiService) deleteSnapshot(ctx context.Context, snapName string) error {
+	return s.subc.DeleteSnapshot(ctx, &pb.DeleteSnapshotRequest{Snapshot: snapName})
+}
+
+func (s *apiService) listProjectSnapshots(ctx context.Context, projName string) nextSnapshotFunc {
+	it := s.subc.ListSnapshots(ctx, &pb.ListSnapshotsRequest{
+		Project: projName,
+	})
+	return func() (*SnapshotConfig, error) {
+		snap, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		return s.toSnapshotConfig(snap)
+	}
+}
+
+func (s *apiService) toSnapshotConfig(snap *pb.Snapshot) (*SnapshotConfig, error) {
+	exp, err := ptypes.Timestamp(snap.ExpireTime)
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotConfig{
+		Snapshot:   &Snapshot{s: s, name: snap.Name},
+		Topic:      &Topic{s: s, name: snap.Topic},
+		Expiration: exp,
+	}, nil
+}
+
+func (s *apiService) seekToTime(ctx context.Context, subName string, t time.Time) error {
+	ts, err := ptypes.TimestampProto(t)
+	if err != nil {
+		return err
+	}
+	_, err = s.subc.Seek(ctx, &pb.SeekRequest{
+		Subscription: subName,
+		Target:       &pb.SeekRequest_Time{Time: ts},
+	})
+	return err
+}
+
+func (s *apiService) seekToSnapshot(ctx context.Context, subName, snapName string) error {
+	_, err := s.subc.Seek(ctx, &pb.SeekRequest{
+		Subscription: subName,
+		Target:       &pb.SeekRequest_Snapshot{Snapshot: snapName},
+	})
+	return err
+}
+
 func (s *apiService) iamHandle(resourceName string) *iam.Handle {
 	return iam.InternalNewHandle(s.pubc.Connection(), resourceName)
 }
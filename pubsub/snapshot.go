@@ -0,0 +1,131 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Snapshot is a reference to a PubSub snapshot.
+//
+// A snapshot captures the acknowledgement state of a subscription at the time
+// it is created. A subscription may later be rewound to a snapshot with
+// Subscription.SeekToSnapshot.
+type Snapshot struct {
+	s service
+
+	// The fully qualified identifier for the snapshot, in the format "projects/<projid>/snapshots/<name>"
+	name string
+}
+
+// Snapshot creates a reference to a snapshot.
+func (c *Client) Snapshot(id string) *Snapshot {
+	return &Snapshot{
+		s:    c.s,
+		name: fmt.Sprintf("projects/%s/snapshots/%s", c.projectID, id),
+	}
+}
+
+// String returns the globally unique printable name of the snapshot.
+func (s *Snapshot) String() string {
+	return s.name
+}
+
+// ID returns the unique identifier of the snapshot within its project.
+func (s *Snapshot) ID() string {
+	slash := strings.LastIndex(s.name, "/")
+	if slash == -1 {
+		// name is not a fully-qualified name.
+		panic("bad snapshot name")
+	}
+	return s.name[slash+1:]
+}
+
+// Delete deletes the snapshot.
+func (s *Snapshot) Delete(ctx context.Context) error {
+	return s.s.deleteSnapshot(ctx, s.name)
+}
+
+// SnapshotConfig contains the details of a snapshot.
+type SnapshotConfig struct {
+	*Snapshot
+
+	// Topic is the topic of the subscription from which the snapshot was created.
+	Topic *Topic
+
+	// Expiration is the time at which the snapshot will be deleted by the
+	// server. A snapshot lives for at most as long as the oldest unacknowledged
+	// message it retains.
+	Expiration time.Time
+}
+
+// CreateSnapshot creates a new snapshot from the current state of sub.
+//
+// id is the name of the snapshot to create. The same naming rules apply as
+// for topics and subscriptions.
+//
+// The snapshot retains the messages that were unacknowledged in sub at the
+// time of creation, as well as any messages published to sub's topic
+// afterwards. If the snapshot already exists an error will be returned.
+func (c *Client) CreateSnapshot(ctx context.Context, id string, sub *Subscription) (*SnapshotConfig, error) {
+	if sub == nil {
+		return nil, errors.New("pubsub: must supply non-nil Subscription")
+	}
+	return c.s.createSnapshot(ctx, c.Snapshot(id).name, sub.name)
+}
+
+// Snapshots returns an iterator which returns snapshots for the client's project.
+func (c *Client) Snapshots(ctx context.Context) *SnapshotConfigIterator {
+	return &SnapshotConfigIterator{
+		next: c.s.listProjectSnapshots(ctx, c.fullyQualifiedProjectName()),
+	}
+}
+
+// SnapshotConfigIterator is an iterator that returns a series of snapshots.
+type SnapshotConfigIterator struct {
+	next nextSnapshotFunc
+}
+
+// Next returns the next SnapshotConfig. If there are no more snapshots, iterator.Done will be returned.
+func (snaps *SnapshotConfigIterator) Next() (*SnapshotConfig, error) {
+	return snaps.next()
+}
+
+// SeekToTime seeks the subscription to a point in time.
+//
+// Messages published before t are marked as acknowledged, and messages
+// retained in the subscription that were published after t are marked as
+// unacknowledged, and will be redelivered. Seeking to a time in the future
+// acknowledges every message currently retained by the subscription.
+func (s *Subscription) SeekToTime(ctx context.Context, t time.Time) error {
+	return s.s.seekToTime(ctx, s.name, t)
+}
+
+// SeekToSnapshot seeks the subscription to a snapshot.
+//
+// The acknowledgement state of the subscription is set to the state captured
+// by snap. The snapshot must have been created from a subscription to the
+// same topic.
+func (s *Subscription) SeekToSnapshot(ctx context.Context, snap *Snapshot) error {
+	if snap == nil {
+		return errors.New("pubsub: must supply non-nil Snapshot")
+	}
+	return s.s.seekToSnapshot(ctx, s.name, snap.name)
+}
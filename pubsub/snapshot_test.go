@@ -0,0 +1,154 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"google.golang.org/api/iterator"
+)
+
+type snapshotService struct {
+	service
+	snaps []string
+
+	// The arguments of the most recent calls are recorded here.
+	createdSnap, createdFrom string
+	deletedSnap              string
+	seekSub, seekSnap        string
+	seekTime                 time.Time
+
+	t *testing.T // for error logging.
+}
+
+func (s *snapshotService) createSnapshot(ctx context.Context, snapName, subName string) (*SnapshotConfig, error) {
+	s.createdSnap, s.createdFrom = snapName, subName
+	return &SnapshotConfig{Snapshot: &Snapshot{s: s, name: snapName}}, nil
+}
+
+func (s *snapshotService) deleteSnapshot(ctx context.Context, snapName string) error {
+	s.deletedSnap = snapName
+	return nil
+}
+
+func (s *snapshotService) listProjectSnapshots(ctx context.Context, projName string) nextSnapshotFunc {
+	if projName != "projects/projid" {
+		s.t.Fatalf("unexpected call: projName: %q", projName)
+		return nil
+	}
+	return func() (*SnapshotConfig, error) {
+		if len(s.snaps) == 0 {
+			return nil, iterator.Done
+		}
+		sn := s.snaps[0]
+		s.snaps = s.snaps[1:]
+		return &SnapshotConfig{Snapshot: &Snapshot{s: s, name: sn}}, nil
+	}
+}
+
+func (s *snapshotService) seekToTime(ctx context.Context, subName string, t time.Time) error {
+	s.seekSub, s.seekTime = subName, t
+	return nil
+}
+
+func (s *snapshotService) seekToSnapshot(ctx context.Context, subName, snapName string) error {
+	s.seekSub, s.seekSnap = subName, snapName
+	return nil
+}
+
+func TestSnapshotID(t *testing.T) {
+	c := &Client{projectID: "projid", s: &snapshotService{t: t}}
+	snap := c.Snapshot("snap")
+	if got, want := snap.ID(), "snap"; got != want {
+		t.Errorf("Snapshot.ID() = %q; want %q", got, want)
+	}
+	if got, want := snap.String(), "projects/projid/snapshots/snap"; got != want {
+		t.Errorf("Snapshot.String() = %q; want %q", got, want)
+	}
+}
+
+func TestListProjectSnapshots(t *testing.T) {
+	s := &snapshotService{
+		snaps: []string{"projects/projid/snapshots/s1", "projects/projid/snapshots/s2"},
+		t:     t,
+	}
+	c := &Client{projectID: "projid", s: s}
+	var got []string
+	it := c.Snapshots(context.Background())
+	for {
+		snap, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatalf("error listing snapshots: %v", err)
+		}
+		got = append(got, snap.ID())
+	}
+	if want := []string{"s1", "s2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshot list: got: %v, want: %v", got, want)
+	}
+}
+
+func TestCreateAndSeekSnapshot(t *testing.T) {
+	ctx := context.Background()
+	s := &snapshotService{t: t}
+	c := &Client{projectID: "projid", s: s}
+	sub := c.Subscription("sub")
+
+	snap, err := c.CreateSnapshot(ctx, "snap", sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.createdSnap, "projects/projid/snapshots/snap"; got != want {
+		t.Errorf("created snapshot: got %q, want %q", got, want)
+	}
+	if got, want := s.createdFrom, sub.name; got != want {
+		t.Errorf("created from: got %q, want %q", got, want)
+	}
+
+	if err := sub.SeekToSnapshot(ctx, snap.Snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if s.seekSub != sub.name || s.seekSnap != snap.name {
+		t.Errorf("seek: got (%q, %q), want (%q, %q)", s.seekSub, s.seekSnap, sub.name, snap.name)
+	}
+
+	when := time.Date(2017, 5, 1, 0, 0, 0, 0, time.UTC)
+	if err := sub.SeekToTime(ctx, when); err != nil {
+		t.Fatal(err)
+	}
+	if !s.seekTime.Equal(when) {
+		t.Errorf("seek time: got %v, want %v", s.seekTime, when)
+	}
+
+	if err := snap.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.deletedSnap, snap.name; got != want {
+		t.Errorf("deleted snapshot: got %q, want %q", got, want)
+	}
+
+	if _, err := c.CreateSnapshot(ctx, "snap", nil); err == nil {
+		t.Error("CreateSnapshot with nil subscription: got nil, want error")
+	}
+	if err := sub.SeekToSnapshot(ctx, nil); err == nil {
+		t.Error("SeekToSnapshot with nil snapshot: got nil, want error")
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/option"
+)
+
+// StatsOp identifies a kind of request to the Pub/Sub service.
+type StatsOp string
+
+// The requests reported to Stats.RecordCall.
+const (
+	OpPublish StatsOp = "publish"
+	OpPull    StatsOp = "pull"
+	OpAck     StatsOp = "ack"
+	// OpModAck covers both deadline extensions and nacks.
+	OpModAck StatsOp = "modack"
+)
+
+// Stats receives measurements of a Client's activity. See WithStats.
+//
+// Methods of Stats may be called concurrently, and should return quickly.
+type Stats interface {
+	// RecordCall is called after each publish, pull, ack or modack request
+	// with the number of messages or ack IDs in the request (for OpPull,
+	// the number of messages returned), its latency, and the error it
+	// returned, if any.
+	RecordCall(op StatsOp, n int, latency time.Duration, err error)
+
+	// RecordExpired is called with the number of Messages which a
+	// MessageIterator stopped keeping alive because MaxExtension elapsed
+	// before Message.Done was called.
+	RecordExpired(n int)
+
+	// AddOutstanding is called with the change in the number of Messages
+	// which have been pulled by a MessageIterator but not yet acked,
+	// nacked or expired.
+	AddOutstanding(delta int)
+}
+
+type noopStats struct{}
+
+func (noopStats) RecordCall(StatsOp, int, time.Duration, error) {}
+func (noopStats) RecordExpired(int)                             {}
+func (noopStats) AddOutstanding(int)                            {}
+
+// WithStats returns a ClientOption which causes measurements of the Client's
+// activity to be reported to st. By default, or if st is nil, they are
+// discarded.
+//
+// The option is only understood by NewClient; it must not be passed to the
+// clients of other packages.
+func WithStats(st Stats) option.ClientOption {
+	return statsOption{stats: st}
+}
+
+// statsOption is removed from the options by NewClient, so its embedded
+// ClientOption, which is nil, is never applied.
+type statsOption struct {
+	option.ClientOption
+	stats Stats
+}
+
+// withStats returns a service which reports the requests made through s to
+// st, or s itself if st is nil.
+func withStats(s service, st Stats) service {
+	if st == nil {
+		return s
+	}
+	return &statsService{service: s, stats: st}
+}
+
+// statsOf returns the Stats to which calls to s are reported.
+func statsOf(s service) Stats {
+	if ss, ok := s.(*statsService); ok {
+		return ss.stats
+	}
+	return noopStats{}
+}
+
+// statsService reports the requests made through it to stats.
+type statsService struct {
+	service
+	stats Stats
+}
+
+func (s *statsService) publishMessages(ctx context.Context, topicName string, msgs []*Message) ([]string, error) {
+	start := time.Now()
+	ids, err := s.service.publishMessages(ctx, topicName, msgs)
+	s.stats.RecordCall(OpPublish, len(msgs), time.Since(start), err)
+	return ids, err
+}
+
+func (s *statsService) fetchMessages(ctx context.Context, subName string, maxMessages int32) ([]*Message, error) {
+	start := time.Now()
+	msgs, err := s.service.fetchMessages(ctx, subName, maxMessages)
+	s.stats.RecordCall(OpPull, len(msgs), time.Since(start), err)
+	return msgs, err
+}
+
+func (s *statsService) acknowledge(ctx context.Context, subName string, ackIDs []string) error {
+	start := time.Now()
+	err := s.service.acknowledge(ctx, subName, ackIDs)
+	s.stats.RecordCall(OpAck, len(ackIDs), time.Since(start), err)
+	return err
+}
+
+func (s *statsService) modifyAckDeadline(ctx context.Context, subName string, deadline time.Duration, ackIDs []string) error {
+	start := time.Now()
+	err := s.service.modifyAckDeadline(ctx, subName, deadline, ackIDs)
+	s.stats.RecordCall(OpModAck, len(ackIDs), time.Since(start), err)
+	return err
+}
+
+// CallStats summarizes the requests of one kind recorded by MemoryStats.
+type CallStats struct {
+	// Calls is the number of requests, and Errors the number of those
+	// which failed.
+	Calls, Errors int
+
+	// Messages is the total number of messages or ack IDs in the requests.
+	Messages int
+
+	// Latencies holds the latency of each request, in the order in which
+	// they completed.
+	Latencies []time.Duration
+}
+
+// MemoryStats is a Stats which keeps all measurements in memory. It is
+// intended for tests.
+type MemoryStats struct {
+	mu          sync.Mutex
+	calls       map[StatsOp]*CallStats
+	expired     int
+	outstanding int
+}
+
+// NewMemoryStats returns an empty MemoryStats.
+func NewMemoryStats() *MemoryStats {
+	return &MemoryStats{calls: make(map[StatsOp]*CallStats)}
+}
+
+// RecordCall implements Stats.
+func (s *MemoryStats) RecordCall(op StatsOp, n int, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cs, ok := s.calls[op]
+	if !ok {
+		cs = &CallStats{}
+		s.calls[op] = cs
+	}
+	cs.Calls++
+	if err != nil {
+		cs.Errors++
+	}
+	cs.Messages += n
+	cs.Latencies = append(cs.Latencies, latency)
+}
+
+// RecordExpired implements Stats.
+func (s *MemoryStats) RecordExpired(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expired += n
+}
+
+// AddOutstanding implements Stats.
+func (s *MemoryStats) AddOutstanding(delta int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outstanding += delta
+}
+
+// Calls returns a summary of the requests of kind op recorded so far.
+func (s *MemoryStats) Calls(op StatsOp) CallStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cs, ok := s.calls[op]
+	if !ok {
+		return CallStats{}
+	}
+	c := *cs
+	c.Latencies = append([]time.Duration(nil), cs.Latencies...)
+	return c
+}
+
+// Expired returns the total number of expired Messages recorded so far.
+func (s *MemoryStats) Expired() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.expired
+}
+
+// Outstanding returns the current number of outstanding Messages.
+func (s *MemoryStats) Outstanding() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.outstanding
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	s := &deadLetterService{
+		fetcherService: fetcherService{
+			results: []fetchResult{
+				{msgs: []*Message{{ackID: "a1"}, {ackID: "a2"}}},
+			},
+		},
+	}
+	st := NewMemoryStats()
+	c := &Client{projectID: "p", s: withStats(s, st)}
+
+	if _, err := c.Topic("t").Publish(ctx, &Message{}, &Message{}, &Message{}); err != nil {
+		t.Fatal(err)
+	}
+
+	it := newMessageIterator(ctx, c.s, "projects/p/subscriptions/s", &pullOptions{
+		ackDeadline:  10 * time.Second,
+		maxExtension: time.Hour,
+		maxPrefetch:  2,
+	})
+	m1, err := it.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := it.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := st.Outstanding(), 2; got != want {
+		t.Errorf("outstanding: got %d, want %d", got, want)
+	}
+	m1.Done(true)
+	m2.Done(false)
+	it.Stop()
+	if got, want := st.Outstanding(), 0; got != want {
+		t.Errorf("outstanding after Stop: got %d, want %d", got, want)
+	}
+
+	for _, test := range []struct {
+		op           StatsOp
+		wantMessages int
+	}{
+		{OpPublish, 3},
+		{OpPull, 2},
+		{OpAck, 1},
+		{OpModAck, 1},
+	} {
+		cs := st.Calls(test.op)
+		if cs.Calls == 0 || cs.Messages != test.wantMessages || len(cs.Latencies) != cs.Calls {
+			t.Errorf("%s: got %+v, want %d messages", test.op, cs, test.wantMessages)
+		}
+	}
+}
+
+func TestWithStatsNil(t *testing.T) {
+	ctx := context.Background()
+	s := &deadLetterService{}
+	c := &Client{projectID: "p", s: withStats(s, nil)}
+	if c.s != service(s) {
+		t.Errorf("withStats(nil): got service %T, want the original", c.s)
+	}
+	if _, ok := statsOf(c.s).(noopStats); !ok {
+		t.Errorf("withStats(nil): got stats %T, want noopStats", statsOf(c.s))
+	}
+	// Requests are not reported, and do not cause a panic.
+	if _, err := c.Topic("t").Publish(ctx, &Message{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatsExpired(t *testing.T) {
+	ticker := make(chan time.Time)
+	st := NewMemoryStats()
+	ka := &keepAlive{
+		s:             &deadLetterService{},
+		Ctx:           context.Background(),
+		Sub:           "subname",
+		ExtensionTick: ticker,
+		Deadline:      10 * time.Second,
+		MaxExtension:  0,
+		Stats:         st,
+	}
+	ka.Start()
+	ka.Add("a")
+	time.Sleep(time.Millisecond)
+	ticker <- time.Time{}
+	ka.Stop()
+
+	if got, want := st.Expired(), 1; got != want {
+		t.Errorf("expired: got %d, want %d", got, want)
+	}
+	if got, want := st.Outstanding(), 0; got != want {
+		t.Errorf("outstanding: got %d, want %d", got, want)
+	}
+}
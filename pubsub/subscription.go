@@ -143,6 +143,9 @@ func (s *Subscription) Pull(ctx context.Context, opts ...PullOption) (*MessageIt
 		return nil, err
 	}
 	po := processPullOptions(opts)
+	if po.deadLetter != nil && po.deadLetter.topic == nil {
+		return nil, errors.New("pubsub: DeadLetter requires a non-nil Topic")
+	}
 	po.ackDeadline = config.AckDeadline
 	return newMessageIterator(ctx, s.s, s.name, po), nil
 }
@@ -177,6 +180,18 @@ type pullOptions struct {
 	// ackDeadline is the default ack deadline for the subscription.  Not
 	// configurable via a PullOption.
 	ackDeadline time.Duration
+
+	// deadLetter, if non-nil, enables client-side dead-lettering of
+	// Messages which are repeatedly nacked.
+	deadLetter *deadLetter
+
+	// ordered indicates that Messages with the same ordering key should be
+	// returned from MessageIterator.Next one at a time.
+	ordered bool
+
+	// dedup, if non-nil, is used to discard Messages which have already
+	// been processed.
+	dedup DedupStore
 }
 
 func processPullOptions(opts []PullOption) *pullOptions {
@@ -229,6 +244,45 @@ func MaxExtension(duration time.Duration) PullOption {
 	return maxExtension(duration)
 }
 
+type deadLetter struct {
+	// topic is the topic to which undeliverable Messages are republished.
+	topic *Topic
+
+	// maxAttempts is the number of deliveries ending in a nack after which a
+	// Message is dead-lettered.
+	maxAttempts int
+}
+
+func (dl deadLetter) setOptions(o *pullOptions) {
+	if dl.maxAttempts < 1 {
+		dl.maxAttempts = 1
+	}
+	o.deadLetter = &dl
+}
+
+// DeadLetter returns a PullOption that republishes Messages which cannot be
+// processed to topic.
+//
+// The MessageIterator counts the deliveries of each Message by its ID. When
+// Message.Done(false) is called on a Message that has been delivered
+// maxDeliveryAttempts times, the Message is published to topic with its
+// original data and attributes, plus the attributes described by the
+// DeadLetter* constants, and the original Message is acknowledged. If
+// publishing fails, the Message is nacked as usual and will be redelivered.
+//
+// Delivery attempts are counted by the MessageIterator only: deliveries to
+// other processes, or to an earlier MessageIterator, are not taken into
+// account. Counts are discarded once a Message is acknowledged or
+// dead-lettered, and when the MessageIterator is stopped. So that memory is
+// bounded, at most 100,000 counts are kept, and a count is discarded if its
+// Message is not redelivered within an hour after the MessageIterator stops
+// extending its deadline.
+//
+// If maxDeliveryAttempts is less than 1, it will be treated as if it were 1.
+func DeadLetter(topic *Topic, maxDeliveryAttempts int) PullOption {
+	return deadLetter{topic: topic, maxAttempts: maxDeliveryAttempts}
+}
+
 // CreateSubscription creates a new subscription on a topic.
 //
 // name is the name of the subscription to create. It must start with a letter,
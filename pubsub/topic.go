@@ -15,8 +15,10 @@
 package pubsub
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
 	"cloud.google.com/go/iam"
 	"golang.org/x/net/context"
@@ -30,6 +32,24 @@ type Topic struct {
 
 	// The fully qualified identifier for the topic, in the format "projects/<projid>/topics/<name>"
 	name string
+
+	// EnableMessageOrdering causes messages with the same OrderingKey to be
+	// published in the order in which Publish is called. At most one
+	// Publish call containing a given key is in flight at a time, and if
+	// one fails, publishing for its keys is paused until ResumePublish is
+	// called.
+	//
+	// EnableMessageOrdering must be set before the first call to Publish.
+	EnableMessageOrdering bool
+
+	// Validate, if non-nil, is called by PublishEncoded, PublishJSON and
+	// PublishProto with each value before it is encoded. If it returns an
+	// error, the value is not published and the error is returned.
+	// Validate is typically used to check values against a schema.
+	Validate func(v interface{}) error
+
+	mu   sync.Mutex
+	keys map[string]*orderingKeyState // ordering keys, created as needed
 }
 
 // CreateTopic creates a new topic.
@@ -117,6 +137,10 @@ func (t *Topic) Subscriptions(ctx context.Context) *SubscriptionIterator {
 // Publish publishes the supplied Messages to the topic.
 // If successful, the server-assigned message IDs are returned in the same order as the supplied Messages.
 // At most MaxPublishBatchSize messages may be supplied.
+//
+// Messages with an OrderingKey may only be published if EnableMessageOrdering
+// is set. If publishing for any of their keys has been paused, Publish returns
+// an ErrPublishingPaused error without sending the messages.
 func (t *Topic) Publish(ctx context.Context, msgs ...*Message) ([]string, error) {
 	if len(msgs) == 0 {
 		return nil, nil
@@ -124,7 +148,16 @@ func (t *Topic) Publish(ctx context.Context, msgs ...*Message) ([]string, error)
 	if len(msgs) > MaxPublishBatchSize {
 		return nil, fmt.Errorf("pubsub: got %d messages, but maximum batch size is %d", len(msgs), MaxPublishBatchSize)
 	}
-	return t.s.publishMessages(ctx, t.name, msgs)
+	keys := orderingKeys(msgs)
+	if len(keys) == 0 {
+		return t.s.publishMessages(ctx, t.name, msgs)
+	}
+	if !t.EnableMessageOrdering {
+		return nil, errors.New("pubsub: Message.OrderingKey is set, but Topic.EnableMessageOrdering is false")
+	}
+	return t.publishOrdered(keys, func() ([]string, error) {
+		return t.s.publishMessages(ctx, t.name, msgs)
+	})
 }
 
 func (t *Topic) IAM() *iam.Handle {
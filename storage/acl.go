@@ -52,10 +52,12 @@ type ACLRule struct {
 
 // ACLHandle provides operations on an access control list for a Google Cloud Storage bucket or object.
 type ACLHandle struct {
-	c         *Client
-	bucket    string
-	object    string
-	isDefault bool
+	c           *Client
+	bucket      string
+	object      string
+	isDefault   bool
+	retry       *retryConfig
+	userProject string // project for requester-pays buckets
 }
 
 // Delete permanently deletes the ACL entry for the given entity.
@@ -94,8 +96,12 @@ func (a *ACLHandle) List(ctx context.Context) ([]ACLRule, error) {
 func (a *ACLHandle) bucketDefaultList(ctx context.Context) ([]ACLRule, error) {
 	var acls *raw.ObjectAccessControls
 	var err error
-	err = runWithRetry(ctx, func() error {
-		acls, err = a.c.raw.DefaultObjectAccessControls.List(a.bucket).Context(ctx).Do()
+	req := a.c.raw.DefaultObjectAccessControls.List(a.bucket)
+	if a.userProject != "" {
+		req.UserProject(a.userProject)
+	}
+	err = runWithRetry(ctx, a.retry, true, func() error {
+		acls, err = req.Context(ctx).Do()
 		return err
 	})
 	if err != nil {
@@ -110,8 +116,12 @@ func (a *ACLHandle) bucketDefaultSet(ctx context.Context, entity ACLEntity, role
 		Entity: string(entity),
 		Role:   string(role),
 	}
-	err := runWithRetry(ctx, func() error {
-		_, err := a.c.raw.DefaultObjectAccessControls.Update(a.bucket, string(entity), acl).Context(ctx).Do()
+	req := a.c.raw.DefaultObjectAccessControls.Update(a.bucket, string(entity), acl)
+	if a.userProject != "" {
+		req.UserProject(a.userProject)
+	}
+	err := runWithRetry(ctx, a.retry, false, func() error {
+		_, err := req.Context(ctx).Do()
 		return err
 	})
 	if err != nil {
@@ -121,8 +131,12 @@ func (a *ACLHandle) bucketDefaultSet(ctx context.Context, entity ACLEntity, role
 }
 
 func (a *ACLHandle) bucketDefaultDelete(ctx context.Context, entity ACLEntity) error {
-	err := runWithRetry(ctx, func() error {
-		return a.c.raw.DefaultObjectAccessControls.Delete(a.bucket, string(entity)).Context(ctx).Do()
+	req := a.c.raw.DefaultObjectAccessControls.Delete(a.bucket, string(entity))
+	if a.userProject != "" {
+		req.UserProject(a.userProject)
+	}
+	err := runWithRetry(ctx, a.retry, false, func() error {
+		return req.Context(ctx).Do()
 	})
 	if err != nil {
 		return fmt.Errorf("storage: error deleting default ACL entry for bucket %q, entity %q: %v", a.bucket, entity, err)
@@ -133,8 +147,12 @@ func (a *ACLHandle) bucketDefaultDelete(ctx context.Context, entity ACLEntity) e
 func (a *ACLHandle) bucketList(ctx context.Context) ([]ACLRule, error) {
 	var acls *raw.BucketAccessControls
 	var err error
-	err = runWithRetry(ctx, func() error {
-		acls, err = a.c.raw.BucketAccessControls.List(a.bucket).Context(ctx).Do()
+	req := a.c.raw.BucketAccessControls.List(a.bucket)
+	if a.userProject != "" {
+		req.UserProject(a.userProject)
+	}
+	err = runWithRetry(ctx, a.retry, true, func() error {
+		acls, err = req.Context(ctx).Do()
 		return err
 	})
 	if err != nil {
@@ -154,8 +172,12 @@ func (a *ACLHandle) bucketSet(ctx context.Context, entity ACLEntity, role ACLRol
 		Entity: string(entity),
 		Role:   string(role),
 	}
-	err := runWithRetry(ctx, func() error {
-		_, err := a.c.raw.BucketAccessControls.Update(a.bucket, string(entity), acl).Context(ctx).Do()
+	req := a.c.raw.BucketAccessControls.Update(a.bucket, string(entity), acl)
+	if a.userProject != "" {
+		req.UserProject(a.userProject)
+	}
+	err := runWithRetry(ctx, a.retry, false, func() error {
+		_, err := req.Context(ctx).Do()
 		return err
 	})
 	if err != nil {
@@ -165,8 +187,12 @@ func (a *ACLHandle) bucketSet(ctx context.Context, entity ACLEntity, role ACLRol
 }
 
 func (a *ACLHandle) bucketDelete(ctx context.Context, entity ACLEntity) error {
-	err := runWithRetry(ctx, func() error {
-		return a.c.raw.BucketAccessControls.Delete(a.bucket, string(entity)).Context(ctx).Do()
+	req := a.c.raw.BucketAccessControls.Delete(a.bucket, string(entity))
+	if a.userProject != "" {
+		req.UserProject(a.userProject)
+	}
+	err := runWithRetry(ctx, a.retry, false, func() error {
+		return req.Context(ctx).Do()
 	})
 	if err != nil {
 		return fmt.Errorf("storage: error deleting bucket ACL entry for bucket %q, entity %q: %v", a.bucket, entity, err)
@@ -177,8 +203,12 @@ func (a *ACLHandle) bucketDelete(ctx context.Context, entity ACLEntity) error {
 func (a *ACLHandle) objectList(ctx context.Context) ([]ACLRule, error) {
 	var acls *raw.ObjectAccessControls
 	var err error
-	err = runWithRetry(ctx, func() error {
-		acls, err = a.c.raw.ObjectAccessControls.List(a.bucket, a.object).Context(ctx).Do()
+	req := a.c.raw.ObjectAccessControls.List(a.bucket, a.object)
+	if a.userProject != "" {
+		req.UserProject(a.userProject)
+	}
+	err = runWithRetry(ctx, a.retry, true, func() error {
+		acls, err = req.Context(ctx).Do()
 		return err
 	})
 	if err != nil {
@@ -193,8 +223,12 @@ func (a *ACLHandle) objectSet(ctx context.Context, entity ACLEntity, role ACLRol
 		Entity: string(entity),
 		Role:   string(role),
 	}
-	err := runWithRetry(ctx, func() error {
-		_, err := a.c.raw.ObjectAccessControls.Update(a.bucket, a.object, string(entity), acl).Context(ctx).Do()
+	req := a.c.raw.ObjectAccessControls.Update(a.bucket, a.object, string(entity), acl)
+	if a.userProject != "" {
+		req.UserProject(a.userProject)
+	}
+	err := runWithRetry(ctx, a.retry, false, func() error {
+		_, err := req.Context(ctx).Do()
 		return err
 	})
 	if err != nil {
@@ -204,8 +238,12 @@ func (a *ACLHandle) objectSet(ctx context.Context, entity ACLEntity, role ACLRol
 }
 
 func (a *ACLHandle) objectDelete(ctx context.Context, entity ACLEntity) error {
-	err := runWithRetry(ctx, func() error {
-		return a.c.raw.ObjectAccessControls.Delete(a.bucket, a.object, string(entity)).Context(ctx).Do()
+	req := a.c.raw.ObjectAccessControls.Delete(a.bucket, a.object, string(entity))
+	if a.userProject != "" {
+		req.UserProject(a.userProject)
+	}
+	err := runWithRetry(ctx, a.retry, false, func() error {
+		return req.Context(ctx).Do()
 	})
 	if err != nil {
 		return fmt.Errorf("storage: error deleting object ACL entry for bucket %q, file %q, entity %q: %v", a.bucket, a.object, entity, err)
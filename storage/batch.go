@@ -0,0 +1,423 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+// This file implements batches of requests to the JSON API, which the raw
+// client does not support. See
+// https://cloud.google.com/storage/docs/json_api/v1/how-tos/batch.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	raw "google.golang.org/api/storage/v1"
+)
+
+// maxBatchSize is the largest number of requests the service accepts in a
+// batch.
+const maxBatchSize = 100
+
+var errNotRun = errors.New("storage: batch operation has not been run")
+
+// Batch returns a new, empty Batch.
+func (c *Client) Batch() *Batch {
+	return &Batch{c: c}
+}
+
+// A Batch collects operations on objects so that they can be sent to the
+// service together. Run sends them in as few HTTP requests as it can, each of
+// up to 100 operations, and reports the result of each operation through the
+// BatchOp that added it.
+//
+// The operations in a batch are independent: the service may perform them in
+// any order, and some may fail while others succeed.
+type Batch struct {
+	c   *Client
+	ops []*BatchOp
+}
+
+// A BatchOp is an operation in a Batch. Its results are available once the
+// Batch has been run.
+type BatchOp struct {
+	method string
+	path   string // escaped, relative to the root of the JSON API
+	query  string
+	header http.Header
+	body   []byte
+	// userProject is the project billed for the operation, if any.
+	userProject string
+	// isObject is set for operations on an object itself, for which 404
+	// means ErrObjectNotExist, and whose responses to updates hold the
+	// object.
+	isObject bool
+	// retry and isIdempotent determine whether the operation is retried.
+	retry        *retryConfig
+	isIdempotent bool
+
+	done  bool
+	err   error
+	attrs *ObjectAttrs
+}
+
+// Err returns the error of the operation, or nil if it succeeded. As for the
+// equivalent methods of ObjectHandle, it is ErrObjectNotExist if a deleted or
+// updated object does not exist.
+func (op *BatchOp) Err() error {
+	if !op.done {
+		return errNotRun
+	}
+	return op.err
+}
+
+// Attrs returns the attributes of the object after an Update operation, or nil
+// if it failed or the operation is of another kind.
+func (op *BatchOp) Attrs() *ObjectAttrs {
+	return op.attrs
+}
+
+// add adds an operation on o to the batch, unless err is non-nil, in which
+// case the operation fails without being sent.
+func (b *Batch) add(op *BatchOp, err error) *BatchOp {
+	if err != nil {
+		op.done, op.err = true, err
+	}
+	b.ops = append(b.ops, op)
+	return op
+}
+
+// objectOp returns an operation on o, whose path is rest relative to that of
+// the object.
+func objectOp(o *ObjectHandle, method, rest string, body interface{}) (*BatchOp, error) {
+	op := &BatchOp{
+		method:      method,
+		path:        "b/" + escapeSegment(o.bucket) + "/o/" + escapeSegment(o.object) + rest,
+		header:      make(http.Header),
+		retry:       o.retry,
+		userProject: o.userProject,
+	}
+	if err := o.validate(); err != nil {
+		return op, err
+	}
+	if body != nil {
+		var err error
+		if op.body, err = json.Marshal(body); err != nil {
+			return op, err
+		}
+	}
+	return op, nil
+}
+
+// Delete adds an operation that deletes the object, as o.Delete does.
+func (b *Batch) Delete(o *ObjectHandle) *BatchOp {
+	op, err := objectOp(o, "DELETE", "", nil)
+	if err == nil && o.conds != nil {
+		err = o.conds.validate("Delete")
+	}
+	op.query = conditionsQuery(o.gen, o.conds)
+	op.isObject = true
+	op.isIdempotent = o.gen >= 0 || o.conds != nil && o.conds.GenerationMatch != 0
+	return b.add(op, err)
+}
+
+// Update adds an operation that updates the object's attributes, as o.Update
+// does.
+func (b *Batch) Update(o *ObjectHandle, uattrs ObjectAttrsToUpdate) *BatchOp {
+	op, err := objectOp(o, "PATCH", "", uattrs.toRawObject(o.bucket))
+	if err == nil && o.conds != nil {
+		err = o.conds.validate("Update")
+	}
+	if err == nil {
+		err = setEncryptionHeaders(op.header, o.encryptionKey, false)
+	}
+	op.query = conditionsQuery(o.gen, o.conds)
+	if op.query != "" {
+		op.query += "&"
+	}
+	op.query += "projection=full"
+	op.isObject = true
+	op.isIdempotent = o.conds != nil && o.conds.MetagenerationMatch != 0
+	return b.add(op, err)
+}
+
+// SetACL adds an operation that sets the role of entity in the object's ACL,
+// as o.ACL().Set does.
+func (b *Batch) SetACL(o *ObjectHandle, entity ACLEntity, role ACLRole) *BatchOp {
+	acl := &raw.ObjectAccessControl{
+		Bucket: o.bucket,
+		Entity: string(entity),
+		Role:   string(role),
+	}
+	return b.add(objectOp(o, "PUT", "/acl/"+escapeSegment(string(entity)), acl))
+}
+
+// DeleteACL adds an operation that removes entity from the object's ACL, as
+// o.ACL().Delete does.
+func (b *Batch) DeleteACL(o *ObjectHandle, entity ACLEntity) *BatchOp {
+	return b.add(objectOp(o, "DELETE", "/acl/"+escapeSegment(string(entity)), nil))
+}
+
+// escapeSegment escapes s for use as a single segment of a URL path.
+func escapeSegment(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// Run sends the operations of the batch that have not yet been run. An
+// operation that fails with a transient error is sent again, in a later
+// batch, with backoff, if the retry policy of its handle allows it; see
+// ObjectHandle.Retryer. The backoff, maximum attempts and retry hook of the
+// handles are not used.
+//
+// Run returns an error only if it could not send the operations or ctx is
+// done; otherwise the result of each operation is reported by its BatchOp.
+// Operations which were not completed have the same error.
+func (b *Batch) Run(ctx context.Context) error {
+	var pending []*BatchOp
+	for _, op := range b.ops {
+		if !op.done {
+			pending = append(pending, op)
+		}
+	}
+	for len(pending) > 0 {
+		n := len(pending)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+		ops := pending[:n]
+		// Retry for as long as some operations may be retried.
+		retry := &retryConfig{
+			policy:      RetryAlways,
+			shouldRetry: func(error) bool { return len(ops) > 0 },
+		}
+		err := runWithRetry(ctx, retry, true, func() error {
+			var err error
+			ops, err = b.send(ctx, ops)
+			return err
+		})
+		if err != nil {
+			for _, op := range ops {
+				op.done = false
+			}
+			for _, op := range pending {
+				if !op.done {
+					op.done, op.err = true, err
+				}
+			}
+			return err
+		}
+		pending = pending[n:]
+	}
+	return nil
+}
+
+// send sends the operations in a single batch request. It returns the
+// operations that should be retried, and the error to report if they are
+// not. The other operations are done.
+func (b *Batch) send(ctx context.Context, ops []*BatchOp) ([]*BatchOp, error) {
+	u, err := url.Parse(b.c.raw.BasePath)
+	if err != nil {
+		return nil, err
+	}
+	root := u.EscapedPath()
+	// The batch endpoint is on the host of the JSON API, under "/batch"
+	// followed by the API's path, without a trailing slash.
+	u.Path = "/batch" + strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, op := range ops {
+		op.done, op.err = false, nil
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", "application/http")
+		h.Set("Content-ID", "<"+strconv.Itoa(i)+">")
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		query := op.query
+		if op.userProject != "" {
+			if query != "" {
+				query += "&"
+			}
+			query += "userProject=" + url.QueryEscape(op.userProject)
+		}
+		target := root + op.path
+		if query != "" {
+			target += "?" + query
+		}
+		fmt.Fprintf(pw, "%s %s HTTP/1.1\r\n", op.method, target)
+		if op.body != nil {
+			fmt.Fprintf(pw, "Content-Type: application/json; charset=UTF-8\r\n")
+		}
+		fmt.Fprintf(pw, "Content-Length: %d\r\n", len(op.body))
+		if err := op.header.Write(pw); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(pw, "\r\n")
+		pw.Write(op.body)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", u.String(), &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	res, err := ctxhttp.Do(ctx, b.c.hc, req)
+	if err != nil {
+		return failBatch(ops, err)
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return failBatch(ops, err)
+	}
+	mt, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mt, "multipart/") {
+		return nil, fmt.Errorf("storage: batch response has Content-Type %q", res.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(res.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("storage: reading batch response: %v", err)
+		}
+		// The Content-ID of the response to the request with Content-ID
+		// <N> is <response-N>.
+		id := strings.TrimSuffix(strings.TrimPrefix(part.Header.Get("Content-ID"), "<response-"), ">")
+		i, err := strconv.Atoi(id)
+		if err != nil || i < 0 || i >= len(ops) {
+			return nil, fmt.Errorf("storage: batch response has unknown Content-ID %q", part.Header.Get("Content-ID"))
+		}
+		r, err := http.ReadResponse(bufio.NewReader(part), nil)
+		if err != nil {
+			return nil, fmt.Errorf("storage: reading batch response: %v", err)
+		}
+		ops[i].finish(r)
+	}
+	var (
+		retry    []*BatchOp
+		retryErr error
+	)
+	for _, op := range ops {
+		switch {
+		case !op.done:
+			op.done, op.err = true, errors.New("storage: no response to batch operation")
+		case op.retry.retryable(op.err, op.isIdempotent):
+			retry = append(retry, op)
+			retryErr = op.err
+		}
+	}
+	return retry, retryErr
+}
+
+// failBatch records err as the result of the operations in a batch request
+// that failed, unless they may be retried, and returns those that may.
+func failBatch(ops []*BatchOp, err error) ([]*BatchOp, error) {
+	var retry []*BatchOp
+	for _, op := range ops {
+		if op.retry.retryable(err, op.isIdempotent) {
+			retry = append(retry, op)
+		} else {
+			op.done, op.err = true, err
+		}
+	}
+	return retry, err
+}
+
+// finish records the result of the operation from its response.
+func (op *BatchOp) finish(res *http.Response) {
+	defer res.Body.Close()
+	op.done = true
+	if res.StatusCode == http.StatusNotFound && op.isObject {
+		op.err = ErrObjectNotExist
+		return
+	}
+	if op.err = googleapi.CheckResponse(res); op.err != nil {
+		return
+	}
+	if op.isObject && op.method == "PATCH" {
+		var obj raw.Object
+		if err := json.NewDecoder(res.Body).Decode(&obj); err != nil {
+			op.err = fmt.Errorf("storage: decoding batch response: %v", err)
+			return
+		}
+		op.attrs = newObject(&obj)
+	}
+}
+
+// DeletePrefix deletes the live objects in the bucket whose names begin with
+// prefix, in batches, and returns the number it deleted. Only the generation
+// of each object that was listed is deleted: objects that others delete or
+// replace while it runs are left alone, are not counted, and are not an
+// error. If the bucket has versioning enabled, the objects are archived.
+func (b *BucketHandle) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	it := b.Objects(ctx, &Query{Prefix: prefix})
+	deleted := 0
+	for {
+		batch := b.c.Batch()
+		var ops []*BatchOp
+		for len(ops) < maxBatchSize {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return deleted, err
+			}
+			// Delete only the generation that was listed.
+			o := b.Object(attrs.Name).If(Conditions{GenerationMatch: attrs.Generation})
+			ops = append(ops, batch.Delete(o))
+		}
+		if len(ops) == 0 {
+			return deleted, nil
+		}
+		if err := batch.Run(ctx); err != nil {
+			return deleted, err
+		}
+		for _, op := range ops {
+			switch err := op.Err(); {
+			case err == nil:
+				deleted++
+			case err == ErrObjectNotExist, isStatus(err, http.StatusPreconditionFailed):
+			default:
+				return deleted, err
+			}
+		}
+	}
+}
+
+func isStatus(err error, code int) bool {
+	e, ok := err.(*googleapi.Error)
+	return ok && e.Code == code
+}
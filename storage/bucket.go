@@ -15,9 +15,15 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
 	"time"
 
+	"cloud.google.com/go/internal/optional"
 	"golang.org/x/net/context"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
@@ -35,13 +41,22 @@ func (b *BucketHandle) Create(ctx context.Context, projectID string, attrs *Buck
 	}
 	bkt.Name = b.name
 	req := b.c.raw.Buckets.Insert(projectID, bkt)
-	return runWithRetry(ctx, func() error { _, err := req.Context(ctx).Do(); return err })
+	if b.userProject != "" {
+		req.UserProject(b.userProject)
+	}
+	return runWithRetry(ctx, b.retry, true, func() error { _, err := req.Context(ctx).Do(); return err })
 }
 
 // Delete deletes the Bucket.
 func (b *BucketHandle) Delete(ctx context.Context) error {
 	req := b.c.raw.Buckets.Delete(b.name)
-	return runWithRetry(ctx, func() error { return req.Context(ctx).Do() })
+	if b.userProject != "" {
+		req.UserProject(b.userProject)
+	}
+	if err := applyBucketConds("BucketHandle.Delete", b.conds, req); err != nil {
+		return err
+	}
+	return runWithRetry(ctx, b.retry, true, func() error { return req.Context(ctx).Do() })
 }
 
 // ACL returns an ACLHandle, which provides access to the bucket's access control list.
@@ -70,20 +85,118 @@ func (b *BucketHandle) Object(name string) *ObjectHandle {
 		bucket: b.name,
 		object: name,
 		acl: ACLHandle{
-			c:      b.c,
-			bucket: b.name,
-			object: name,
+			c:           b.c,
+			bucket:      b.name,
+			object:      name,
+			retry:       b.retry,
+			userProject: b.userProject,
 		},
-		gen: -1,
+		gen:         -1,
+		retry:       b.retry,
+		userProject: b.userProject,
+	}
+}
+
+// If returns a new BucketHandle that applies a set of preconditions.
+// Preconditions already set on the BucketHandle are ignored.
+// Operations on the new handle will only occur if the preconditions are
+// satisfied.
+func (b *BucketHandle) If(conds BucketConditions) *BucketHandle {
+	b2 := *b
+	b2.conds = &conds
+	return &b2
+}
+
+// Retryer returns a new BucketHandle that retries operations as configured
+// by opts, which are applied on top of any already set on the BucketHandle.
+// They apply to all the operations of the handle, including those of its
+// ACLHandles, and are inherited by the ObjectHandles it creates.
+//
+// By default, a BucketHandle retries idempotent operations that fail with
+// transient errors. See ObjectHandle.Retryer and RetryPolicy.
+func (b *BucketHandle) Retryer(opts ...RetryOption) *BucketHandle {
+	b2 := *b
+	b2.retry = b.retry.with(opts)
+	b2.acl.retry = b2.retry
+	b2.defaultObjectACL.retry = b2.retry
+	return &b2
+}
+
+// UserProject returns a new BucketHandle that bills the given project for
+// its operations, as requester-pays buckets require of those who do not own
+// them. See https://cloud.google.com/storage/docs/requester-pays. The project
+// is billed for all the operations of the handle, including those of its
+// ACLHandles and IAM handle, and of the ObjectHandles it creates and their
+// Readers, Writers, Copiers and Composers.
+func (b *BucketHandle) UserProject(projectID string) *BucketHandle {
+	b2 := *b
+	b2.userProject = projectID
+	b2.acl.userProject = projectID
+	b2.defaultObjectACL.userProject = projectID
+	return &b2
+}
+
+// BucketConditions constrain bucket methods to act on specific metagenerations.
+//
+// The zero value is an empty set of constraints.
+type BucketConditions struct {
+	// MetagenerationMatch specifies that the bucket must have the given
+	// metageneration for the operation to occur.
+	// If MetagenerationMatch is zero, it has no effect.
+	MetagenerationMatch int64
+
+	// MetagenerationNotMatch specifies that the bucket must not have the given
+	// metageneration for the operation to occur.
+	// If MetagenerationNotMatch is zero, it has no effect.
+	MetagenerationNotMatch int64
+}
+
+func (c *BucketConditions) validate(method string) error {
+	if *c == (BucketConditions{}) {
+		return fmt.Errorf("storage: %s: empty conditions", method)
+	}
+	if c.MetagenerationMatch != 0 && c.MetagenerationNotMatch != 0 {
+		return fmt.Errorf("storage: %s: multiple conditions specified for metageneration", method)
+	}
+	return nil
+}
+
+// applyBucketConds modifies the provided call using the conditions in conds.
+// call is something that quacks like a *raw.WhateverCall.
+func applyBucketConds(method string, conds *BucketConditions, call interface{}) error {
+	if conds == nil {
+		return nil
+	}
+	if err := conds.validate(method); err != nil {
+		return err
+	}
+	cval := reflect.ValueOf(call)
+	switch {
+	case conds.MetagenerationMatch != 0:
+		if !setConditionField(cval, "IfMetagenerationMatch", conds.MetagenerationMatch) {
+			return fmt.Errorf("storage: %s: ifMetagenerationMatch not supported", method)
+		}
+	case conds.MetagenerationNotMatch != 0:
+		if !setConditionField(cval, "IfMetagenerationNotMatch", conds.MetagenerationNotMatch) {
+			return fmt.Errorf("storage: %s: ifMetagenerationNotMatch not supported", method)
+		}
 	}
+	return nil
 }
 
 // Attrs returns the metadata for the bucket.
 func (b *BucketHandle) Attrs(ctx context.Context) (*BucketAttrs, error) {
+	req := b.c.raw.Buckets.Get(b.name).Projection("full")
+	if b.userProject != "" {
+		req.UserProject(b.userProject)
+	}
+	if err := applyBucketConds("BucketHandle.Attrs", b.conds, req); err != nil {
+		return nil, err
+	}
 	var resp *raw.Bucket
 	var err error
-	err = runWithRetry(ctx, func() error {
-		resp, err = b.c.raw.Buckets.Get(b.name).Projection("full").Context(ctx).Do()
+	err = runWithRetry(ctx, b.retry, true, func() error {
+		resp, err = req.Context(ctx).Do()
 		return err
 	})
 	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
@@ -126,8 +239,208 @@ type BucketAttrs struct {
 	Created time.Time
 
 	// VersioningEnabled reports whether this bucket has versioning enabled.
-	// This field is read-only.
 	VersioningEnabled bool
+
+	// RequesterPays reports whether the bucket is a requester-pays bucket,
+	// whose operations are billed to the project of the requester, set with
+	// BucketHandle.UserProject.
+	RequesterPays bool
+
+	// Labels are the bucket's labels.
+	Labels map[string]string
+
+	// Lifecycle is the lifecycle configuration for objects in the bucket.
+	Lifecycle Lifecycle
+
+	// RetentionPolicy, if non-nil, prevents objects in the bucket from being
+	// deleted or replaced until they reach a minimum age.
+	RetentionPolicy *RetentionPolicy
+
+	// CORS is the bucket's Cross-Origin Resource Sharing (CORS) configuration.
+	CORS []CORS
+
+	// Website configures the bucket's behavior when it is served as a
+	// static website.
+	Website *BucketWebsite
+
+	// Logging configures access logging for the bucket.
+	Logging *BucketLogging
+
+	// Encryption configures the default encryption of the objects in the
+	// bucket.
+	Encryption *BucketEncryption
+}
+
+// Lifecycle is the lifecycle configuration for objects in the bucket.
+type Lifecycle struct {
+	Rules []LifecycleRule
+}
+
+// RetentionPolicy enforces a minimum retention time for all objects
+// contained in the bucket.
+//
+// Any attempt to overwrite or delete objects younger than the retention
+// period will result in an error. An unlocked retention policy can be
+// modified or removed from the bucket via the Update method. A locked
+// retention policy cannot be removed or shortened in duration for the
+// lifetime of the bucket.
+type RetentionPolicy struct {
+	// RetentionPeriod specifies the duration that objects need to be
+	// retained. Retention duration must be greater than zero and less than
+	// 100 years. It is stored with a resolution of one second.
+	RetentionPeriod time.Duration
+
+	// EffectiveTime is the time from which the policy was enforced and
+	// effective. This field is read-only.
+	EffectiveTime time.Time
+
+	// IsLocked describes whether the bucket is locked. Once locked, an
+	// object retention policy cannot be modified. This field is read-only.
+	IsLocked bool
+}
+
+const (
+	// RFC 3339 format for dates, with no time or time zone.
+	rfc3339Date = "2006-01-02"
+
+	// DeleteAction is a lifecycle action that deletes live and/or archived
+	// objects. Takes precedence over SetStorageClass actions.
+	DeleteAction = "Delete"
+
+	// SetStorageClassAction changes the storage class of live and/or archived
+	// objects.
+	SetStorageClassAction = "SetStorageClass"
+)
+
+// LifecycleRule is a lifecycle configuration rule.
+//
+// When all the configured conditions are met by an object in the bucket, the
+// configured action will automatically be taken on that object.
+type LifecycleRule struct {
+	// Action is the action to take when all of the associated conditions are
+	// met.
+	Action LifecycleAction
+
+	// Condition is the set of conditions that must be met for the associated
+	// action to be taken.
+	Condition LifecycleCondition
+}
+
+// LifecycleAction is a lifecycle configuration action.
+type LifecycleAction struct {
+	// Type is the type of action to take on matching objects.
+	//
+	// Acceptable values are "Delete" to delete matching objects and
+	// "SetStorageClass" to set the storage class defined in StorageClass on
+	// matching objects.
+	Type string
+
+	// StorageClass is the storage class to set on matching objects if the Action
+	// is "SetStorageClass".
+	StorageClass string
+}
+
+// Liveness specifies whether the object is live or not.
+type Liveness int
+
+const (
+	// LiveAndArchived includes both live and archived objects.
+	LiveAndArchived Liveness = iota
+	// Live specifies that the object is still live.
+	Live
+	// Archived specifies that the object is archived.
+	Archived
+)
+
+// LifecycleCondition is a set of conditions used to match objects and take an
+// action automatically.
+//
+// All configured conditions must be met for the associated action to be taken.
+type LifecycleCondition struct {
+	// AgeInDays is the age of the object in days.
+	AgeInDays int64
+
+	// CreatedBefore is the time the object was created.
+	//
+	// This condition is satisfied when an object is created before midnight of
+	// the specified date in UTC.
+	CreatedBefore time.Time
+
+	// Liveness specifies the object's liveness. Relevant only for versioned
+	// objects.
+	Liveness Liveness
+
+	// MatchesStorageClasses is the condition matching the object's storage
+	// class.
+	//
+	// Values include "MULTI_REGIONAL", "REGIONAL", "NEARLINE", "COLDLINE",
+	// "STANDARD", and "DURABLE_REDUCED_AVAILABILITY".
+	MatchesStorageClasses []string
+
+	// NumNewerVersions is the condition matching objects with a number of
+	// newer versions.
+	//
+	// If the value is N, this condition is satisfied when there are at least
+	// N versions (including the live version) newer than this version of the
+	// object.
+	NumNewerVersions int64
+}
+
+// BucketLogging holds the bucket's logging configuration, which defines the
+// destination bucket and optional name prefix for the current bucket's
+// logs.
+type BucketLogging struct {
+	// The destination bucket where the current bucket's logs
+	// should be placed.
+	LogBucket string
+
+	// A prefix for log object names.
+	LogObjectPrefix string
+}
+
+// BucketEncryption holds the bucket's default encryption configuration.
+type BucketEncryption struct {
+	// DefaultKMSKeyName is the resource name of the Cloud KMS key that
+	// encrypts objects written to the bucket without a key of their own.
+	DefaultKMSKeyName string
+}
+
+// BucketWebsite holds the bucket's website configuration, controlling how the
+// service behaves when accessing bucket contents as a web site. See
+// https://cloud.google.com/storage/docs/static-website for more information.
+type BucketWebsite struct {
+	// If the requested object path is missing, the service will ensure the
+	// path has a trailing '/', append this suffix, and attempt to retrieve
+	// the resulting object. This allows the creation of index.html objects
+	// to represent directory pages.
+	MainPageSuffix string
+
+	// If the requested object path is missing, and any mainPageSuffix object
+	// is missing, if applicable, the service will return the named object
+	// from this bucket as the content for a 404 Not Found result.
+	NotFoundPage string
+}
+
+// CORS is the bucket's Cross-Origin Resource Sharing (CORS) configuration.
+type CORS struct {
+	// MaxAge is the value to return in the Access-Control-Max-Age
+	// header used in preflight responses.
+	MaxAge time.Duration
+
+	// Methods is the list of HTTP methods on which to include CORS response
+	// headers, (GET, OPTIONS, POST, etc) Note: "*" is permitted in the list
+	// of methods, and means "any method".
+	Methods []string
+
+	// Origins is the list of Origins eligible to receive CORS response
+	// headers. Note: "*" is permitted in the list of origins, and means
+	// "any Origin".
+	Origins []string
+
+	// ResponseHeaders is the list of HTTP headers other than the simple
+	// response headers to give permission for the user-agent to share
+	// across domains.
+	ResponseHeaders []string
 }
 
 func newBucket(b *raw.Bucket) *BucketAttrs {
@@ -141,6 +454,14 @@ func newBucket(b *raw.Bucket) *BucketAttrs {
 		StorageClass:      b.StorageClass,
 		Created:           convertTime(b.TimeCreated),
 		VersioningEnabled: b.Versioning != nil && b.Versioning.Enabled,
+		RequesterPays:     b.Billing != nil && b.Billing.RequesterPays,
+		Labels:            b.Labels,
+		Lifecycle:         toLifecycle(b.Lifecycle),
+		RetentionPolicy:   toRetentionPolicy(b.RetentionPolicy),
+		CORS:              toCORS(b.Cors),
+		Website:           toBucketWebsite(b.Website),
+		Logging:           toBucketLogging(b.Logging),
+		Encryption:        toBucketEncryption(b.Encryption),
 	}
 	acl := make([]ACLRule, len(b.Acl))
 	for i, rule := range b.Acl {
@@ -174,12 +495,363 @@ func (b *BucketAttrs) toRawBucket() *raw.Bucket {
 		}
 	}
 	dACL := toRawObjectACL(b.DefaultObjectACL)
+	var v *raw.BucketVersioning
+	if b.VersioningEnabled {
+		v = &raw.BucketVersioning{Enabled: true}
+	}
+	var bb *raw.BucketBilling
+	if b.RequesterPays {
+		bb = &raw.BucketBilling{RequesterPays: true}
+	}
 	return &raw.Bucket{
 		Name:             b.Name,
 		DefaultObjectAcl: dACL,
 		Location:         b.Location,
 		StorageClass:     b.StorageClass,
 		Acl:              acl,
+		Versioning:       v,
+		Billing:          bb,
+		Labels:           b.Labels,
+		Lifecycle:        toRawLifecycle(b.Lifecycle),
+		RetentionPolicy:  b.RetentionPolicy.toRawRetentionPolicy(),
+		Cors:             toRawCORS(b.CORS),
+		Website:          b.Website.toRawBucketWebsite(),
+		Logging:          b.Logging.toRawBucketLogging(),
+		Encryption:       b.Encryption.toRawBucketEncryption(),
+	}
+}
+
+// Update updates a bucket's attributes, and returns the updated attributes.
+// Use BucketHandle.If to make the update conditional on the bucket's
+// metageneration.
+func (b *BucketHandle) Update(ctx context.Context, uattrs BucketAttrsToUpdate) (*BucketAttrs, error) {
+	req := b.c.raw.Buckets.Patch(b.name, uattrs.toRawBucket()).Projection("full")
+	if b.userProject != "" {
+		req.UserProject(b.userProject)
+	}
+	if err := applyBucketConds("BucketHandle.Update", b.conds, req); err != nil {
+		return nil, err
+	}
+	var rb *raw.Bucket
+	var err error
+	isIdempotent := b.conds != nil && b.conds.MetagenerationMatch != 0
+	err = runWithRetry(ctx, b.retry, isIdempotent, func() error {
+		rb, err = req.Context(ctx).Do()
+		return err
+	})
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+		return nil, ErrBucketNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newBucket(rb), nil
+}
+
+// LockRetentionPolicy locks a bucket's retention policy, which can then no
+// longer be removed or shortened. The handle must have a MetagenerationMatch
+// condition, to ensure that the policy being locked is the one last read.
+func (b *BucketHandle) LockRetentionPolicy(ctx context.Context) error {
+	if b.conds == nil || b.conds.MetagenerationMatch == 0 {
+		return errors.New("storage: LockRetentionPolicy requires a MetagenerationMatch condition")
+	}
+	req := b.c.raw.Buckets.LockRetentionPolicy(b.name, b.conds.MetagenerationMatch)
+	if b.userProject != "" {
+		req.UserProject(b.userProject)
+	}
+	return runWithRetry(ctx, b.retry, true, func() error {
+		_, err := req.Context(ctx).Do()
+		return err
+	})
+}
+
+// BucketAttrsToUpdate define the attributes to update during an Update call.
+type BucketAttrsToUpdate struct {
+	// If set, updates whether the bucket uses versioning.
+	VersioningEnabled optional.Bool
+
+	// If set, updates whether the bucket is a requester-pays bucket.
+	RequesterPays optional.Bool
+
+	// If set, replaces the CORS configuration with a new configuration.
+	// An empty (rather than nil) slice causes all CORS policies to be removed.
+	CORS []CORS
+
+	// If set, replaces the lifecycle configuration of the bucket. A
+	// Lifecycle with no rules removes it.
+	Lifecycle *Lifecycle
+
+	// If set, updates the retention policy of the bucket. Using
+	// RetentionPolicy.RetentionPeriod = 0 will delete the existing policy.
+	RetentionPolicy *RetentionPolicy
+
+	// If set, replaces the website configuration. The zero value removes it.
+	Website *BucketWebsite
+
+	// If set, replaces the logging configuration. The zero value removes it.
+	Logging *BucketLogging
+
+	// If set, replaces the default encryption configuration. The zero value
+	// removes it.
+	Encryption *BucketEncryption
+
+	setLabels    map[string]string
+	deleteLabels map[string]bool
+}
+
+// SetLabel causes a label to be added or modified when ua is used
+// in a call to Bucket.Update.
+func (ua *BucketAttrsToUpdate) SetLabel(name, value string) {
+	if ua.setLabels == nil {
+		ua.setLabels = map[string]string{}
+	}
+	ua.setLabels[name] = value
+}
+
+// DeleteLabel causes a label to be deleted when ua is used in a
+// call to Bucket.Update.
+func (ua *BucketAttrsToUpdate) DeleteLabel(name string) {
+	if ua.deleteLabels == nil {
+		ua.deleteLabels = map[string]bool{}
+	}
+	ua.deleteLabels[name] = true
+}
+
+func (ua *BucketAttrsToUpdate) toRawBucket() *raw.Bucket {
+	rb := &raw.Bucket{}
+	if ua.VersioningEnabled != nil {
+		rb.Versioning = &raw.BucketVersioning{
+			Enabled:         optional.ToBool(ua.VersioningEnabled),
+			ForceSendFields: []string{"Enabled"},
+		}
+	}
+	if ua.RequesterPays != nil {
+		rb.Billing = &raw.BucketBilling{
+			RequesterPays:   optional.ToBool(ua.RequesterPays),
+			ForceSendFields: []string{"RequesterPays"},
+		}
+	}
+	if ua.CORS != nil {
+		rb.Cors = toRawCORS(ua.CORS)
+		rb.ForceSendFields = append(rb.ForceSendFields, "Cors")
+	}
+	if ua.Lifecycle != nil {
+		rb.Lifecycle = toRawLifecycle(*ua.Lifecycle)
+		if rb.Lifecycle == nil {
+			rb.NullFields = append(rb.NullFields, "Lifecycle")
+		}
+	}
+	if ua.RetentionPolicy != nil {
+		if ua.RetentionPolicy.RetentionPeriod == 0 {
+			rb.NullFields = append(rb.NullFields, "RetentionPolicy")
+		} else {
+			rb.RetentionPolicy = ua.RetentionPolicy.toRawRetentionPolicy()
+		}
+	}
+	if ua.Website != nil {
+		if *ua.Website == (BucketWebsite{}) {
+			rb.NullFields = append(rb.NullFields, "Website")
+		} else {
+			rb.Website = ua.Website.toRawBucketWebsite()
+		}
+	}
+	if ua.Logging != nil {
+		if *ua.Logging == (BucketLogging{}) {
+			rb.NullFields = append(rb.NullFields, "Logging")
+		} else {
+			rb.Logging = ua.Logging.toRawBucketLogging()
+		}
+	}
+	if ua.Encryption != nil {
+		if *ua.Encryption == (BucketEncryption{}) {
+			rb.NullFields = append(rb.NullFields, "Encryption")
+		} else {
+			rb.Encryption = ua.Encryption.toRawBucketEncryption()
+		}
+	}
+	if ua.setLabels != nil || ua.deleteLabels != nil {
+		rb.Labels = map[string]string{}
+		for k, v := range ua.setLabels {
+			rb.Labels[k] = v
+		}
+		if len(rb.Labels) == 0 && len(ua.deleteLabels) > 0 {
+			rb.ForceSendFields = append(rb.ForceSendFields, "Labels")
+		}
+		for l := range ua.deleteLabels {
+			rb.NullFields = append(rb.NullFields, "Labels."+l)
+		}
+	}
+	return rb
+}
+
+func toRawLifecycle(l Lifecycle) *raw.BucketLifecycle {
+	if len(l.Rules) == 0 {
+		return nil
+	}
+	rl := &raw.BucketLifecycle{}
+	for _, r := range l.Rules {
+		rr := &raw.BucketLifecycleRule{
+			Action: &raw.BucketLifecycleRuleAction{
+				Type:         r.Action.Type,
+				StorageClass: r.Action.StorageClass,
+			},
+			Condition: &raw.BucketLifecycleRuleCondition{
+				Age:                 r.Condition.AgeInDays,
+				MatchesStorageClass: r.Condition.MatchesStorageClasses,
+				NumNewerVersions:    r.Condition.NumNewerVersions,
+			},
+		}
+		switch r.Condition.Liveness {
+		case Live:
+			rr.Condition.IsLive = googleapi.Bool(true)
+		case Archived:
+			rr.Condition.IsLive = googleapi.Bool(false)
+		}
+		if !r.Condition.CreatedBefore.IsZero() {
+			rr.Condition.CreatedBefore = r.Condition.CreatedBefore.Format(rfc3339Date)
+		}
+		rl.Rule = append(rl.Rule, rr)
+	}
+	return rl
+}
+
+func toLifecycle(rl *raw.BucketLifecycle) Lifecycle {
+	var l Lifecycle
+	if rl == nil {
+		return l
+	}
+	for _, rr := range rl.Rule {
+		r := LifecycleRule{}
+		if rr.Action != nil {
+			r.Action = LifecycleAction{
+				Type:         rr.Action.Type,
+				StorageClass: rr.Action.StorageClass,
+			}
+		}
+		if c := rr.Condition; c != nil {
+			r.Condition = LifecycleCondition{
+				AgeInDays:             c.Age,
+				MatchesStorageClasses: c.MatchesStorageClass,
+				NumNewerVersions:      c.NumNewerVersions,
+			}
+			switch {
+			case c.IsLive == nil:
+				r.Condition.Liveness = LiveAndArchived
+			case *c.IsLive:
+				r.Condition.Liveness = Live
+			default:
+				r.Condition.Liveness = Archived
+			}
+			if c.CreatedBefore != "" {
+				r.Condition.CreatedBefore, _ = time.Parse(rfc3339Date, c.CreatedBefore)
+			}
+		}
+		l.Rules = append(l.Rules, r)
+	}
+	return l
+}
+
+func (rp *RetentionPolicy) toRawRetentionPolicy() *raw.BucketRetentionPolicy {
+	if rp == nil {
+		return nil
+	}
+	return &raw.BucketRetentionPolicy{
+		RetentionPeriod: int64(rp.RetentionPeriod / time.Second),
+	}
+}
+
+func toRetentionPolicy(rp *raw.BucketRetentionPolicy) *RetentionPolicy {
+	if rp == nil {
+		return nil
+	}
+	return &RetentionPolicy{
+		RetentionPeriod: time.Duration(rp.RetentionPeriod) * time.Second,
+		EffectiveTime:   convertTime(rp.EffectiveTime),
+		IsLocked:        rp.IsLocked,
+	}
+}
+
+func toRawCORS(c []CORS) []*raw.BucketCors {
+	var out []*raw.BucketCors
+	for _, v := range c {
+		out = append(out, &raw.BucketCors{
+			MaxAgeSeconds:  int64(v.MaxAge / time.Second),
+			Method:         v.Methods,
+			Origin:         v.Origins,
+			ResponseHeader: v.ResponseHeaders,
+		})
+	}
+	return out
+}
+
+func toCORS(rc []*raw.BucketCors) []CORS {
+	var out []CORS
+	for _, v := range rc {
+		out = append(out, CORS{
+			MaxAge:          time.Duration(v.MaxAgeSeconds) * time.Second,
+			Methods:         v.Method,
+			Origins:         v.Origin,
+			ResponseHeaders: v.ResponseHeader,
+		})
+	}
+	return out
+}
+
+func (w *BucketWebsite) toRawBucketWebsite() *raw.BucketWebsite {
+	if w == nil {
+		return nil
+	}
+	return &raw.BucketWebsite{
+		MainPageSuffix: w.MainPageSuffix,
+		NotFoundPage:   w.NotFoundPage,
+	}
+}
+
+func toBucketWebsite(w *raw.BucketWebsite) *BucketWebsite {
+	if w == nil {
+		return nil
+	}
+	return &BucketWebsite{
+		MainPageSuffix: w.MainPageSuffix,
+		NotFoundPage:   w.NotFoundPage,
+	}
+}
+
+func (b *BucketLogging) toRawBucketLogging() *raw.BucketLogging {
+	if b == nil {
+		return nil
+	}
+	return &raw.BucketLogging{
+		LogBucket:       b.LogBucket,
+		LogObjectPrefix: b.LogObjectPrefix,
+	}
+}
+
+func toBucketLogging(b *raw.BucketLogging) *BucketLogging {
+	if b == nil {
+		return nil
+	}
+	return &BucketLogging{
+		LogBucket:       b.LogBucket,
+		LogObjectPrefix: b.LogObjectPrefix,
+	}
+}
+
+func (b *BucketEncryption) toRawBucketEncryption() *raw.BucketEncryption {
+	if b == nil {
+		return nil
+	}
+	return &raw.BucketEncryption{
+		DefaultKmsKeyName: b.DefaultKMSKeyName,
+	}
+}
+
+func toBucketEncryption(b *raw.BucketEncryption) *BucketEncryption {
+	if b == nil {
+		return nil
+	}
+	return &BucketEncryption{
+		DefaultKMSKeyName: b.DefaultKmsKeyName,
 	}
 }
 
@@ -208,6 +880,7 @@ type ObjectIterator struct {
 	pageInfo *iterator.PageInfo
 	nextFunc func() error
 	items    []*ObjectAttrs
+	glob     *regexp.Regexp // compiled from query.MatchGlob
 }
 
 // PageInfo supports pagination. See the google.golang.org/api/iterator package for details.
@@ -230,19 +903,46 @@ func (it *ObjectIterator) Next() (*ObjectAttrs, error) {
 }
 
 func (it *ObjectIterator) fetch(pageSize int, pageToken string) (string, error) {
+	prefix := it.query.Prefix
+	if it.query.MatchGlob != "" && it.glob == nil {
+		var err error
+		if it.glob, err = globToRegexp(it.query.MatchGlob); err != nil {
+			return "", err
+		}
+	}
+	if it.glob != nil {
+		// Narrow the listing to the names that can match.
+		if p := globPrefix(it.query.MatchGlob); strings.HasPrefix(p, prefix) {
+			prefix = p
+		}
+	}
 	req := it.bucket.c.raw.Objects.List(it.bucket.name)
+	if it.bucket.userProject != "" {
+		req.UserProject(it.bucket.userProject)
+	}
 	req.Projection("full")
 	req.Delimiter(it.query.Delimiter)
-	req.Prefix(it.query.Prefix)
+	req.Prefix(prefix)
 	req.Versions(it.query.Versions)
 	req.PageToken(pageToken)
 	if pageSize > 0 {
 		req.MaxResults(int64(pageSize))
 	}
+	if it.query.fieldSelection != "" {
+		req.Fields(googleapi.Field(it.query.fieldSelection))
+	}
+	// The raw client has no methods for these parameters.
+	var opts []googleapi.CallOption
+	if it.query.StartOffset != "" {
+		opts = append(opts, queryParam{"startOffset", it.query.StartOffset})
+	}
+	if it.query.EndOffset != "" {
+		opts = append(opts, queryParam{"endOffset", it.query.EndOffset})
+	}
 	var resp *raw.Objects
 	var err error
-	err = runWithRetry(it.ctx, func() error {
-		resp, err = req.Context(it.ctx).Do()
+	err = runWithRetry(it.ctx, it.bucket.retry, true, func() error {
+		resp, err = req.Context(it.ctx).Do(opts...)
 		return err
 	})
 	if err != nil {
@@ -252,6 +952,9 @@ func (it *ObjectIterator) fetch(pageSize int, pageToken string) (string, error)
 		return "", err
 	}
 	for _, item := range resp.Items {
+		if it.glob != nil && !it.glob.MatchString(item.Name) {
+			continue
+		}
 		it.items = append(it.items, newObject(item))
 	}
 	for _, prefix := range resp.Prefixes {
@@ -260,7 +963,13 @@ func (it *ObjectIterator) fetch(pageSize int, pageToken string) (string, error)
 	return resp.NextPageToken, nil
 }
 
-// TODO(jbd): Add storage.buckets.update.
+// queryParam is a googleapi.CallOption which sets a query parameter of a
+// request.
+type queryParam struct {
+	key, value string
+}
+
+func (p queryParam) Get() (string, string) { return p.key, p.value }
 
 // Buckets returns an iterator over the buckets in the project. You may
 // optionally set the iterator's Prefix field to restrict the list to buckets
@@ -317,7 +1026,7 @@ func (it *BucketIterator) fetch(pageSize int, pageToken string) (string, error)
 	}
 	var resp *raw.Buckets
 	var err error
-	err = runWithRetry(it.ctx, func() error {
+	err = runWithRetry(it.ctx, nil, true, func() error {
 		resp, err = req.Context(it.ctx).Do()
 		return err
 	})
@@ -43,8 +43,23 @@ type Copier struct {
 	// RewriteToken can be set before calling Run to resume a copy
 	// operation. After Run returns a non-nil error, RewriteToken will
 	// have been updated to contain the value needed to resume the copy.
+	//
+	// Run updates RewriteToken after each call to the service, before it
+	// calls ProgressFunc, so ProgressFunc can save it. A later Copier, even
+	// in another process, resumes the copy from where that call left off if
+	// it has the same source, destination, attributes and keys, and
+	// RewriteToken is set to the saved value. The service keeps the progress
+	// of a copy for a week. RewriteToken is empty after Run succeeds.
 	RewriteToken string
 
+	// MaxBytesRewrittenPerCall, if non-zero, limits the number of bytes the
+	// service copies in each call that Run makes, so that ProgressFunc is
+	// called, and RewriteToken updated, more often. It must be a multiple of
+	// 1 MiB (1048576). It only has an effect on copies that the service
+	// cannot complete by copying metadata alone, such as those to another
+	// location or storage class, or with a change of encryption key.
+	MaxBytesRewrittenPerCall int64
+
 	// ProgressFunc can be used to monitor the progress of a multi-RPC copy
 	// operation. If ProgressFunc is not nil and CopyFrom requires multiple
 	// calls to the underlying service (see
@@ -60,6 +75,20 @@ type Copier struct {
 	// ProgressFunc should return quickly without blocking.
 	ProgressFunc func(copiedBytes, totalBytes uint64)
 
+	// DestinationKMSKeyName is the resource name of the Cloud KMS key with
+	// which to encrypt the destination object. If it is empty, and dst has
+	// no customer-supplied encryption key, the destination is encrypted with
+	// the key named by ObjectAttrs.KMSKeyName, as when the attributes are
+	// copied from a source encrypted with a KMS key, or else with the
+	// default key of its bucket. It is an error to set it when dst has a
+	// customer-supplied encryption key. Key versions appended to the names
+	// of keys are ignored.
+	//
+	// Copying an object to itself with a different key rewrites its data
+	// under the new key. A source encrypted with a customer-supplied key
+	// must have that key set on src.
+	DestinationKMSKeyName string
+
 	dst, src *ObjectHandle
 }
 
@@ -71,6 +100,16 @@ func (c *Copier) Run(ctx context.Context) (*ObjectAttrs, error) {
 	if err := c.dst.validate(); err != nil {
 		return nil, err
 	}
+	if c.DestinationKMSKeyName != "" && c.dst.encryptionKey != nil {
+		return nil, errors.New("storage: cannot use DestinationKMSKeyName with a customer-supplied encryption key")
+	}
+	kmsKey := c.DestinationKMSKeyName
+	if kmsKey == "" && c.dst.encryptionKey == nil {
+		// Attributes copied from a source encrypted with a KMS key keep
+		// the destination under the same key.
+		kmsKey = c.ObjectAttrs.KMSKeyName
+	}
+	kmsKey = kmsKeyWithoutVersion(kmsKey)
 	var rawObject *raw.Object
 	// If any attribute was set, then we make sure the name matches the destination
 	// name, and we check that ContentType is non-empty so we can provide a better
@@ -83,7 +122,7 @@ func (c *Copier) Run(ctx context.Context) (*ObjectAttrs, error) {
 		rawObject = c.ObjectAttrs.toRawObject(c.dst.bucket)
 	}
 	for {
-		res, err := c.callRewrite(ctx, c.src, rawObject)
+		res, err := c.callRewrite(ctx, c.src, rawObject, kmsKey)
 		if err != nil {
 			return nil, err
 		}
@@ -91,19 +130,32 @@ func (c *Copier) Run(ctx context.Context) (*ObjectAttrs, error) {
 			c.ProgressFunc(res.TotalBytesRewritten, res.ObjectSize)
 		}
 		if res.Done { // Finished successfully.
+			c.RewriteToken = ""
 			return newObject(res.Resource), nil
 		}
 	}
 	return nil, nil
 }
 
-func (c *Copier) callRewrite(ctx context.Context, src *ObjectHandle, rawObj *raw.Object) (*raw.RewriteResponse, error) {
+func (c *Copier) callRewrite(ctx context.Context, src *ObjectHandle, rawObj *raw.Object, kmsKey string) (*raw.RewriteResponse, error) {
 	call := c.dst.c.raw.Objects.Rewrite(src.bucket, src.object, c.dst.bucket, c.dst.object, rawObj)
 
 	call.Context(ctx).Projection("full")
 	if c.RewriteToken != "" {
 		call.RewriteToken(c.RewriteToken)
 	}
+	if kmsKey != "" {
+		call.DestinationKmsKeyName(kmsKey)
+	}
+	if c.MaxBytesRewrittenPerCall != 0 {
+		call.MaxBytesRewrittenPerCall(c.MaxBytesRewrittenPerCall)
+	}
+	// A copy is billed to one project: that of the destination, if set.
+	if c.dst.userProject != "" {
+		call.UserProject(c.dst.userProject)
+	} else if c.src.userProject != "" {
+		call.UserProject(c.src.userProject)
+	}
 	if err := applyConds("Copy destination", c.dst.gen, c.dst.conds, call); err != nil {
 		return nil, err
 	}
@@ -118,7 +170,7 @@ func (c *Copier) callRewrite(ctx context.Context, src *ObjectHandle, rawObj *raw
 	}
 	var res *raw.RewriteResponse
 	var err error
-	err = runWithRetry(ctx, func() error { res, err = call.Do(); return err })
+	err = runWithRetry(ctx, c.dst.retry, c.dst.isGenerationConditional(), func() error { res, err = call.Do(); return err })
 	if err != nil {
 		return nil, err
 	}
@@ -184,12 +236,15 @@ func (c *Composer) Run(ctx context.Context) (*ObjectAttrs, error) {
 	if err := applyConds("ComposeFrom destination", c.dst.gen, c.dst.conds, call); err != nil {
 		return nil, err
 	}
+	if c.dst.userProject != "" {
+		call.UserProject(c.dst.userProject)
+	}
 	if err := setEncryptionHeaders(call.Header(), c.dst.encryptionKey, false); err != nil {
 		return nil, err
 	}
 	var obj *raw.Object
 	var err error
-	err = runWithRetry(ctx, func() error { obj, err = call.Do(); return err })
+	err = runWithRetry(ctx, c.dst.retry, c.dst.isGenerationConditional(), func() error { obj, err = call.Do(); return err })
 	if err != nil {
 		return nil, err
 	}
@@ -19,9 +19,11 @@ Google Cloud Storage stores data in named objects, which are grouped into bucket
 More information about Google Cloud Storage is available at
 https://cloud.google.com/storage/docs.
 
-All of the methods of this package use exponential backoff to retry calls
+The methods of this package use exponential backoff to retry idempotent calls
 that fail with certain errors, as described in
-https://cloud.google.com/storage/docs/exponential-backoff.
+https://cloud.google.com/storage/docs/exponential-backoff. Use the Retryer
+methods of ObjectHandle and BucketHandle to configure retries; see RetryPolicy
+for which calls are idempotent.
 
 Note: This package is in beta.  Some backwards-incompatible changes may occur.
 
@@ -141,11 +143,31 @@ since you read it. Here is how to express that:
     w = obj.If(storage.Conditions{GenerationMatch: objAttrs.Generation}).NewWriter(ctx)
     // Proceed with writing as above.
 
+Requester Pays
+
+The operations of a requester-pays bucket are billed to the project of whoever
+makes them. Name the project with BucketHandle.UserProject; the handle, and
+the object handles it creates, bill it for every request:
+
+    bkt := client.Bucket("shared-bucket").UserProject("my-project")
+    rc, err := bkt.Object("data.csv").NewReader(ctx)
+
+HMAC Keys
+
+HMAC keys let tools written for Amazon S3 sign requests to the XML API. Manage
+the keys of a project's service accounts with Client.CreateHMACKey,
+Client.HMACKeys and Client.HMACKeyHandle. To read and write objects with
+requests signed with a key, rather than OAuth2 credentials, create the client
+with NewHMACClient:
+
+    hc, err := storage.NewHMACClient(ctx, key.AccessID, key.Secret)
+
 Signed URLs
 
 You can obtain a URL that lets anyone read or write an object for a limited time.
 You don't need to create a client to do this. See the documentation of
-SignedURL for details.
+SignedURL for details. To let a browser upload an object with an HTML form, see
+GenerateSignedPostPolicy.
 
     url, err := storage.SignedURL(bucketName, "shared-object", opts)
     if err != nil {
@@ -15,6 +15,7 @@
 package storage_test
 
 import (
+	"archive/zip"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -152,6 +153,46 @@ func ExampleObjectIterator_Next() {
 	}
 }
 
+func ExampleQuery_SetAttrSelection() {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	q := &storage.Query{MatchGlob: "logs/**/*.gz"}
+	// Fetch only the attributes that are needed.
+	if err := q.SetAttrSelection([]string{"Name", "Size"}); err != nil {
+		// TODO: handle error.
+	}
+	it := client.Bucket("my-bucket").Objects(ctx, q)
+	for {
+		objAttrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			// TODO: Handle error.
+		}
+		fmt.Println(objAttrs.Name, objAttrs.Size)
+	}
+}
+
+func ExampleBucketHandle_FileSystem() {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	fsys := client.Bucket("my-bucket").FileSystem(ctx)
+	infos, err := fsys.ReadDir("logs/2017")
+	if err != nil {
+		// TODO: handle error.
+	}
+	for _, info := range infos {
+		fmt.Println(info.Name(), info.Size(), info.IsDir())
+	}
+}
+
 func ExampleSignedURL() {
 	pkey, err := ioutil.ReadFile("my-private-key.pem")
 	if err != nil {
@@ -169,6 +210,82 @@ func ExampleSignedURL() {
 	fmt.Println(url)
 }
 
+func ExampleGenerateSignedPostPolicy() {
+	pkey, err := ioutil.ReadFile("my-private-key.pem")
+	if err != nil {
+		// TODO: handle error.
+	}
+	policy, err := storage.GenerateSignedPostPolicy("my-bucket", "uploads/my-object", &storage.PostPolicyOptions{
+		GoogleAccessID: "xxx@developer.gserviceaccount.com",
+		PrivateKey:     pkey,
+		Expires:        time.Now().Add(time.Hour),
+		Conditions: []storage.PostPolicyCondition{
+			storage.ConditionContentLengthRange(0, 10<<20),
+		},
+	})
+	if err != nil {
+		// TODO: handle error.
+	}
+	// Render an HTML form which posts the policy's fields, followed by a
+	// "file" field, to policy.URL.
+	fmt.Println(policy.URL, policy.Fields)
+}
+
+func ExampleSyncer_Upload() {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	s := client.Bucket("my-bucket").Syncer("build/artifacts", "artifacts/")
+	s.Delete = true
+	s.Exclude = []string{"*.tmp"}
+	actions, err := s.Upload(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	for _, a := range actions {
+		fmt.Println(a.Op, a.Path)
+	}
+}
+
+func ExampleBatch_Run() {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	bkt := client.Bucket("my-bucket")
+	batch := client.Batch()
+	var ops []*storage.BatchOp
+	for _, name := range []string{"obj1", "obj2", "obj3"} {
+		ops = append(ops, batch.Update(bkt.Object(name), storage.ObjectAttrsToUpdate{
+			CacheControl: "no-cache",
+		}))
+	}
+	if err := batch.Run(ctx); err != nil {
+		// TODO: handle error.
+	}
+	for _, op := range ops {
+		if err := op.Err(); err != nil {
+			// TODO: handle error.
+		}
+	}
+}
+
+func ExampleBucketHandle_DeletePrefix() {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	n, err := client.Bucket("my-bucket").DeletePrefix(ctx, "tmp/")
+	if err != nil {
+		// TODO: handle error.
+	}
+	fmt.Printf("deleted %d objects\n", n)
+}
+
 func ExampleObjectHandle_Attrs() {
 	ctx := context.Background()
 	client, err := storage.NewClient(ctx)
@@ -258,6 +375,46 @@ func ExampleObjectHandle_NewRangeReader() {
 	fmt.Println("first 64K of file contents:", slurp)
 }
 
+func ExampleObjectHandle_NewSeekableReader() {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	r, err := client.Bucket("bucketname").Object("archive.zip").NewSeekableReader(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	defer r.Close()
+	// List the files in the archive, reading only its directory.
+	zr, err := zip.NewReader(r, r.Size())
+	if err != nil {
+		// TODO: handle error.
+	}
+	for _, f := range zr.File {
+		fmt.Println(f.Name)
+	}
+}
+
+func ExampleObjectHandle_Retryer() {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	// Retry every operation, even those that are not idempotent, at most
+	// five times, and log each retry.
+	obj := client.Bucket("bucketname").Object("filename1").Retryer(
+		storage.WithPolicy(storage.RetryAlways),
+		storage.WithMaxAttempts(5),
+		storage.WithRetryHook(func(attempt int, err error) {
+			log.Printf("attempt %d failed: %v", attempt, err)
+		}))
+	if _, err := obj.Update(ctx, storage.ObjectAttrsToUpdate{ContentType: "text/plain"}); err != nil {
+		// TODO: handle error.
+	}
+}
+
 func ExampleObjectHandle_NewWriter() {
 	ctx := context.Background()
 	client, err := storage.NewClient(ctx)
@@ -416,6 +573,79 @@ func ExampleObjectHandle_CopierFrom_rotateEncryptionKeys() {
 	}
 }
 
+func ExampleObjectHandle_CopierFrom_rotateKMSKeys() {
+	// To move every object in a bucket to a new Cloud KMS key, rewrite each
+	// one onto itself. Run continues the rewrite until it is done, however
+	// many calls the service needs.
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	const newKey = "projects/my-project/locations/us/keyRings/my-ring/cryptoKeys/my-key"
+	bkt := client.Bucket("bucketname")
+	it := bkt.Objects(ctx, nil)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			// TODO: handle error.
+		}
+		obj := bkt.Object(attrs.Name)
+		// Only replace the generation that was listed.
+		c := obj.If(storage.Conditions{GenerationMatch: attrs.Generation}).CopierFrom(obj)
+		c.DestinationKMSKeyName = newKey
+		if _, err := c.Run(ctx); err != nil {
+			// TODO: handle error.
+		}
+	}
+}
+
+func ExampleNewHMACClient() {
+	// Create an HMAC key for a service account. Its secret is only returned
+	// now, so store it safely.
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	key, err := client.CreateHMACKey(ctx, "my-project", "robot@my-project.iam.gserviceaccount.com")
+	if err != nil {
+		// TODO: handle error.
+	}
+
+	// Read and write objects with requests signed with the key.
+	hc, err := storage.NewHMACClient(ctx, key.AccessID, key.Secret)
+	if err != nil {
+		// TODO: handle error.
+	}
+	w := hc.Bucket("bucketname").Object("filename1").NewWriter(ctx)
+	if _, err := w.Write([]byte("hello world")); err != nil {
+		// TODO: handle error.
+	}
+	if err := w.Close(); err != nil {
+		// TODO: handle error.
+	}
+}
+
+func ExampleHMACKeyHandle_Delete() {
+	// Only inactive keys can be deleted.
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	h := client.HMACKeyHandle("my-project", "GOOG1EXAMPLEACCESSID")
+	if _, err := h.Update(ctx, storage.HMACKeyAttrsToUpdate{State: storage.Inactive}); err != nil {
+		// TODO: handle error.
+	}
+	if err := h.Delete(ctx); err != nil {
+		// TODO: handle error.
+	}
+}
+
 func ExampleComposer_Run() {
 	ctx := context.Background()
 	client, err := storage.NewClient(ctx)
@@ -499,3 +729,126 @@ func ExampleObjectHandle_Key() {
 		// TODO: handle error.
 	}
 }
+
+func ExampleParallelUploader_Run() {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	f, err := os.Open("backup.tar")
+	if err != nil {
+		// TODO: handle error.
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		// TODO: handle error.
+	}
+	obj := client.Bucket("bucketname").Object("backup.tar")
+	u := obj.ParallelUploaderFrom(f, fi.Size())
+	u.Parallelism = 16
+	attrs, err := u.Run(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	fmt.Println(attrs)
+}
+
+func ExampleParallelDownloader_Run() {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	f, err := os.Create("backup.tar")
+	if err != nil {
+		// TODO: handle error.
+	}
+	obj := client.Bucket("bucketname").Object("backup.tar")
+	if _, err := obj.ParallelDownloaderTo(f).Run(ctx); err != nil {
+		// TODO: handle error.
+	}
+	if err := f.Close(); err != nil {
+		// TODO: handle error.
+	}
+}
+
+func ExampleBucketHandle_Update() {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	b := client.Bucket("my-bucket")
+	attrs, err := b.Attrs(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	// Enable versioning, and delete archived versions after a month. The
+	// update only succeeds if the bucket hasn't changed since it was read.
+	ua := storage.BucketAttrsToUpdate{
+		VersioningEnabled: true,
+		Lifecycle: &storage.Lifecycle{Rules: []storage.LifecycleRule{{
+			Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: storage.LifecycleCondition{AgeInDays: 30, Liveness: storage.Archived},
+		}}},
+	}
+	ua.SetLabel("team", "ingest")
+	attrs, err = b.If(storage.BucketConditions{MetagenerationMatch: attrs.MetaGeneration}).Update(ctx, ua)
+	if err != nil {
+		// TODO: handle error.
+	}
+	fmt.Println(attrs)
+}
+
+func ExampleBucketHandle_AddNotification() {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	b := client.Bucket("my-bucket")
+	n, err := b.AddNotification(ctx, &storage.Notification{
+		TopicProjectID: "my-project",
+		TopicID:        "my-topic",
+		EventTypes:     []string{storage.ObjectFinalizeEvent},
+		PayloadFormat:  storage.JSONPayload,
+	})
+	if err != nil {
+		// TODO: handle error.
+	}
+	fmt.Println(n.ID)
+}
+
+func ExampleBucketHandle_IAM() {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	h := client.Bucket("my-bucket").IAM()
+	policy, err := h.Policy(ctx)
+	if err != nil {
+		// TODO: handle error.
+	}
+	policy.Add("group:readers@example.com", "roles/storage.objectViewer")
+	if err := h.SetPolicy(ctx, policy); err != nil {
+		// TODO: handle error.
+	}
+}
+
+func ExampleDecodeNotification() {
+	// In a Cloud PubSub receive callback, m is a *pubsub.Message.
+	var m struct {
+		Attributes map[string]string
+		Data       []byte
+	}
+	e, err := storage.DecodeNotification(m.Attributes, m.Data)
+	if err != nil {
+		// TODO: handle error.
+	}
+	if e.Type == storage.ObjectFinalizeEvent {
+		fmt.Printf("created gs://%s/%s\n", e.Bucket, e.Object)
+	}
+}
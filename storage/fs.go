@@ -0,0 +1,405 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	errIsDir   = errors.New("is a directory")
+	errNotDir  = errors.New("not a directory")
+	errClosed  = errors.New("file already closed")
+	errBadSeek = errors.New("negative position")
+)
+
+// FileSystem returns a FileSystem that presents the objects in b as a tree of
+// files. All its operations use ctx.
+func (b *BucketHandle) FileSystem(ctx context.Context) *FileSystem {
+	return &FileSystem{b: b, ctx: ctx}
+}
+
+// A FileSystem presents the objects in a bucket as a tree of files and
+// directories, in the manner of the io/fs package of Go 1.16 and later. With
+// that version, the IOFS method returns an fs.FS for the FileSystem. Files are
+// read-only, but the Create method writes objects.
+//
+// Names are slash-separated paths, such as "a/b/c", that are unrooted and
+// have no "." or ".." elements or empty elements; the root is ".". A file is
+// an object with the same name. A directory exists wherever there are objects
+// whose names begin with its path followed by "/"; objects whose names are
+// not valid paths, including those ending in "/", are not files. If there is
+// both an object and a directory with the same name, the FileSystem presents
+// only the object.
+//
+// Errors are of type *os.PathError. The error of an operation on a name that
+// does not exist is os.ErrNotExist, and that of an invalid name is
+// os.ErrInvalid.
+type FileSystem struct {
+	b   *BucketHandle
+	ctx context.Context
+}
+
+// validPath reports whether name is a valid name for a FileSystem.
+func validPath(name string) bool {
+	if !utf8.ValidString(name) {
+		return false
+	}
+	if name == "." {
+		return true
+	}
+	for {
+		i := strings.IndexByte(name, '/')
+		if i < 0 {
+			i = len(name)
+		}
+		switch name[:i] {
+		case "", ".", "..":
+			return false
+		}
+		if i == len(name) {
+			return true
+		}
+		name = name[i+1:]
+	}
+}
+
+func pathError(op, name string, err error) error {
+	if err == ErrObjectNotExist {
+		err = os.ErrNotExist
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// Open opens the named file or directory for reading.
+func (fsys *FileSystem) Open(name string) (*File, error) {
+	info, err := fsys.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{fsys: fsys, name: name, info: info}
+	if !info.dir {
+		// Read the generation that was opened, even if the object is
+		// replaced.
+		f.obj = fsys.b.Object(name).Generation(info.attrs.Generation)
+	}
+	return f, nil
+}
+
+// Stat returns information about the named file or directory. The Sys method
+// of the result returns the *ObjectAttrs of a file, and nil for a directory.
+func (fsys *FileSystem) Stat(name string) (os.FileInfo, error) {
+	info, err := fsys.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (fsys *FileSystem) stat(op, name string) (*fileInfo, error) {
+	if !validPath(name) {
+		return nil, pathError(op, name, os.ErrInvalid)
+	}
+	if name == "." {
+		return &fileInfo{name: ".", dir: true}, nil
+	}
+	attrs, err := fsys.b.Object(name).Attrs(fsys.ctx)
+	if err == nil {
+		return newFileInfo(attrs), nil
+	}
+	if err != ErrObjectNotExist {
+		return nil, pathError(op, name, err)
+	}
+	// Look for a single object in the directory.
+	it := fsys.b.Objects(fsys.ctx, &Query{Prefix: name + "/"})
+	it.PageInfo().MaxSize = 1
+	if _, err := it.Next(); err != nil {
+		if err == iterator.Done {
+			err = os.ErrNotExist
+		}
+		return nil, pathError(op, name, err)
+	}
+	return &fileInfo{name: path.Base(name), dir: true}, nil
+}
+
+// ReadDir returns the files and directories in the named directory, sorted
+// by name.
+func (fsys *FileSystem) ReadDir(name string) ([]os.FileInfo, error) {
+	if !validPath(name) {
+		return nil, pathError("readdir", name, os.ErrInvalid)
+	}
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+	it := fsys.b.Objects(fsys.ctx, &Query{Prefix: prefix, Delimiter: "/"})
+	var infos []os.FileInfo
+	files := make(map[string]bool)
+	var dirs []string
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, pathError("readdir", name, err)
+		}
+		if attrs.Prefix != "" {
+			dirs = append(dirs, strings.TrimSuffix(attrs.Prefix[len(prefix):], "/"))
+			continue
+		}
+		if base := attrs.Name[len(prefix):]; validPath(base) {
+			files[base] = true
+			infos = append(infos, newFileInfo(attrs))
+		}
+	}
+	for _, d := range dirs {
+		if validPath(d) && !files[d] {
+			infos = append(infos, &fileInfo{name: d, dir: true})
+		}
+	}
+	if len(infos) == 0 && name != "." {
+		// Distinguish an empty listing from a file or a missing directory.
+		info, err := fsys.stat("readdir", name)
+		switch {
+		case err != nil:
+			return nil, err
+		case !info.dir:
+			return nil, pathError("readdir", name, errNotDir)
+		}
+	}
+	sort.Sort(byName(infos))
+	return infos, nil
+}
+
+type byName []os.FileInfo
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i].Name() < a[j].Name() }
+
+// Create returns a Writer that creates or replaces the object for the named
+// file when it is closed. As for ObjectHandle.NewWriter, the attributes of
+// the object can be set before the first call to Write.
+func (fsys *FileSystem) Create(name string) (*Writer, error) {
+	if !validPath(name) || name == "." {
+		return nil, pathError("create", name, os.ErrInvalid)
+	}
+	return fsys.b.Object(name).NewWriter(fsys.ctx), nil
+}
+
+// fileInfo implements os.FileInfo for objects and directories.
+type fileInfo struct {
+	name  string
+	dir   bool
+	attrs *ObjectAttrs // files only
+}
+
+func newFileInfo(attrs *ObjectAttrs) *fileInfo {
+	return &fileInfo{name: path.Base(attrs.Name), attrs: attrs}
+}
+
+func (fi *fileInfo) Name() string { return fi.name }
+func (fi *fileInfo) IsDir() bool  { return fi.dir }
+
+func (fi *fileInfo) Size() int64 {
+	if fi.dir {
+		return 0
+	}
+	return fi.attrs.Size
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	if fi.dir {
+		return time.Time{}
+	}
+	return fi.attrs.Updated
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	if fi.dir {
+		return nil
+	}
+	return fi.attrs
+}
+
+// A File is a file or directory opened by a FileSystem. A File which is a
+// file implements io.ReadSeeker and io.ReaderAt; each read after a seek
+// starts a new request for the rest of the object. A File is not safe for
+// concurrent use, except for ReadAt.
+type File struct {
+	fsys   *FileSystem
+	name   string
+	info   *fileInfo
+	closed bool
+
+	// For files.
+	obj *ObjectHandle
+	r   *Reader // nil until read, and after seeking
+	off int64
+
+	// For directories.
+	listed  bool
+	entries []os.FileInfo // those not yet returned by Readdir
+}
+
+// Stat returns information about the file or directory, as of when it was
+// opened.
+func (f *File) Stat() (os.FileInfo, error) {
+	if f.closed {
+		return nil, pathError("stat", f.name, errClosed)
+	}
+	return f.info, nil
+}
+
+// Read reads up to len(p) bytes from the file.
+func (f *File) Read(p []byte) (int, error) {
+	if err := f.checkFile("read"); err != nil {
+		return 0, err
+	}
+	if f.off >= f.info.Size() {
+		return 0, io.EOF
+	}
+	if f.r == nil {
+		r, err := f.obj.NewRangeReader(f.fsys.ctx, f.off, -1)
+		if err != nil {
+			return 0, pathError("read", f.name, err)
+		}
+		f.r = r
+	}
+	n, err := f.r.Read(p)
+	f.off += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes from the file starting at offset off.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.checkFile("read"); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, pathError("read", f.name, errBadSeek)
+	}
+	size := f.info.Size()
+	if off >= size {
+		return 0, io.EOF
+	}
+	want := int64(len(p))
+	if off+want > size {
+		want = size - off
+	}
+	r, err := f.obj.NewRangeReader(f.fsys.ctx, off, want)
+	if err != nil {
+		return 0, pathError("read", f.name, err)
+	}
+	defer r.Close()
+	n, err := io.ReadFull(r, p[:want])
+	if err == nil && want < int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read, as described by io.Seeker.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if err := f.checkFile("seek"); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case os.SEEK_SET:
+	case os.SEEK_CUR:
+		offset += f.off
+	case os.SEEK_END:
+		offset += f.info.Size()
+	default:
+		return 0, pathError("seek", f.name, os.ErrInvalid)
+	}
+	if offset < 0 {
+		return 0, pathError("seek", f.name, errBadSeek)
+	}
+	if offset != f.off && f.r != nil {
+		f.r.Close()
+		f.r = nil
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// Readdir reads the contents of the directory, sorted by name, in the manner
+// of os.File.Readdir: if n > 0, it returns at most n entries, and io.EOF at
+// the end of the directory; otherwise it returns all the remaining entries.
+func (f *File) Readdir(n int) ([]os.FileInfo, error) {
+	if f.closed {
+		return nil, pathError("readdir", f.name, errClosed)
+	}
+	if !f.info.dir {
+		return nil, pathError("readdir", f.name, errNotDir)
+	}
+	if !f.listed {
+		entries, err := f.fsys.ReadDir(f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.entries, f.listed = entries, true
+	}
+	if n <= 0 || n > len(f.entries) {
+		if n > 0 && len(f.entries) == 0 {
+			return nil, io.EOF
+		}
+		n = len(f.entries)
+	}
+	entries := f.entries[:n]
+	f.entries = f.entries[n:]
+	return entries, nil
+}
+
+// Close closes the file or directory.
+func (f *File) Close() error {
+	if f.closed {
+		return pathError("close", f.name, errClosed)
+	}
+	f.closed = true
+	if f.r != nil {
+		return f.r.Close()
+	}
+	return nil
+}
+
+func (f *File) checkFile(op string) error {
+	if f.closed {
+		return pathError(op, f.name, errClosed)
+	}
+	if f.info.dir {
+		return pathError(op, f.name, errIsDir)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.16
+
+package storage
+
+import "io/fs"
+
+// IOFS returns an fs.FS for the file system, which also implements
+// fs.StatFS and fs.ReadDirFS. The files it opens implement io.Seeker and
+// io.ReaderAt, and its directories implement fs.ReadDirFile.
+func (fsys *FileSystem) IOFS() fs.FS {
+	return ioFS{fsys}
+}
+
+type ioFS struct {
+	fsys *FileSystem
+}
+
+func (f ioFS) Open(name string) (fs.File, error) {
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioFile{file}, nil
+}
+
+func (f ioFS) Stat(name string) (fs.FileInfo, error) {
+	return f.fsys.Stat(name)
+}
+
+func (f ioFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	infos, err := f.fsys.ReadDir(name)
+	return dirEntries(infos), err
+}
+
+type ioFile struct {
+	*File
+}
+
+func (f ioFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	infos, err := f.Readdir(n)
+	return dirEntries(infos), err
+}
+
+func dirEntries(infos []fs.FileInfo) []fs.DirEntry {
+	entries := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = dirEntry{info}
+	}
+	return entries
+}
+
+// dirEntry implements fs.DirEntry.
+type dirEntry struct {
+	fs.FileInfo
+}
+
+func (e dirEntry) Type() fs.FileMode          { return e.Mode().Type() }
+func (e dirEntry) Info() (fs.FileInfo, error) { return e.FileInfo, nil }
@@ -0,0 +1,256 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+	raw "google.golang.org/api/storage/v1"
+)
+
+// HMACState is the state of an HMAC key.
+type HMACState string
+
+const (
+	// Active is the state of a key that can be used to sign requests.
+	Active HMACState = "ACTIVE"
+
+	// Inactive is the state of a key that cannot be used to sign requests,
+	// but can be made active again. Only inactive keys can be deleted.
+	Inactive HMACState = "INACTIVE"
+
+	// Deleted is the state of a key that has been deleted. Deleted keys are
+	// only listed if HMACKeyIterator.ShowDeletedKeys is set.
+	Deleted HMACState = "DELETED"
+)
+
+// HMACKey is an HMAC key of a service account, with which requests to the XML
+// API can be signed, as those of Amazon S3 are. See
+// https://cloud.google.com/storage/docs/authentication/hmackeys and
+// NewHMACClient.
+type HMACKey struct {
+	// Secret is the secret of the key. It is only set in the HMACKey
+	// returned by Client.CreateHMACKey; the service reveals it only then.
+	Secret string
+
+	// AccessID identifies the key. It is not secret.
+	AccessID string
+
+	// ID is the resource ID of the key, of the form "projectID/accessID".
+	ID string
+
+	// ProjectID is the project that owns the service account of the key.
+	ProjectID string
+
+	// ServiceAccountEmail is the email address of the service account whose
+	// permissions requests signed with the key have.
+	ServiceAccountEmail string
+
+	// State is the state of the key.
+	State HMACState
+
+	// Etag identifies the version of the key's metadata, for use with
+	// HMACKeyAttrsToUpdate.
+	Etag string
+
+	// Created is the time the key was created.
+	Created time.Time
+
+	// Updated is the time the key's metadata was last changed.
+	Updated time.Time
+}
+
+func newHMACKey(m *raw.HmacKeyMetadata, secret string) *HMACKey {
+	if m == nil {
+		return nil
+	}
+	return &HMACKey{
+		Secret:              secret,
+		AccessID:            m.AccessId,
+		ID:                  m.Id,
+		ProjectID:           m.ProjectId,
+		ServiceAccountEmail: m.ServiceAccountEmail,
+		State:               HMACState(m.State),
+		Etag:                m.Etag,
+		Created:             convertTime(m.TimeCreated),
+		Updated:             convertTime(m.Updated),
+	}
+}
+
+// CreateHMACKey creates a new HMAC key for the service account with the given
+// email address, in the given project. The Secret of the returned key cannot
+// be retrieved again.
+func (c *Client) CreateHMACKey(ctx context.Context, projectID, serviceAccountEmail string) (*HMACKey, error) {
+	if projectID == "" {
+		return nil, errors.New("storage: CreateHMACKey: missing projectID")
+	}
+	if serviceAccountEmail == "" {
+		return nil, errors.New("storage: CreateHMACKey: missing serviceAccountEmail")
+	}
+	call := c.raw.Projects.HmacKeys.Create(projectID, serviceAccountEmail)
+	var key *raw.HmacKey
+	var err error
+	// Each attempt would create a new key.
+	err = runWithRetry(ctx, nil, false, func() error {
+		key, err = call.Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newHMACKey(key.Metadata, key.Secret), nil
+}
+
+// HMACKeyHandle returns a handle for the HMAC key with the given access ID,
+// in the given project. This call does not perform any network operations.
+func (c *Client) HMACKeyHandle(projectID, accessID string) *HMACKeyHandle {
+	return &HMACKeyHandle{c: c, projectID: projectID, accessID: accessID}
+}
+
+// HMACKeyHandle provides operations on an HMAC key.
+// Use Client.HMACKeyHandle to get a handle.
+type HMACKeyHandle struct {
+	c         *Client
+	projectID string
+	accessID  string
+}
+
+// Get returns the metadata of the key. Its Secret is not set.
+func (h *HMACKeyHandle) Get(ctx context.Context) (*HMACKey, error) {
+	call := h.c.raw.Projects.HmacKeys.Get(h.projectID, h.accessID)
+	var m *raw.HmacKeyMetadata
+	var err error
+	err = runWithRetry(ctx, nil, true, func() error {
+		m, err = call.Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newHMACKey(m, ""), nil
+}
+
+// HMACKeyAttrsToUpdate holds the changes to make to an HMAC key.
+type HMACKeyAttrsToUpdate struct {
+	// State is the new state of the key, which must be Active or Inactive.
+	State HMACState
+
+	// Etag, if set, makes the update conditional on the key's metadata
+	// having this version.
+	Etag string
+}
+
+// Update changes the state of the key, and returns its updated metadata.
+func (h *HMACKeyHandle) Update(ctx context.Context, au HMACKeyAttrsToUpdate) (*HMACKey, error) {
+	if au.State != Active && au.State != Inactive {
+		return nil, errors.New("storage: HMACKeyHandle.Update: State must be Active or Inactive")
+	}
+	call := h.c.raw.Projects.HmacKeys.Update(h.projectID, h.accessID, &raw.HmacKeyMetadata{
+		State: string(au.State),
+		Etag:  au.Etag,
+	})
+	var m *raw.HmacKeyMetadata
+	var err error
+	err = runWithRetry(ctx, nil, au.Etag != "", func() error {
+		m, err = call.Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newHMACKey(m, ""), nil
+}
+
+// Delete deletes the key, which must be Inactive.
+func (h *HMACKeyHandle) Delete(ctx context.Context) error {
+	call := h.c.raw.Projects.HmacKeys.Delete(h.projectID, h.accessID)
+	return runWithRetry(ctx, nil, true, func() error { return call.Context(ctx).Do() })
+}
+
+// HMACKeys returns an iterator over the HMAC keys in the project, other than
+// deleted ones. Set the fields of the iterator before the first call to Next
+// to filter the keys.
+func (c *Client) HMACKeys(ctx context.Context, projectID string) *HMACKeyIterator {
+	it := &HMACKeyIterator{
+		ctx:       ctx,
+		client:    c,
+		projectID: projectID,
+	}
+	it.pageInfo, it.nextFunc = iterator.NewPageInfo(
+		it.fetch,
+		func() int { return len(it.keys) },
+		func() interface{} { k := it.keys; it.keys = nil; return k })
+	return it
+}
+
+// An HMACKeyIterator is an iterator over HMACKeys.
+type HMACKeyIterator struct {
+	// ServiceAccountEmail restricts the iterator to the keys of the service
+	// account with this email address.
+	ServiceAccountEmail string
+
+	// ShowDeletedKeys makes the iterator return deleted keys too.
+	ShowDeletedKeys bool
+
+	ctx       context.Context
+	client    *Client
+	projectID string
+	keys      []*HMACKey
+	pageInfo  *iterator.PageInfo
+	nextFunc  func() error
+}
+
+// Next returns the next result. Its second return value is iterator.Done if
+// there are no more results. Once Next returns iterator.Done, all subsequent
+// calls will return iterator.Done.
+func (it *HMACKeyIterator) Next() (*HMACKey, error) {
+	if err := it.nextFunc(); err != nil {
+		return nil, err
+	}
+	k := it.keys[0]
+	it.keys = it.keys[1:]
+	return k, nil
+}
+
+// PageInfo supports pagination. See the google.golang.org/api/iterator package for details.
+func (it *HMACKeyIterator) PageInfo() *iterator.PageInfo { return it.pageInfo }
+
+func (it *HMACKeyIterator) fetch(pageSize int, pageToken string) (string, error) {
+	req := it.client.raw.Projects.HmacKeys.List(it.projectID)
+	if it.ServiceAccountEmail != "" {
+		req.ServiceAccountEmail(it.ServiceAccountEmail)
+	}
+	req.ShowDeletedKeys(it.ShowDeletedKeys)
+	req.PageToken(pageToken)
+	if pageSize > 0 {
+		req.MaxResults(int64(pageSize))
+	}
+	var resp *raw.HmacKeysMetadata
+	var err error
+	err = runWithRetry(it.ctx, nil, true, func() error {
+		resp, err = req.Context(it.ctx).Do()
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, item := range resp.Items {
+		it.keys = append(it.keys, newHMACKey(item, ""))
+	}
+	return resp.NextPageToken, nil
+}
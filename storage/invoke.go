@@ -21,23 +21,157 @@ import (
 	"google.golang.org/api/googleapi"
 )
 
-// runWithRetry calls the function until it returns nil or a non-retryable error, or
-// the context is done.
-func runWithRetry(ctx context.Context, call func() error) error {
-	return internal.Retry(ctx, gax.Backoff{}, func() (stop bool, err error) {
+// A RetryPolicy determines which operations a handle retries after
+// transient errors.
+type RetryPolicy int
+
+const (
+	// RetryIdempotent retries only idempotent operations, which have the same
+	// effect however many times they are performed. This is the default.
+	//
+	// Operations that read are idempotent, as are those that create or
+	// delete buckets. Operations that modify objects, object metadata or
+	// bucket metadata are idempotent only if made conditional on the
+	// generation or metageneration that they replace, with If or
+	// Generation: for example, an object is deleted idempotently by a handle
+	// with a specific generation, or with a GenerationMatch condition. Other
+	// writes are not retried, because a retry could overwrite or delete a
+	// change made by someone else since the first attempt. Changes to ACLs
+	// and the creation of notification configurations are never idempotent.
+	RetryIdempotent RetryPolicy = iota
+
+	// RetryAlways retries all operations, including those that are not
+	// idempotent.
+	RetryAlways
+
+	// RetryNever makes each operation at most once.
+	RetryNever
+)
+
+// A RetryOption configures how a handle retries operations. See
+// ObjectHandle.Retryer.
+type RetryOption interface {
+	apply(*retryConfig)
+}
+
+type retryOption func(*retryConfig)
+
+func (f retryOption) apply(c *retryConfig) { f(c) }
+
+// WithPolicy sets the policy that determines which operations are retried.
+func WithPolicy(p RetryPolicy) RetryOption {
+	return retryOption(func(c *retryConfig) { c.policy = p })
+}
+
+// WithBackoff sets the backoff between attempts. By default, the first pause
+// is a second, and each pause is twice the previous one, up to 30 seconds.
+func WithBackoff(b gax.Backoff) RetryOption {
+	return retryOption(func(c *retryConfig) { c.backoff = &b })
+}
+
+// WithMaxAttempts sets the largest number of attempts at each operation,
+// including the first. If n is zero, the default, operations are retried
+// until they succeed or their context is done.
+func WithMaxAttempts(n int) RetryOption {
+	return retryOption(func(c *retryConfig) { c.maxAttempts = n })
+}
+
+// WithErrorFunc sets the function that reports whether an error is
+// transient, and so whether an operation that failed with it may be retried.
+// By default, errors with HTTP status 429 or 5xx are transient.
+func WithErrorFunc(f func(err error) bool) RetryOption {
+	return retryOption(func(c *retryConfig) { c.shouldRetry = f })
+}
+
+// WithRetryHook sets a function that is called before each retry with the
+// number of the attempt that failed, starting at 1, and its error. It is
+// called synchronously, so it should return quickly.
+func WithRetryHook(f func(attempt int, err error)) RetryOption {
+	return retryOption(func(c *retryConfig) { c.hook = f })
+}
+
+// retryConfig holds the retry options of a handle. A nil *retryConfig holds
+// the defaults.
+type retryConfig struct {
+	policy      RetryPolicy
+	backoff     *gax.Backoff
+	maxAttempts int
+	shouldRetry func(error) bool
+	hook        func(int, error)
+}
+
+// with returns a copy of c with opts applied.
+func (c *retryConfig) with(opts []RetryOption) *retryConfig {
+	c2 := &retryConfig{}
+	if c != nil {
+		*c2 = *c
+	}
+	for _, opt := range opts {
+		opt.apply(c2)
+	}
+	return c2
+}
+
+// retryable reports whether an operation that failed with err may be
+// retried, given whether it is idempotent.
+func (c *retryConfig) retryable(err error, isIdempotent bool) bool {
+	if err == nil {
+		return false
+	}
+	if c == nil {
+		return isIdempotent && shouldRetry(err)
+	}
+	switch c.policy {
+	case RetryNever:
+		return false
+	case RetryIdempotent:
+		if !isIdempotent {
+			return false
+		}
+	}
+	if c.shouldRetry != nil {
+		return c.shouldRetry(err)
+	}
+	return shouldRetry(err)
+}
+
+// runWithRetry calls the function until it returns nil or an error that retry
+// does not allow it to retry, or the context is done.
+func runWithRetry(ctx context.Context, retry *retryConfig, isIdempotent bool, call func() error) error {
+	var bo gax.Backoff
+	if retry != nil && retry.backoff != nil {
+		bo = *retry.backoff
+	}
+	attempts := 0
+	return internal.Retry(ctx, bo, func() (stop bool, err error) {
 		err = call()
 		if err == nil {
 			return true, nil
 		}
-		e, ok := err.(*googleapi.Error)
-		if !ok {
+		attempts++
+		if !retry.retryable(err, isIdempotent) {
 			return true, err
 		}
-		// Retry on 429 and 5xx, according to
-		// https://cloud.google.com/storage/docs/exponential-backoff.
-		if e.Code == 429 || (e.Code >= 500 && e.Code < 600) {
-			return false, nil
+		if retry != nil {
+			if retry.maxAttempts > 0 && attempts >= retry.maxAttempts {
+				return true, err
+			}
+			if retry.hook != nil {
+				retry.hook(attempts, err)
+			}
 		}
-		return true, err
+		return false, nil
 	})
 }
+
+// shouldRetry reports whether err, returned by a call to the service, is
+// transient.
+func shouldRetry(err error) bool {
+	e, ok := err.(*googleapi.Error)
+	if !ok {
+		return false
+	}
+	// Retry on 429 and 5xx, according to
+	// https://cloud.google.com/storage/docs/exponential-backoff.
+	return e.Code == 429 || (e.Code >= 500 && e.Code < 600)
+}
@@ -16,10 +16,16 @@ package storage
 
 import (
 	"errors"
+	"net/http"
+	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	gax "github.com/googleapis/gax-go"
 	"golang.org/x/net/context"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
 )
 
 func TestInvoke(t *testing.T) {
@@ -47,9 +53,140 @@ func TestInvoke(t *testing.T) {
 			}
 			return test.err
 		}
-		got := runWithRetry(ctx, call)
+		got := runWithRetry(ctx, nil, true, call)
 		if got != test.err {
 			t.Errorf("%v: got %v, want %v", test, got, test.err)
 		}
 	}
 }
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+	transient := &googleapi.Error{Code: 503}
+	fast := WithBackoff(gax.Backoff{Initial: time.Millisecond})
+	for _, test := range []struct {
+		desc         string
+		opts         []RetryOption
+		isIdempotent bool
+		wantCalls    int
+	}{
+		{"default, idempotent", nil, true, 3},
+		{"default, not idempotent", nil, false, 1},
+		{"RetryIdempotent, idempotent", []RetryOption{WithPolicy(RetryIdempotent)}, true, 3},
+		{"RetryIdempotent, not idempotent", []RetryOption{WithPolicy(RetryIdempotent)}, false, 1},
+		{"RetryAlways", []RetryOption{WithPolicy(RetryAlways)}, false, 3},
+		{"RetryNever", []RetryOption{WithPolicy(RetryNever)}, true, 1},
+		{"max attempts", []RetryOption{WithMaxAttempts(2)}, true, 2},
+		{"error func", []RetryOption{WithErrorFunc(func(error) bool { return false })}, true, 1},
+	} {
+		calls := 0
+		call := func() error {
+			calls++
+			if calls < 3 {
+				return transient
+			}
+			return nil
+		}
+		// Don't wait a second between attempts.
+		retry := (*retryConfig)(nil).with(append(test.opts, fast))
+		err := runWithRetry(ctx, retry, test.isIdempotent, call)
+		if calls != test.wantCalls {
+			t.Errorf("%s: got %d calls, want %d", test.desc, calls, test.wantCalls)
+		}
+		if wantErr := calls < 3; (err != nil) != wantErr {
+			t.Errorf("%s: got error %v, want error: %t", test.desc, err, wantErr)
+		}
+	}
+}
+
+func TestRetryHook(t *testing.T) {
+	type retry struct {
+		attempt int
+		err     error
+	}
+	var got []retry
+	r := (*retryConfig)(nil).with([]RetryOption{
+		WithBackoff(gax.Backoff{Initial: time.Millisecond}),
+		WithMaxAttempts(3),
+		WithRetryHook(func(attempt int, err error) { got = append(got, retry{attempt, err}) }),
+	})
+	errs := []error{&googleapi.Error{Code: 500}, &googleapi.Error{Code: 429}, &googleapi.Error{Code: 502}}
+	calls := 0
+	err := runWithRetry(context.Background(), r, true, func() error {
+		calls++
+		return errs[calls-1]
+	})
+	if err != errs[2] {
+		t.Errorf("got %v, want %v", err, errs[2])
+	}
+	want := []retry{{1, errs[0]}, {2, errs[1]}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got retries %v, want %v", got, want)
+	}
+}
+
+func TestRetryerInheritance(t *testing.T) {
+	c := &Client{}
+	b := c.Bucket("b").Retryer(WithPolicy(RetryNever))
+	o := b.Object("o").Retryer(WithMaxAttempts(5))
+	if o.retry.policy != RetryNever || o.retry.maxAttempts != 5 {
+		t.Errorf("object retry config: got %+v", o.retry)
+	}
+	if o.ACL().retry != o.retry || b.DefaultObjectACL().retry != b.retry {
+		t.Error("ACL handles do not share the retry config of their handles")
+	}
+	if b.retry.maxAttempts != 0 {
+		t.Error("Retryer modified the bucket handle's config")
+	}
+	if c.Bucket("b").Object("o").retry != nil {
+		t.Error("new handles have a retry config")
+	}
+}
+
+func TestRetryIdempotency(t *testing.T) {
+	var calls int32
+	hc, close := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"bucket": "b", "name": "o"}`))
+	})
+	defer close()
+	ctx := context.Background()
+	c, err := NewClient(ctx, option.WithHTTPClient(hc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := c.Bucket("b").Object("o").Retryer(WithBackoff(gax.Backoff{Initial: time.Millisecond}))
+	for _, test := range []struct {
+		desc      string
+		call      func() error
+		wantCalls int32
+	}{
+		{"Delete", func() error { return obj.Delete(ctx) }, 1},
+		{"Delete generation", func() error { return obj.Generation(3).Delete(ctx) }, 2},
+		{"Delete if generation matches", func() error { return obj.If(Conditions{GenerationMatch: 3}).Delete(ctx) }, 2},
+		{"Update", func() error {
+			_, err := obj.Update(ctx, ObjectAttrsToUpdate{ContentType: "text/plain"})
+			return err
+		}, 1},
+		{"Update if metageneration matches", func() error {
+			_, err := obj.If(Conditions{MetagenerationMatch: 3}).Update(ctx, ObjectAttrsToUpdate{ContentType: "text/plain"})
+			return err
+		}, 2},
+		{"Update with RetryAlways", func() error {
+			_, err := obj.Retryer(WithPolicy(RetryAlways)).Update(ctx, ObjectAttrsToUpdate{ContentType: "text/plain"})
+			return err
+		}, 2},
+	} {
+		atomic.StoreInt32(&calls, 0)
+		err := test.call()
+		if got := atomic.LoadInt32(&calls); got != test.wantCalls {
+			t.Errorf("%s: got %d requests, want %d", test.desc, got, test.wantCalls)
+		}
+		if wantErr := test.wantCalls == 1; (err != nil) != wantErr {
+			t.Errorf("%s: got error %v, want error: %t", test.desc, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	raw "google.golang.org/api/storage/v1"
+)
+
+// A Notification describes how to send Cloud PubSub messages when certain
+// events occur in a bucket.
+type Notification struct {
+	// The ID of the notification.
+	ID string
+
+	// The ID of the topic to which this subscription publishes.
+	TopicID string
+
+	// The ID of the project to which the topic belongs.
+	TopicProjectID string
+
+	// Only send notifications about listed event types. If empty, send notifications
+	// for all event types.
+	// See https://cloud.google.com/storage/docs/pubsub-notifications#events.
+	EventTypes []string
+
+	// If present, only apply this notification configuration to object names that
+	// begin with this prefix.
+	ObjectNamePrefix string
+
+	// An optional list of additional attributes to attach to each Cloud PubSub
+	// message published for this notification subscription.
+	CustomAttributes map[string]string
+
+	// The contents of the message payload.
+	// See https://cloud.google.com/storage/docs/pubsub-notifications#payload.
+	PayloadFormat string
+}
+
+// Values for Notification.PayloadFormat.
+const (
+	// Send no payload with notification messages.
+	NoPayload = "NONE"
+
+	// Send object metadata as JSON with notification messages.
+	JSONPayload = "JSON_API_V1"
+)
+
+// Values for Notification.EventTypes.
+const (
+	// Event that occurs when an object is successfully created.
+	ObjectFinalizeEvent = "OBJECT_FINALIZE"
+
+	// Event that occurs when the metadata of an existing object changes.
+	ObjectMetadataUpdateEvent = "OBJECT_METADATA_UPDATE"
+
+	// Event that occurs when an object is permanently deleted.
+	ObjectDeleteEvent = "OBJECT_DELETE"
+
+	// Event that occurs when the live version of an object becomes an
+	// archived version.
+	ObjectArchiveEvent = "OBJECT_ARCHIVE"
+)
+
+func toNotification(rn *raw.Notification) *Notification {
+	n := &Notification{
+		ID:               rn.Id,
+		EventTypes:       rn.EventTypes,
+		ObjectNamePrefix: rn.ObjectNamePrefix,
+		CustomAttributes: rn.CustomAttributes,
+		PayloadFormat:    rn.PayloadFormat,
+	}
+	n.TopicProjectID, n.TopicID = parseNotificationTopic(rn.Topic)
+	return n
+}
+
+var topicRE = regexp.MustCompile("^//pubsub.googleapis.com/projects/([^/]+)/topics/([^/]+)")
+
+// parseNotificationTopic extracts the project and topic IDs from the full
+// resource name returned by the service. If the name is malformed, it returns
+// "?" for both IDs.
+func parseNotificationTopic(nt string) (projectID, topicID string) {
+	matches := topicRE.FindStringSubmatch(nt)
+	if matches == nil {
+		return "?", "?"
+	}
+	return matches[1], matches[2]
+}
+
+func toRawNotification(n *Notification) *raw.Notification {
+	return &raw.Notification{
+		Id: n.ID,
+		Topic: fmt.Sprintf("//pubsub.googleapis.com/projects/%s/topics/%s",
+			n.TopicProjectID, n.TopicID),
+		EventTypes:       n.EventTypes,
+		ObjectNamePrefix: n.ObjectNamePrefix,
+		CustomAttributes: n.CustomAttributes,
+		PayloadFormat:    n.PayloadFormat,
+	}
+}
+
+// AddNotification adds a notification to b. You must set n's TopicProjectID, TopicID
+// and PayloadFormat, and must not set its ID. The other fields are all optional. The
+// returned Notification's ID can be used to refer to it.
+func (b *BucketHandle) AddNotification(ctx context.Context, n *Notification) (*Notification, error) {
+	if n.ID != "" {
+		return nil, errors.New("storage: AddNotification: ID must not be set")
+	}
+	if n.TopicProjectID == "" {
+		return nil, errors.New("storage: AddNotification: missing TopicProjectID")
+	}
+	if n.TopicID == "" {
+		return nil, errors.New("storage: AddNotification: missing TopicID")
+	}
+	if n.PayloadFormat == "" {
+		return nil, errors.New("storage: AddNotification: PayloadFormat must be set")
+	}
+	call := b.c.raw.Notifications.Insert(b.name, toRawNotification(n)).Context(ctx)
+	if b.userProject != "" {
+		call.UserProject(b.userProject)
+	}
+	var rn *raw.Notification
+	var err error
+	err = runWithRetry(ctx, b.retry, false, func() error {
+		rn, err = call.Do()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toNotification(rn), nil
+}
+
+// Notifications returns all the Notifications configured for this bucket, as a map
+// indexed by notification ID.
+func (b *BucketHandle) Notifications(ctx context.Context) (map[string]*Notification, error) {
+	call := b.c.raw.Notifications.List(b.name).Context(ctx)
+	if b.userProject != "" {
+		call.UserProject(b.userProject)
+	}
+	var res *raw.Notifications
+	var err error
+	err = runWithRetry(ctx, b.retry, true, func() error {
+		res, err = call.Do()
+		return err
+	})
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+		return nil, ErrBucketNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]*Notification{}
+	for _, rn := range res.Items {
+		n := toNotification(rn)
+		m[n.ID] = n
+	}
+	return m, nil
+}
+
+// DeleteNotification deletes the notification with the given ID.
+func (b *BucketHandle) DeleteNotification(ctx context.Context, id string) error {
+	call := b.c.raw.Notifications.Delete(b.name, id).Context(ctx)
+	if b.userProject != "" {
+		call.UserProject(b.userProject)
+	}
+	return runWithRetry(ctx, b.retry, true, func() error { return call.Do() })
+}
+
+// A NotificationEvent is a change to an object, as described by a Cloud PubSub
+// message sent for a Notification.
+type NotificationEvent struct {
+	// Type is the type of the event, such as ObjectFinalizeEvent.
+	Type string
+
+	// NotificationID is the ID of the Notification that sent the message.
+	NotificationID string
+
+	// PayloadFormat is the format of the message's payload.
+	PayloadFormat string
+
+	// Bucket and Object name the object that changed, and Generation is the
+	// generation of the object the event concerns.
+	Bucket     string
+	Object     string
+	Generation int64
+
+	// EventTime is when the event occurred.
+	EventTime time.Time
+
+	// OverwroteGeneration is the generation of the object that was replaced
+	// by the one created, for ObjectFinalizeEvents. OverwrittenByGeneration is
+	// the generation of the object that replaced the one archived or
+	// deleted, for ObjectArchiveEvents and ObjectDeleteEvents. Both are zero
+	// if there was no such object.
+	OverwroteGeneration     int64
+	OverwrittenByGeneration int64
+
+	// Attrs holds the object's attributes, if PayloadFormat is JSONPayload.
+	Attrs *ObjectAttrs
+}
+
+var notificationConfigRE = regexp.MustCompile("^projects/_/buckets/[^/]+/notificationConfigs/([^/]+)$")
+
+// DecodeNotification decodes the attributes and data of a Cloud PubSub message
+// sent for a Notification. With the cloud.google.com/go/pubsub package, pass a
+// Message's Attributes and Data fields.
+func DecodeNotification(attrs map[string]string, data []byte) (*NotificationEvent, error) {
+	e := &NotificationEvent{
+		Type:          attrs["eventType"],
+		PayloadFormat: attrs["payloadFormat"],
+		Bucket:        attrs["bucketId"],
+		Object:        attrs["objectId"],
+	}
+	if e.Type == "" || e.Bucket == "" {
+		return nil, errors.New("storage: message is not a notification: missing eventType or bucketId attribute")
+	}
+	if m := notificationConfigRE.FindStringSubmatch(attrs["notificationConfig"]); m != nil {
+		e.NotificationID = m[1]
+	}
+	for _, f := range []struct {
+		attr string
+		dst  *int64
+	}{
+		{"objectGeneration", &e.Generation},
+		{"overwroteGeneration", &e.OverwroteGeneration},
+		{"overwrittenByGeneration", &e.OverwrittenByGeneration},
+	} {
+		v, ok := attrs[f.attr]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("storage: bad %s attribute %q in notification", f.attr, v)
+		}
+		*f.dst = n
+	}
+	if t, ok := attrs["eventTime"]; ok {
+		var err error
+		if e.EventTime, err = time.Parse(time.RFC3339, t); err != nil {
+			return nil, fmt.Errorf("storage: bad eventTime attribute %q in notification", t)
+		}
+	}
+	if e.PayloadFormat == JSONPayload && len(data) > 0 {
+		var obj raw.Object
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, fmt.Errorf("storage: decoding notification payload: %v", err)
+		}
+		e.Attrs = newObject(&obj)
+	}
+	return e, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseNotificationTopic(t *testing.T) {
+	for _, test := range []struct {
+		in            string
+		wantProjectID string
+		wantTopicID   string
+	}{
+		{"", "?", "?"},
+		{"foobar", "?", "?"},
+		{"//pubsub.googleapis.com/projects/foo", "?", "?"},
+		{"//pubsub.googleapis.com/projects/my-project/topics/my-topic",
+			"my-project", "my-topic"},
+	} {
+		gotProjectID, gotTopicID := parseNotificationTopic(test.in)
+		if gotProjectID != test.wantProjectID || gotTopicID != test.wantTopicID {
+			t.Errorf("%q: got (%q, %q), want (%q, %q)",
+				test.in, gotProjectID, gotTopicID, test.wantProjectID, test.wantTopicID)
+		}
+	}
+}
+
+func TestDecodeNotification(t *testing.T) {
+	attrs := map[string]string{
+		"notificationConfig":  "projects/_/buckets/my-bucket/notificationConfigs/7",
+		"eventType":           ObjectFinalizeEvent,
+		"payloadFormat":       JSONPayload,
+		"bucketId":            "my-bucket",
+		"objectId":            "dir/obj",
+		"objectGeneration":    "1500000000000000",
+		"eventTime":           "2017-07-14T02:40:00.000Z",
+		"overwroteGeneration": "1400000000000000",
+	}
+	data := []byte(`{"bucket": "my-bucket", "name": "dir/obj", "size": "5", "generation": "1500000000000000"}`)
+	e, err := DecodeNotification(attrs, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &NotificationEvent{
+		Type:                ObjectFinalizeEvent,
+		NotificationID:      "7",
+		PayloadFormat:       JSONPayload,
+		Bucket:              "my-bucket",
+		Object:              "dir/obj",
+		Generation:          1500000000000000,
+		EventTime:           time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC),
+		OverwroteGeneration: 1400000000000000,
+	}
+	if e.Attrs == nil || e.Attrs.Name != "dir/obj" || e.Attrs.Size != 5 {
+		t.Errorf("got Attrs %+v", e.Attrs)
+	}
+	e.Attrs = nil
+	if !e.EventTime.Equal(want.EventTime) {
+		t.Errorf("got EventTime %v, want %v", e.EventTime, want.EventTime)
+	}
+	e.EventTime = want.EventTime
+	if *e != *want {
+		t.Errorf("got\n%+v\nwant\n%+v", e, want)
+	}
+
+	if _, err := DecodeNotification(map[string]string{"foo": "bar"}, nil); err == nil {
+		t.Error("decoded a message without notification attributes")
+	}
+	attrs["objectGeneration"] = "x"
+	if _, err := DecodeNotification(attrs, data); err == nil {
+		t.Error("decoded a bad generation")
+	}
+}
+
+func TestAddNotificationValidation(t *testing.T) {
+	b := (&Client{}).Bucket("b")
+	for _, test := range []struct {
+		n      Notification
+		errMsg string
+	}{
+		{Notification{ID: "1", TopicProjectID: "p", TopicID: "t", PayloadFormat: JSONPayload}, "ID must not be set"},
+		{Notification{TopicID: "t", PayloadFormat: JSONPayload}, "missing TopicProjectID"},
+		{Notification{TopicProjectID: "p", PayloadFormat: JSONPayload}, "missing TopicID"},
+		{Notification{TopicProjectID: "p", TopicID: "t"}, "PayloadFormat must be set"},
+	} {
+		_, err := b.AddNotification(context.Background(), &test.n)
+		if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+			t.Errorf("%+v: got %v, want error containing %q", test.n, err, test.errMsg)
+		}
+	}
+}
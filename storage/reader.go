@@ -15,15 +15,25 @@
 package storage
 
 import (
+	"fmt"
+	"hash/crc32"
 	"io"
 )
 
 // Reader reads a Cloud Storage object.
 // It implements io.Reader.
+//
+// When the whole object is read, Reader computes the CRC32C checksum of its
+// contents, and Read returns an error in place of io.EOF if it doesn't match
+// the checksum reported by the service.
 type Reader struct {
 	body         io.ReadCloser
 	remain, size int64
 	contentType  string
+
+	checkCRC bool   // whether to check the CRC32C at EOF
+	wantCRC  uint32 // the CRC32C reported by the service
+	gotCRC   uint32 // the CRC32C of the bytes read so far
 }
 
 // Close closes the Reader. It must be called when done reading.
@@ -36,6 +46,14 @@ func (r *Reader) Read(p []byte) (int, error) {
 	if r.remain != -1 {
 		r.remain -= int64(n)
 	}
+	if r.checkCRC {
+		r.gotCRC = crc32.Update(r.gotCRC, crc32cTable, p[:n])
+		// The check is made here rather than in Close, whose error is
+		// commonly ignored.
+		if err == io.EOF && r.gotCRC != r.wantCRC {
+			return n, fmt.Errorf("storage: bad CRC32C on read: got %08x, want %08x", r.gotCRC, r.wantCRC)
+		}
+	}
 	return n, err
 }
 
@@ -0,0 +1,346 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+// This file implements resumable Writers. Rather than handing the object's
+// contents to the raw client, which keeps the upload session to itself, they
+// speak the resumable upload protocol directly, so that the session URI can be
+// recorded and the upload continued by another process. See
+// https://cloud.google.com/storage/docs/json_api/v1/how-tos/resumable-upload.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+	"google.golang.org/api/googleapi"
+	raw "google.golang.org/api/storage/v1"
+)
+
+// statusResumeIncomplete is the status with which the service acknowledges a
+// chunk of a resumable upload which is not the last.
+const statusResumeIncomplete = 308
+
+// ResumeWriter returns a Writer that continues the resumable upload with the
+// given session URI, as reported by Writer.SessionURI or passed to
+// Writer.Checkpoint. It asks the service how much of the object it has
+// persisted, which the Writer's Offset method reports. The caller should write
+// the rest of the object, starting at that offset, and call Close.
+//
+// The object's attributes and preconditions were fixed when the session
+// began, so the Writer's ObjectAttrs are ignored. If the object is encrypted
+// with a customer-supplied key, o must have the same key.
+//
+// Sessions expire a week after they begin. If the upload completed before the
+// process that began it could call Close, Close returns nil immediately and
+// Attrs describes the object.
+func (o *ObjectHandle) ResumeWriter(ctx context.Context, sessionURI string) (*Writer, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if sessionURI == "" {
+		return nil, errors.New("storage: empty upload session URI")
+	}
+	w := o.NewWriter(ctx)
+	w.Resumable = true
+	w.resumable = true
+	w.opened = true
+	w.sessionURI = sessionURI
+	err := runWithRetry(ctx, o.retry, true, func() error {
+		var err error
+		w.offset, err = w.querySession()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// SessionURI returns the URI of a resumable Writer's upload session, or the
+// empty string if the session has not begun. The session begins when the first
+// chunk of the object is sent, or on Close for objects smaller than ChunkSize.
+func (w *Writer) SessionURI() string {
+	return w.sessionURI
+}
+
+// Offset returns the number of bytes of the object that the service has
+// persisted during a resumable write. A Writer returned by ResumeWriter
+// expects the object's contents from this offset on.
+func (w *Writer) Offset() int64 {
+	return w.offset
+}
+
+func (w *Writer) openResumable() error {
+	if w.o.gen >= 0 {
+		return errors.New("storage: NewWriter: generation not supported")
+	}
+	if w.o.conds != nil {
+		if err := w.o.conds.validate("NewWriter"); err != nil {
+			return err
+		}
+	}
+	if w.ChunkSize < 0 {
+		return errors.New("storage: Writer.ChunkSize must non-negative")
+	}
+	w.opened = true
+	return nil
+}
+
+// chunkSize returns the number of bytes a resumable Writer sends in each
+// request. The service requires every chunk but the last to be a multiple of
+// 256K.
+func (w *Writer) chunkSize() int {
+	n := w.ChunkSize
+	if n <= 0 {
+		n = googleapi.DefaultUploadChunkSize
+	}
+	if r := n % googleapi.MinUploadChunkSize; r != 0 {
+		n += googleapi.MinUploadChunkSize - r
+	}
+	return n
+}
+
+func (w *Writer) writeResumable(p []byte) (int, error) {
+	if w.obj != nil {
+		return 0, errors.New("storage: resumable upload is already complete")
+	}
+	w.buf = append(w.buf, p...)
+	if err := w.flush(false); err != nil {
+		w.err = err
+		return len(p), err
+	}
+	return len(p), nil
+}
+
+func (w *Writer) closeResumable() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.obj != nil {
+		return nil
+	}
+	if err := w.flush(true); err != nil {
+		w.err = err
+		return err
+	}
+	w.err = w.checkChecksums()
+	return w.err
+}
+
+// flush sends the buffered contents of the object to the service a chunk at a
+// time. Unless last is set, a partial chunk is kept for a later call;
+// otherwise the upload is finalized.
+func (w *Writer) flush(last bool) error {
+	size := w.chunkSize()
+	if w.sessionURI == "" && (last || len(w.buf) >= size) {
+		if err := w.startSession(); err != nil {
+			return err
+		}
+	}
+	for len(w.buf) >= size || (last && w.obj == nil) {
+		retrying := false
+		err := runWithRetry(w.ctx, w.o.retry, true, func() error {
+			if retrying {
+				// The failed request may have been partly committed.
+				committed, err := w.querySession()
+				if err != nil || w.obj != nil {
+					return err
+				}
+				if err := w.commit(committed); err != nil {
+					return err
+				}
+			}
+			n := len(w.buf)
+			if n > size {
+				n = size
+			}
+			final := last && n == len(w.buf)
+			err := w.sendChunk(w.buf[:n], final)
+			retrying = err != nil
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startSession begins a resumable upload session.
+func (w *Writer) startSession() error {
+	o := w.o
+	u, err := url.Parse(o.c.raw.BasePath)
+	if err != nil {
+		return err
+	}
+	// Uploads are served under "/upload" on the host of the JSON API.
+	u.Path = "/upload" + u.Path + "b/" + o.bucket + "/o"
+	v := url.Values{"uploadType": {"resumable"}, "projection": {"full"}}
+	if k := kmsKeyWithoutVersion(w.ObjectAttrs.KMSKeyName); k != "" {
+		v.Set("kmsKeyName", k)
+	}
+	if o.userProject != "" {
+		v.Set("userProject", o.userProject)
+	}
+	u.RawQuery = v.Encode()
+	if q := conditionsQuery(-1, o.conds); q != "" {
+		u.RawQuery += "&" + q
+	}
+	obj := w.rawObject()
+	if obj.ContentType == "" {
+		obj.ContentType = http.DetectContentType(w.buf)
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return runWithRetry(w.ctx, o.retry, true, func() error {
+		req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+		req.Header.Set("X-Upload-Content-Type", obj.ContentType)
+		if err := setEncryptionHeaders(req.Header, o.encryptionKey, false); err != nil {
+			return err
+		}
+		res, err := ctxhttp.Do(w.ctx, o.c.hc, req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if err := googleapi.CheckResponse(res); err != nil {
+			return err
+		}
+		loc := res.Header.Get("Location")
+		if loc == "" {
+			return errors.New("storage: no upload session URI in response")
+		}
+		w.sessionURI = loc
+		w.checkpoint()
+		return nil
+	})
+}
+
+// sendChunk sends the given bytes, which follow the committed offset, to the
+// upload session. If final is set, they end the object.
+func (w *Writer) sendChunk(chunk []byte, final bool) error {
+	total := "*"
+	if final {
+		total = strconv.FormatInt(w.offset+int64(len(chunk)), 10)
+	}
+	cr := "bytes */" + total
+	if len(chunk) > 0 {
+		cr = fmt.Sprintf("bytes %d-%d/%s", w.offset, w.offset+int64(len(chunk))-1, total)
+	}
+	var hash string
+	if final {
+		hash = w.checksumHeader()
+	}
+	committed, err := w.putSession(chunk, cr, hash)
+	if err != nil || w.obj != nil {
+		return err
+	}
+	if final {
+		return errors.New("storage: service did not complete the upload")
+	}
+	return w.commit(committed)
+}
+
+// querySession asks the service how many bytes of the object it has
+// persisted.
+func (w *Writer) querySession() (int64, error) {
+	return w.putSession(nil, "bytes */*", "")
+}
+
+// putSession sends a request to the upload session, and returns the number of
+// bytes the service has persisted. If the upload is complete, it also sets
+// w.obj. If hash is non-empty, it is sent as the X-Goog-Hash header.
+func (w *Writer) putSession(body []byte, contentRange, hash string) (int64, error) {
+	req, err := http.NewRequest("PUT", w.sessionURI, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Range", contentRange)
+	if hash != "" {
+		req.Header.Set("X-Goog-Hash", hash)
+	}
+	if err := setEncryptionHeaders(req.Header, w.o.encryptionKey, false); err != nil {
+		return 0, err
+	}
+	res, err := ctxhttp.Do(w.ctx, w.o.c.hc, req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == statusResumeIncomplete {
+		return committedBytes(res.Header.Get("Range"))
+	}
+	if err := googleapi.CheckResponse(res); err != nil {
+		return 0, err
+	}
+	var obj raw.Object
+	if err := json.NewDecoder(res.Body).Decode(&obj); err != nil {
+		return 0, fmt.Errorf("storage: decoding uploaded object: %v", err)
+	}
+	w.obj = newObject(&obj)
+	w.buf = nil
+	return w.obj.Size, nil
+}
+
+// committedBytes parses the Range header of an incomplete upload, of the form
+// "bytes=0-N". It is absent if the service has persisted nothing.
+func committedBytes(rng string) (int64, error) {
+	if rng == "" {
+		return 0, nil
+	}
+	i := strings.LastIndex(rng, "-")
+	if !strings.HasPrefix(rng, "bytes=0-") || i < 0 {
+		return 0, fmt.Errorf("storage: invalid Range %q in upload response", rng)
+	}
+	last, err := strconv.ParseInt(rng[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("storage: invalid Range %q in upload response", rng)
+	}
+	return last + 1, nil
+}
+
+// commit discards the buffered bytes that the service has persisted.
+func (w *Writer) commit(committed int64) error {
+	if committed < w.offset || committed > w.offset+int64(len(w.buf)) {
+		return fmt.Errorf("storage: service persisted %d bytes, but %d were sent", committed, w.offset+int64(len(w.buf)))
+	}
+	if committed == w.offset {
+		return nil
+	}
+	w.buf = append(w.buf[:0], w.buf[committed-w.offset:]...)
+	w.offset = committed
+	w.checkpoint()
+	return nil
+}
+
+func (w *Writer) checkpoint() {
+	if w.Checkpoint != nil {
+		w.Checkpoint(w.sessionURI, w.offset)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// minReadAhead is the size of the first request after a seek.
+	minReadAhead = 64 << 10
+
+	// defaultMaxReadAhead is the default value of SeekableReader.ReadAhead.
+	defaultMaxReadAhead = 16 << 20
+)
+
+var errSeekableClosed = errors.New("storage: SeekableReader is closed")
+
+// NewSeekableReader returns a SeekableReader for the object. It reads the
+// object's attributes, and then only the generation that they describe, so
+// that it never sees a mixture of the contents of different generations: if
+// that generation is replaced or deleted, reads fail with ErrObjectNotExist.
+//
+// The caller must call Close on the returned SeekableReader when done
+// reading.
+func (o *ObjectHandle) NewSeekableReader(ctx context.Context) (*SeekableReader, error) {
+	attrs, err := o.Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &SeekableReader{
+		ReadAhead: defaultMaxReadAhead,
+		ctx:       ctx,
+		o:         o.Generation(attrs.Generation),
+		attrs:     attrs,
+	}, nil
+}
+
+// A SeekableReader reads a Cloud Storage object with random access. It
+// implements io.ReadSeeker, io.ReaderAt and io.Closer.
+//
+// A SeekableReader reads the object with range requests, which it makes
+// afresh after seeking. It buffers what it reads in memory. Each request is at
+// least 64 KiB, so that small reads do not each need their own request, and
+// sequential reads double the size of each request up to ReadAhead.
+//
+// Unlike Reader, a SeekableReader does not verify the checksum of the object.
+//
+// The methods of SeekableReader may be called concurrently, but it makes only
+// one request at a time.
+type SeekableReader struct {
+	// ReadAhead is the largest number of bytes the reader requests in one
+	// request and holds in its buffer. Reads of ReadAhead bytes or more are
+	// not buffered. It defaults to 16 MiB, and may be changed before the
+	// first read.
+	ReadAhead int
+
+	ctx   context.Context
+	o     *ObjectHandle // pinned to the generation of attrs
+	attrs *ObjectAttrs
+
+	mu     sync.Mutex
+	closed bool
+	off    int64  // the offset of the next Read
+	buf    []byte // holds the bytes of the object from bufOff
+	bufOff int64
+	window int64 // the size of the most recent request that filled buf
+}
+
+// Attrs returns the attributes of the object generation that is read.
+func (r *SeekableReader) Attrs() *ObjectAttrs {
+	return r.attrs
+}
+
+// Size returns the size of the object in bytes.
+func (r *SeekableReader) Size() int64 {
+	return r.attrs.Size
+}
+
+// Read reads up to len(p) bytes from the object at the current offset.
+func (r *SeekableReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, errSeekableClosed
+	}
+	n, err := r.readAt(p, r.off)
+	r.off += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes from the object starting at offset off. It does
+// not affect the offset of Read.
+func (r *SeekableReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("storage: invalid offset %d < 0", off)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, errSeekableClosed
+	}
+	n := 0
+	for n < len(p) {
+		m, err := r.readAt(p[n:], off+int64(n))
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readAt reads up to len(p) bytes at off. If the buffer holds the byte at off,
+// it returns what the buffer holds from there, without making a request. The
+// caller must hold r.mu.
+func (r *SeekableReader) readAt(p []byte, off int64) (int, error) {
+	size := r.attrs.Size
+	if off >= size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off >= r.bufOff && off < r.bufOff+int64(len(r.buf)) {
+		return copy(p, r.buf[off-r.bufOff:]), nil
+	}
+	max := int64(r.ReadAhead)
+	if max <= 0 {
+		max = defaultMaxReadAhead
+	}
+	if max < minReadAhead {
+		max = minReadAhead
+	}
+	want := int64(len(p))
+	if want > size-off {
+		want = size - off
+	}
+	if want >= max {
+		// Read large requests directly, without copying.
+		return r.fetch(p[:want], off)
+	}
+	// Grow the window if this read continues the previous one.
+	if off == r.bufOff+int64(len(r.buf)) && r.window > 0 {
+		r.window *= 2
+	} else {
+		r.window = minReadAhead
+	}
+	if r.window > max {
+		r.window = max
+	}
+	if r.window < want {
+		r.window = want
+	}
+	n := r.window
+	if n > size-off {
+		n = size - off
+	}
+	if int64(cap(r.buf)) < n {
+		r.buf = make([]byte, n)
+	}
+	r.buf = r.buf[:n]
+	r.bufOff = off
+	if _, err := r.fetch(r.buf, off); err != nil {
+		r.buf = r.buf[:0]
+		return 0, err
+	}
+	return copy(p, r.buf), nil
+}
+
+// fetch reads len(p) bytes at off with a single request.
+func (r *SeekableReader) fetch(p []byte, off int64) (int, error) {
+	rr, err := r.o.NewRangeReader(r.ctx, off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer rr.Close()
+	n, err := io.ReadFull(rr, p)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = fmt.Errorf("storage: object generation %d ended early at %d bytes", r.attrs.Generation, off+int64(n))
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read, as described by io.Seeker. Seeking
+// makes no request; seeking beyond the end of the object is allowed, and
+// subsequent reads return io.EOF.
+func (r *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, errSeekableClosed
+	}
+	switch whence {
+	case os.SEEK_SET:
+	case os.SEEK_CUR:
+		offset += r.off
+	case os.SEEK_END:
+		offset += r.attrs.Size
+	default:
+		return 0, fmt.Errorf("storage: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("storage: invalid offset %d < 0", offset)
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// Close releases the buffer of the SeekableReader. Subsequent calls to its
+// methods return an error.
+func (r *SeekableReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return errSeekableClosed
+	}
+	r.closed = true
+	r.buf = nil
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/option"
+)
+
+func TestSeekableReader(t *testing.T) {
+	const size = 300 << 10
+	contents := make([]byte, size)
+	for i := range contents {
+		contents[i] = byte(i * 7)
+	}
+	var (
+		mu     sync.Mutex
+		ranges []string
+	)
+	hc, close := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/storage/v1/b/bucket/o/obj":
+			fmt.Fprintf(w, `{"bucket": "bucket", "name": "obj", "size": "%d", "generation": "7"}`, size)
+		case "/bucket/obj":
+			if got := r.URL.Query().Get("generation"); got != "7" {
+				t.Errorf("read generation %q, want 7", got)
+			}
+			var first, last int
+			rg := r.Header.Get("Range")
+			if _, err := fmt.Sscanf(rg, "bytes=%d-%d", &first, &last); err != nil || last >= size {
+				t.Errorf("bad Range %q", rg)
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			mu.Lock()
+			ranges = append(ranges, rg)
+			mu.Unlock()
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, size))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(contents[first : last+1])
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	defer close()
+	ctx := context.Background()
+	c, err := NewClient(ctx, option.WithHTTPClient(hc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.Bucket("bucket").Object("obj").NewSeekableReader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Size() != size {
+		t.Errorf("got size %d, want %d", r.Size(), size)
+	}
+	checkRanges := func(desc string, want ...string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !reflect.DeepEqual(ranges, want) {
+			t.Errorf("%s: got ranges %q, want %q", desc, ranges, want)
+		}
+		ranges = nil
+	}
+
+	// Small sequential reads make requests of increasing size.
+	var got []byte
+	for p := make([]byte, 1000); ; {
+		n, err := r.Read(p)
+		got = append(got, p[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(got, contents) {
+		t.Error("sequential read: contents differ")
+	}
+	checkRanges("sequential read", "bytes=0-65535", "bytes=65536-196607", "bytes=196608-307199")
+
+	// Seeking starts again with a small request.
+	if _, err := r.Seek(1000, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	if _, err := io.ReadFull(r, buf); err != nil || !bytes.Equal(buf, contents[1000:1010]) {
+		t.Errorf("read after seek: got %v, %v", buf, err)
+	}
+	// Reads within the buffer make no request.
+	if _, err := r.ReadAt(buf, 2000); err != nil || !bytes.Equal(buf, contents[2000:2010]) {
+		t.Errorf("ReadAt: got %v, %v", buf, err)
+	}
+	checkRanges("read after seek", "bytes=1000-66535")
+
+	// Reads at least as large as ReadAhead are not buffered.
+	r.ReadAhead = 100 << 10
+	big := make([]byte, 150<<10)
+	if n, err := r.ReadAt(big, size-100<<10); err != io.EOF || !bytes.Equal(big[:n], contents[size-100<<10:]) {
+		t.Errorf("ReadAt at end: got %d bytes, %v", n, err)
+	}
+	if n, err := r.ReadAt(buf, size); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt beyond end: got %d, %v", n, err)
+	}
+	checkRanges("large ReadAt", "bytes=204800-307199")
+
+	if _, err := r.Seek(-1, os.SEEK_SET); err == nil {
+		t.Error("Seek to negative offset: got nil error, want one")
+	}
+	if _, err := r.Seek(0, os.SEEK_END); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(buf); err != io.EOF {
+		t.Errorf("Read at end: got %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,407 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// now is the clock used to sign URLs and policies. Tests replace it.
+var now = time.Now
+
+// SigningScheme determines the version of the scheme with which SignedURL
+// signs URLs.
+type SigningScheme int
+
+const (
+	// SigningSchemeDefault is the V2 scheme.
+	SigningSchemeDefault SigningScheme = iota
+
+	// SigningSchemeV2 is the legacy scheme, described at
+	// https://cloud.google.com/storage/docs/access-control/signed-urls-v2.
+	SigningSchemeV2
+
+	// SigningSchemeV4 is described at
+	// https://cloud.google.com/storage/docs/access-control/signing-urls-manually.
+	// URLs signed with it may expire at most seven days after they are signed.
+	SigningSchemeV4
+)
+
+const (
+	signingAlgorithmV4 = "GOOG4-RSA-SHA256"
+	maxExpirationV4    = 7 * 24 * time.Hour
+	timestampV4        = "20060102T150405Z"
+	dateV4             = "20060102"
+)
+
+// A URLStyle determines the host and path of signed URLs and post policies.
+type URLStyle interface {
+	host(bucket string) string
+	path(bucket, object string) string
+}
+
+type pathStyle struct{}
+
+func (pathStyle) host(bucket string) string { return "storage.googleapis.com" }
+
+func (pathStyle) path(bucket, object string) string { return "/" + bucket + "/" + object }
+
+type virtualHostedStyle struct{}
+
+func (virtualHostedStyle) host(bucket string) string { return bucket + ".storage.googleapis.com" }
+
+func (virtualHostedStyle) path(bucket, object string) string { return "/" + object }
+
+type bucketBoundHostname struct {
+	hostname string
+}
+
+func (b bucketBoundHostname) host(bucket string) string { return b.hostname }
+
+func (bucketBoundHostname) path(bucket, object string) string { return "/" + object }
+
+// PathStyle is the default URLStyle, which names the bucket in the path, as in
+// https://storage.googleapis.com/bucket/object.
+func PathStyle() URLStyle {
+	return pathStyle{}
+}
+
+// VirtualHostedStyle is a URLStyle which names the bucket in the host, as in
+// https://bucket.storage.googleapis.com/object.
+func VirtualHostedStyle() URLStyle {
+	return virtualHostedStyle{}
+}
+
+// BucketBoundHostname is a URLStyle for a custom domain which is mapped to a
+// bucket, such as through a CNAME record or a load balancer, as in
+// https://hostname/object. The hostname must not include a scheme.
+func BucketBoundHostname(hostname string) URLStyle {
+	return bucketBoundHostname{hostname: hostname}
+}
+
+// signedURLV4 signs a URL with the V4 scheme at time t, which is in UTC.
+func signedURLV4(bucket, name string, opts *SignedURLOptions, signBytes func([]byte) ([]byte, error), t time.Time) (string, error) {
+	expires := opts.Expires.Sub(t) / time.Second * time.Second
+	if expires <= 0 {
+		return "", errors.New("storage: expires must be in the future")
+	}
+	if expires > maxExpirationV4 {
+		return "", errors.New("storage: expires must be within seven days of now")
+	}
+	style := opts.Style
+	if style == nil {
+		style = PathStyle()
+	}
+	host := style.host(bucket)
+	headers, err := headersV4(host, opts)
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for n := range headers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	signedHeaders := strings.Join(names, ";")
+
+	scope := t.Format(dateV4) + "/auto/storage/goog4_request"
+	q := url.Values{}
+	for k, vs := range opts.QueryParameters {
+		q[k] = append([]string(nil), vs...)
+	}
+	q.Set("X-Goog-Algorithm", signingAlgorithmV4)
+	q.Set("X-Goog-Credential", opts.GoogleAccessID+"/"+scope)
+	q.Set("X-Goog-Date", t.Format(timestampV4))
+	q.Set("X-Goog-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	q.Set("X-Goog-SignedHeaders", signedHeaders)
+	query := strings.Replace(q.Encode(), "+", "%20", -1)
+	path := escapePathV4(style.path(bucket, name))
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s\n", opts.Method)
+	fmt.Fprintf(buf, "%s\n", path)
+	fmt.Fprintf(buf, "%s\n", query)
+	for _, n := range names {
+		fmt.Fprintf(buf, "%s:%s\n", n, headers[n])
+	}
+	fmt.Fprintf(buf, "\n%s\n", signedHeaders)
+	if sum, ok := headers["x-goog-content-sha256"]; ok {
+		fmt.Fprint(buf, sum)
+	} else {
+		fmt.Fprint(buf, "UNSIGNED-PAYLOAD")
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	stringToSign := fmt.Sprintf("%s\n%s\n%s\n%x", signingAlgorithmV4, t.Format(timestampV4), scope, sum[:])
+
+	sig, err := signBytes([]byte(stringToSign))
+	if err != nil {
+		return "", err
+	}
+	scheme := "https"
+	if opts.Insecure {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s%s?%s&X-Goog-Signature=%s", scheme, host, path, query, hex.EncodeToString(sig)), nil
+}
+
+// headersV4 returns the canonical headers which a V4 signed URL covers,
+// keyed by lowercase name. Values of repeated headers are joined by commas.
+func headersV4(host string, opts *SignedURLOptions) (map[string]string, error) {
+	headers := map[string]string{"host": host}
+	add := func(name, value string) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.Join(strings.Fields(value), " ")
+		if v, ok := headers[name]; ok {
+			value = v + "," + value
+		}
+		headers[name] = value
+	}
+	for _, h := range opts.Headers {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("storage: header %q is not of the form name:value", h)
+		}
+		add(h[:i], h[i+1:])
+	}
+	if opts.ContentType != "" {
+		add("content-type", opts.ContentType)
+	}
+	if opts.MD5 != nil {
+		add("content-md5", string(opts.MD5))
+	}
+	return headers, nil
+}
+
+// escapePathV4 percent-encodes each segment of path, except for unreserved
+// characters.
+func escapePathV4(path string) string {
+	segs := strings.Split(path, "/")
+	for i, s := range segs {
+		segs[i] = strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+	}
+	return strings.Join(segs, "/")
+}
+
+// PostPolicyOptions configures the policy returned by GenerateSignedPostPolicy.
+type PostPolicyOptions struct {
+	// GoogleAccessID is the service account that signs the policy, as for
+	// SignedURLOptions.
+	// Required.
+	GoogleAccessID string
+
+	// PrivateKey and SignBytes are as for SignedURLOptions. Exactly one must
+	// be non-nil.
+	PrivateKey []byte
+	SignBytes  func([]byte) ([]byte, error)
+
+	// Expires is the time after which the policy no longer permits uploads.
+	// Required.
+	Expires time.Time
+
+	// Style determines the form of the URL to which the form is posted.
+	// Optional; the default is PathStyle.
+	Style URLStyle
+
+	// Insecure determines whether the URL uses HTTP rather than HTTPS.
+	// Optional.
+	Insecure bool
+
+	// Fields are form fields which are added to the policy and must be
+	// posted unchanged.
+	// Optional.
+	Fields *PolicyFields
+
+	// Conditions are additional constraints on the form fields and the
+	// uploaded file.
+	// Optional.
+	Conditions []PostPolicyCondition
+}
+
+// PolicyFields are the form fields of a post policy which set attributes of
+// the uploaded object, or determine the response to a successful upload.
+type PolicyFields struct {
+	// ACL is the name of a predefined ACL, such as "public-read".
+	ACL                string
+	CacheControl       string
+	ContentType        string
+	ContentDisposition string
+	ContentEncoding    string
+	// Metadata holds the object's custom metadata, which is sent in fields
+	// named "x-goog-meta-" followed by the key.
+	Metadata map[string]string
+	// StatusCodeOnSuccess is the status of the response to a successful
+	// upload which does not redirect: 200, 201 or 204.
+	StatusCodeOnSuccess int
+	// RedirectToURLOnSuccess is the URL to which a successful upload
+	// redirects.
+	RedirectToURLOnSuccess string
+}
+
+// A PostPolicyCondition is a condition of a post policy, which is marshaled
+// as an element of the policy document's "conditions" array. See
+// https://cloud.google.com/storage/docs/authentication/signatures#policy-document.
+type PostPolicyCondition interface {
+	json.Marshaler
+}
+
+type startsWith struct {
+	field, prefix string
+}
+
+func (s startsWith) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string{"starts-with", "$" + s.field, s.prefix})
+}
+
+// ConditionStartsWith returns a condition that the form field with the given
+// name, such as "key" or "Content-Type", begins with prefix. An empty prefix
+// permits any value.
+func ConditionStartsWith(field, prefix string) PostPolicyCondition {
+	return startsWith{field: field, prefix: prefix}
+}
+
+type contentLengthRange struct {
+	min, max int64
+}
+
+func (c contentLengthRange) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{"content-length-range", c.min, c.max})
+}
+
+// ConditionContentLengthRange returns a condition that the size of the
+// uploaded file in bytes is between min and max, inclusive.
+func ConditionContentLengthRange(min, max int64) PostPolicyCondition {
+	return contentLengthRange{min: min, max: max}
+}
+
+// A PostPolicy describes an HTML form which uploads a file to Cloud Storage.
+type PostPolicy struct {
+	// URL is the form's action.
+	URL string
+
+	// Fields are the form's fields, which must be sent, in addition to any
+	// that other conditions require, before the "file" field.
+	Fields map[string]string
+}
+
+type policyDocument struct {
+	Conditions []interface{} `json:"conditions"`
+	Expiration string        `json:"expiration"`
+}
+
+// GenerateSignedPostPolicy returns a policy, signed with the V4 scheme,
+// which permits an HTML form to upload an object with the given name to the
+// bucket until opts.Expires. See
+// https://cloud.google.com/storage/docs/xml-api/post-object.
+func GenerateSignedPostPolicy(bucket, object string, opts *PostPolicyOptions) (*PostPolicy, error) {
+	if opts == nil {
+		return nil, errors.New("storage: missing required PostPolicyOptions")
+	}
+	if bucket == "" {
+		return nil, errors.New("storage: missing required bucket name")
+	}
+	if object == "" {
+		return nil, errors.New("storage: missing required object name")
+	}
+	if opts.GoogleAccessID == "" {
+		return nil, errors.New("storage: missing required GoogleAccessID")
+	}
+	if (opts.PrivateKey == nil) == (opts.SignBytes == nil) {
+		return nil, errors.New("storage: exactly one of PrivateKey or SignedBytes must be set")
+	}
+	t := now().UTC()
+	if !opts.Expires.After(t) {
+		return nil, errors.New("storage: expires must be in the future")
+	}
+	signBytes, err := signer(opts.PrivateKey, opts.SignBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]string{}
+	if f := opts.Fields; f != nil {
+		for k, v := range map[string]string{
+			"acl":                     f.ACL,
+			"cache-control":           f.CacheControl,
+			"content-type":            f.ContentType,
+			"content-disposition":     f.ContentDisposition,
+			"content-encoding":        f.ContentEncoding,
+			"success_action_redirect": f.RedirectToURLOnSuccess,
+		} {
+			if v != "" {
+				fields[k] = v
+			}
+		}
+		for k, v := range f.Metadata {
+			fields["x-goog-meta-"+k] = v
+		}
+		if f.StatusCodeOnSuccess != 0 {
+			fields["success_action_status"] = strconv.Itoa(f.StatusCodeOnSuccess)
+		}
+	}
+	var names []string
+	for k := range fields {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	doc := policyDocument{Expiration: opts.Expires.UTC().Format(time.RFC3339)}
+	for _, k := range names {
+		doc.Conditions = append(doc.Conditions, map[string]string{k: fields[k]})
+	}
+	for _, c := range opts.Conditions {
+		doc.Conditions = append(doc.Conditions, c)
+	}
+	fields["key"] = object
+	fields["x-goog-date"] = t.Format(timestampV4)
+	fields["x-goog-credential"] = opts.GoogleAccessID + "/" + t.Format(dateV4) + "/auto/storage/goog4_request"
+	fields["x-goog-algorithm"] = signingAlgorithmV4
+	doc.Conditions = append(doc.Conditions, map[string]string{"bucket": bucket})
+	for _, k := range []string{"key", "x-goog-date", "x-goog-credential", "x-goog-algorithm"} {
+		doc.Conditions = append(doc.Conditions, map[string]string{k: fields[k]})
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	policy := base64.StdEncoding.EncodeToString(b)
+	sig, err := signBytes([]byte(policy))
+	if err != nil {
+		return nil, err
+	}
+	fields["policy"] = policy
+	fields["x-goog-signature"] = hex.EncodeToString(sig)
+
+	style := opts.Style
+	if style == nil {
+		style = PathStyle()
+	}
+	scheme := "https"
+	if opts.Insecure {
+		scheme = "http"
+	}
+	u := &url.URL{Scheme: scheme, Host: style.host(bucket), Path: style.path(bucket, "")}
+	return &PostPolicy{URL: u.String(), Fields: fields}, nil
+}
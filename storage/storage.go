@@ -22,6 +22,7 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -30,6 +31,7 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -72,6 +74,15 @@ const (
 type Client struct {
 	hc  *http.Client
 	raw *raw.Service
+
+	// scheme and readHost locate the XML API, which is used to read
+	// object contents.
+	scheme   string
+	readHost string
+
+	// xmlWrites is set if objects are written with the XML API, as by
+	// clients that sign requests with HMAC keys.
+	xmlWrites bool
 }
 
 // NewClient creates a new Google Cloud Storage client.
@@ -81,8 +92,15 @@ func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error
 		option.WithScopes(ScopeFullControl),
 		option.WithUserAgent(userAgent),
 	}
-	opts = append(o, opts...)
-	hc, _, err := transport.NewHTTPClient(ctx, opts...)
+	xmlEndpoint := ""
+	for _, opt := range opts {
+		if x, ok := opt.(xmlEndpointOption); ok {
+			xmlEndpoint = x.endpoint
+			continue
+		}
+		o = append(o, opt)
+	}
+	hc, ep, err := transport.NewHTTPClient(ctx, o...)
 	if err != nil {
 		return nil, fmt.Errorf("dialing: %v", err)
 	}
@@ -90,10 +108,45 @@ func NewClient(ctx context.Context, opts ...option.ClientOption) (*Client, error
 	if err != nil {
 		return nil, fmt.Errorf("storage client: %v", err)
 	}
-	return &Client{
-		hc:  hc,
-		raw: rawService,
-	}, nil
+	c := &Client{
+		hc:       hc,
+		raw:      rawService,
+		scheme:   "https",
+		readHost: "storage.googleapis.com",
+	}
+	if ep != "" {
+		// The endpoint is the base of the JSON API, such as
+		// "https://www.googleapis.com/storage/v1/". The XML API is
+		// served elsewhere, so it does not move the reads of objects.
+		rawService.BasePath = ep
+	}
+	if xmlEndpoint != "" {
+		u, err := url.Parse(xmlEndpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("storage client: invalid XML endpoint %q", xmlEndpoint)
+		}
+		c.scheme, c.readHost = u.Scheme, u.Host
+	}
+	return c, nil
+}
+
+// WithXMLEndpoint returns a ClientOption that sets the base URL of the XML
+// API, such as "https://storage.googleapis.com", from which object contents
+// are read, and to which clients created with NewHMACClient write objects.
+// option.WithEndpoint only moves the JSON API. WithXMLEndpoint is meant for
+// fakes and emulators, such as storagetest.Server.
+//
+// The option is only understood by NewClient and NewHMACClient; it must not
+// be passed to the clients of other packages.
+func WithXMLEndpoint(endpoint string) option.ClientOption {
+	return xmlEndpointOption{endpoint: endpoint}
+}
+
+// xmlEndpointOption is removed from the options by NewClient, so its
+// embedded ClientOption, which is nil, is never applied.
+type xmlEndpointOption struct {
+	option.ClientOption
+	endpoint string
 }
 
 // Close closes the Client.
@@ -110,8 +163,11 @@ type BucketHandle struct {
 	acl              ACLHandle
 	defaultObjectACL ACLHandle
 
-	c    *Client
-	name string
+	c           *Client
+	name        string
+	conds       *BucketConditions
+	retry       *retryConfig // nil for the defaults
+	userProject string       // project for requester-pays buckets
 }
 
 // Bucket returns a BucketHandle, which provides operations on the named bucket.
@@ -191,7 +247,8 @@ type SignedURLOptions struct {
 	ContentType string
 
 	// Headers is a list of extention headers the client must provide
-	// in order to use the generated signed URL.
+	// in order to use the generated signed URL. With SigningSchemeV4, each
+	// must be of the form "name:value".
 	// Optional.
 	Headers []string
 
@@ -200,6 +257,26 @@ type SignedURLOptions struct {
 	// header in order to use the signed URL.
 	// Optional.
 	MD5 []byte
+
+	// Scheme determines the version of the signing scheme to use.
+	// Optional.
+	Scheme SigningScheme
+
+	// QueryParameters is a map of additional query parameters, such as
+	// response-content-disposition, which the signature covers. It is only
+	// supported by SigningSchemeV4.
+	// Optional.
+	QueryParameters url.Values
+
+	// Style determines the form of the URL: PathStyle, the default,
+	// VirtualHostedStyle or BucketBoundHostname. Styles other than PathStyle
+	// are only supported by SigningSchemeV4.
+	// Optional.
+	Style URLStyle
+
+	// Insecure determines whether the URL uses HTTP rather than HTTPS.
+	// Optional.
+	Insecure bool
 }
 
 // SignedURL returns a URL for the specified object. Signed URLs allow
@@ -222,26 +299,48 @@ func SignedURL(bucket, name string, opts *SignedURLOptions) (string, error) {
 	if opts.Expires.IsZero() {
 		return "", errors.New("storage: missing required expires option")
 	}
+	signBytes, err := signer(opts.PrivateKey, opts.SignBytes)
+	if err != nil {
+		return "", err
+	}
+	switch opts.Scheme {
+	case SigningSchemeDefault, SigningSchemeV2:
+		return signedURLV2(bucket, name, opts, signBytes)
+	case SigningSchemeV4:
+		return signedURLV4(bucket, name, opts, signBytes, now().UTC())
+	default:
+		return "", fmt.Errorf("storage: unknown signing scheme %d", opts.Scheme)
+	}
+}
 
-	signBytes := opts.SignBytes
-	if opts.PrivateKey != nil {
-		key, err := parseKey(opts.PrivateKey)
-		if err != nil {
-			return "", err
-		}
-		signBytes = func(b []byte) ([]byte, error) {
-			sum := sha256.Sum256(b)
-			return rsa.SignPKCS1v15(
-				rand.Reader,
-				key,
-				crypto.SHA256,
-				sum[:],
-			)
-		}
-	} else {
-		signBytes = opts.SignBytes
+// signer returns a function that signs bytes with the given PEM or DER
+// encoded private key, or signBytes if key is nil.
+func signer(key []byte, signBytes func([]byte) ([]byte, error)) (func([]byte) ([]byte, error), error) {
+	if key == nil {
+		return signBytes, nil
+	}
+	parsed, err := parseKey(key)
+	if err != nil {
+		return nil, err
 	}
+	return func(b []byte) ([]byte, error) {
+		sum := sha256.Sum256(b)
+		return rsa.SignPKCS1v15(
+			rand.Reader,
+			parsed,
+			crypto.SHA256,
+			sum[:],
+		)
+	}, nil
+}
 
+func signedURLV2(bucket, name string, opts *SignedURLOptions, signBytes func([]byte) ([]byte, error)) (string, error) {
+	if opts.QueryParameters != nil {
+		return "", errors.New("storage: QueryParameters require SigningSchemeV4")
+	}
+	if _, ok := opts.Style.(pathStyle); opts.Style != nil && !ok {
+		return "", errors.New("storage: URL styles other than PathStyle require SigningSchemeV4")
+	}
 	u := &url.URL{
 		Path: fmt.Sprintf("/%s/%s", bucket, name),
 	}
@@ -260,6 +359,9 @@ func SignedURL(bucket, name string, opts *SignedURLOptions) (string, error) {
 	}
 	encoded := base64.StdEncoding.EncodeToString(b)
 	u.Scheme = "https"
+	if opts.Insecure {
+		u.Scheme = "http"
+	}
 	u.Host = "storage.googleapis.com"
 	q := u.Query()
 	q.Set("GoogleAccessId", opts.GoogleAccessID)
@@ -278,7 +380,9 @@ type ObjectHandle struct {
 	acl           ACLHandle
 	gen           int64 // a negative value indicates latest
 	conds         *Conditions
-	encryptionKey []byte // AES-256 key
+	encryptionKey []byte       // AES-256 key
+	retry         *retryConfig // nil for the defaults
+	userProject   string       // project for requester-pays buckets
 }
 
 // ACL provides access to the object's access control list.
@@ -321,6 +425,21 @@ func (o *ObjectHandle) Key(encryptionKey []byte) *ObjectHandle {
 	return &o2
 }
 
+// Retryer returns a new ObjectHandle that retries operations as configured
+// by opts, which are applied on top of any already set on the ObjectHandle.
+// They apply to all the operations of the handle, including those of its
+// ACLHandle, Writers, Readers, Copiers and Composers.
+//
+// By default, an ObjectHandle retries idempotent operations that fail with
+// transient errors, with exponential backoff, until they succeed or their
+// context is done. See RetryPolicy for which operations are idempotent.
+func (o *ObjectHandle) Retryer(opts ...RetryOption) *ObjectHandle {
+	o2 := *o
+	o2.retry = o.retry.with(opts)
+	o2.acl.retry = o2.retry
+	return &o2
+}
+
 // Attrs returns meta information about the object.
 // ErrObjectNotExist will be returned if the object is not found.
 func (o *ObjectHandle) Attrs(ctx context.Context) (*ObjectAttrs, error) {
@@ -331,12 +450,15 @@ func (o *ObjectHandle) Attrs(ctx context.Context) (*ObjectAttrs, error) {
 	if err := applyConds("Attrs", o.gen, o.conds, call); err != nil {
 		return nil, err
 	}
+	if o.userProject != "" {
+		call.UserProject(o.userProject)
+	}
 	if err := setEncryptionHeaders(call.Header(), o.encryptionKey, false); err != nil {
 		return nil, err
 	}
 	var obj *raw.Object
 	var err error
-	err = runWithRetry(ctx, func() error { obj, err = call.Do(); return err })
+	err = runWithRetry(ctx, o.retry, true, func() error { obj, err = call.Do(); return err })
 	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
 		return nil, ErrObjectNotExist
 	}
@@ -353,6 +475,53 @@ func (o *ObjectHandle) Update(ctx context.Context, uattrs ObjectAttrsToUpdate) (
 	if err := o.validate(); err != nil {
 		return nil, err
 	}
+	rawObj := uattrs.toRawObject(o.bucket)
+	call := o.c.raw.Objects.Patch(o.bucket, o.object, rawObj).Projection("full").Context(ctx)
+	if err := applyConds("Update", o.gen, o.conds, call); err != nil {
+		return nil, err
+	}
+	if o.userProject != "" {
+		call.UserProject(o.userProject)
+	}
+	if err := setEncryptionHeaders(call.Header(), o.encryptionKey, false); err != nil {
+		return nil, err
+	}
+	var obj *raw.Object
+	var err error
+	isIdempotent := o.conds != nil && o.conds.MetagenerationMatch != 0
+	err = runWithRetry(ctx, o.retry, isIdempotent, func() error { obj, err = call.Do(); return err })
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
+		return nil, ErrObjectNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newObject(obj), nil
+}
+
+// ObjectAttrsToUpdate is used to update the attributes of an object.
+// Only fields set to non-nil values will be updated.
+// Set a field to its zero value to delete it.
+//
+// For example, to change ContentType and delete ContentEncoding and
+// Metadata, use
+//    ObjectAttrsToUpdate{
+//        ContentType: "text/html",
+//        ContentEncoding: "",
+//        Metadata: map[string]string{},
+//    }
+type ObjectAttrsToUpdate struct {
+	ContentType        optional.String
+	ContentLanguage    optional.String
+	ContentEncoding    optional.String
+	ContentDisposition optional.String
+	CacheControl       optional.String
+	Metadata           map[string]string // set to map[string]string{} to delete
+	ACL                []ACLRule
+}
+
+// toRawObject returns the object to send in a patch request for the update.
+func (uattrs *ObjectAttrsToUpdate) toRawObject(bucket string) *raw.Object {
 	var attrs ObjectAttrs
 	// Lists of fields to send, and set to null, in the JSON.
 	var forceSendFields, nullFields []string
@@ -397,47 +566,10 @@ func (o *ObjectHandle) Update(ctx context.Context, uattrs ObjectAttrsToUpdate) (
 		// we don't append to nullFields here.
 		forceSendFields = append(forceSendFields, "Acl")
 	}
-	rawObj := attrs.toRawObject(o.bucket)
+	rawObj := attrs.toRawObject(bucket)
 	rawObj.ForceSendFields = forceSendFields
 	rawObj.NullFields = nullFields
-	call := o.c.raw.Objects.Patch(o.bucket, o.object, rawObj).Projection("full").Context(ctx)
-	if err := applyConds("Update", o.gen, o.conds, call); err != nil {
-		return nil, err
-	}
-	if err := setEncryptionHeaders(call.Header(), o.encryptionKey, false); err != nil {
-		return nil, err
-	}
-	var obj *raw.Object
-	var err error
-	err = runWithRetry(ctx, func() error { obj, err = call.Do(); return err })
-	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusNotFound {
-		return nil, ErrObjectNotExist
-	}
-	if err != nil {
-		return nil, err
-	}
-	return newObject(obj), nil
-}
-
-// ObjectAttrsToUpdate is used to update the attributes of an object.
-// Only fields set to non-nil values will be updated.
-// Set a field to its zero value to delete it.
-//
-// For example, to change ContentType and delete ContentEncoding and
-// Metadata, use
-//    ObjectAttrsToUpdate{
-//        ContentType: "text/html",
-//        ContentEncoding: "",
-//        Metadata: map[string]string{},
-//    }
-type ObjectAttrsToUpdate struct {
-	ContentType        optional.String
-	ContentLanguage    optional.String
-	ContentEncoding    optional.String
-	ContentDisposition optional.String
-	CacheControl       optional.String
-	Metadata           map[string]string // set to map[string]string{} to delete
-	ACL                []ACLRule
+	return rawObj
 }
 
 // Delete deletes the single specified object.
@@ -449,7 +581,12 @@ func (o *ObjectHandle) Delete(ctx context.Context) error {
 	if err := applyConds("Delete", o.gen, o.conds, call); err != nil {
 		return err
 	}
-	err := runWithRetry(ctx, func() error { return call.Do() })
+	if o.userProject != "" {
+		call.UserProject(o.userProject)
+	}
+	// Deleting a specific generation is idempotent.
+	isIdempotent := o.gen >= 0 || o.conds != nil && o.conds.GenerationMatch != 0
+	err := runWithRetry(ctx, o.retry, isIdempotent, func() error { return call.Do() })
 	switch e := err.(type) {
 	case nil:
 		return nil
@@ -486,11 +623,17 @@ func (o *ObjectHandle) NewRangeReader(ctx context.Context, offset, length int64)
 		}
 	}
 	u := &url.URL{
-		Scheme:   "https",
-		Host:     "storage.googleapis.com",
+		Scheme:   o.c.scheme,
+		Host:     o.c.readHost,
 		Path:     fmt.Sprintf("/%s/%s", o.bucket, o.object),
 		RawQuery: conditionsQuery(o.gen, o.conds),
 	}
+	if o.userProject != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += "userProject=" + url.QueryEscape(o.userProject)
+	}
 	verb := "GET"
 	if length == 0 {
 		verb = "HEAD"
@@ -508,7 +651,7 @@ func (o *ObjectHandle) NewRangeReader(ctx context.Context, offset, length int64)
 		return nil, err
 	}
 	var res *http.Response
-	err = runWithRetry(ctx, func() error { res, err = o.c.hc.Do(req); return err })
+	err = runWithRetry(ctx, o.retry, true, func() error { res, err = o.c.hc.Do(req); return err })
 	if err != nil {
 		return nil, err
 	}
@@ -552,14 +695,48 @@ func (o *ObjectHandle) NewRangeReader(ctx context.Context, offset, length int64)
 		body = emptyBody
 	}
 
+	// The checksum covers the stored contents of the whole object. It can't
+	// be checked for a range, nor when the object was stored gzip-encoded
+	// and has been decompressed by the service (decompressive transcoding)
+	// or by the transport.
+	crc, checkCRC := parseCRC32C(res.Header)
+	if res.StatusCode != http.StatusOK || length == 0 || res.Uncompressed {
+		checkCRC = false
+	}
+	if res.Header.Get("X-Goog-Stored-Content-Encoding") == "gzip" && res.Header.Get("Content-Encoding") != "gzip" {
+		checkCRC = false
+	}
+
 	return &Reader{
 		body:        body,
 		size:        size,
 		remain:      remain,
 		contentType: res.Header.Get("Content-Type"),
+		checkCRC:    checkCRC,
+		wantCRC:     crc,
 	}, nil
 }
 
+// parseCRC32C returns the CRC32C checksum in the X-Goog-Hash headers of a
+// response from the XML API, which hold comma-separated pairs such as
+// "crc32c=n03x6A==".
+func parseCRC32C(h http.Header) (uint32, bool) {
+	for _, v := range h["X-Goog-Hash"] {
+		for _, kv := range strings.Split(v, ",") {
+			kv = strings.TrimSpace(kv)
+			if !strings.HasPrefix(kv, "crc32c=") {
+				continue
+			}
+			b, err := base64.StdEncoding.DecodeString(kv[len("crc32c="):])
+			if err != nil || len(b) != 4 {
+				return 0, false
+			}
+			return binary.BigEndian.Uint32(b), true
+		}
+	}
+	return 0, false
+}
+
 var emptyBody = ioutil.NopCloser(strings.NewReader(""))
 
 // NewWriter returns a storage Writer that writes to the GCS object
@@ -586,6 +763,12 @@ func (o *ObjectHandle) NewWriter(ctx context.Context) *Writer {
 	}
 }
 
+// isGenerationConditional reports whether writes to the object are
+// conditional on its generation, and so are idempotent.
+func (o *ObjectHandle) isGenerationConditional() bool {
+	return o.conds != nil && (o.conds.GenerationMatch != 0 || o.conds.DoesNotExist)
+}
+
 func (o *ObjectHandle) validate() error {
 	if o.bucket == "" {
 		return errors.New("storage: bucket name is empty")
@@ -690,11 +873,15 @@ type ObjectAttrs struct {
 	// sent in the response headers.
 	ContentDisposition string
 
-	// MD5 is the MD5 hash of the object's content. This field is read-only.
+	// MD5 is the MD5 hash of the object's content. This field is read-only,
+	// except when used from a Writer. If set on a Writer, the service
+	// rejects the upload if the MD5 hash of the data doesn't match.
 	MD5 []byte
 
 	// CRC32C is the CRC32 checksum of the object's content using
-	// the Castagnoli93 polynomial. This field is read-only.
+	// the Castagnoli93 polynomial. This field is read-only, except when used
+	// from a Writer. If set on a Writer and Writer.SendCRC32C is true, the
+	// service rejects the upload if the CRC32C of the data doesn't match.
 	CRC32C uint32
 
 	// MediaLink is an URL to the object's content. This field is read-only.
@@ -744,6 +931,19 @@ type ObjectAttrs struct {
 	// encryption in Google Cloud Storage.
 	CustomerKeySHA256 string
 
+	// KMSKeyName is the resource name of the Cloud KMS key that encrypts the
+	// object, such as
+	// "projects/P/locations/L/keyRings/R/cryptoKeys/K". When writing an
+	// object, it selects the key with which the Writer encrypts it; if it is
+	// empty, the bucket's default key is used, if any. It is an error to set
+	// it on a Writer whose ObjectHandle has a customer-supplied encryption
+	// key. The service reports the name with the key version that it used
+	// appended, as in ".../cryptoKeys/K/cryptoKeyVersions/1"; Writers and
+	// Copiers drop the version, so attributes read from one object can be
+	// reused for another. See Copier to change the key of an existing
+	// object.
+	KMSKeyName string
+
 	// Prefix is set only for ObjectAttrs which represent synthetic "directory
 	// entries" when iterating over buckets using Query.Delimiter. See
 	// ObjectIterator.Next. When set, no other fields in ObjectAttrs will be
@@ -761,6 +961,15 @@ func convertTime(t string) time.Time {
 	return r
 }
 
+// kmsKeyWithoutVersion returns the name of a Cloud KMS key without any key
+// version, which the service reports but does not accept in requests.
+func kmsKeyWithoutVersion(name string) string {
+	if i := strings.Index(name, "/cryptoKeyVersions/"); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
 func newObject(o *raw.Object) *ObjectAttrs {
 	if o == nil {
 		return nil
@@ -804,6 +1013,7 @@ func newObject(o *raw.Object) *ObjectAttrs {
 		MetaGeneration:    o.Metageneration,
 		StorageClass:      o.StorageClass,
 		CustomerKeySHA256: sha256,
+		KMSKeyName:        o.KmsKeyName,
 		Created:           convertTime(o.TimeCreated),
 		Deleted:           convertTime(o.TimeDeleted),
 		Updated:           convertTime(o.Updated),
@@ -829,6 +1039,164 @@ type Query struct {
 	// Versions indicates whether multiple versions of the same
 	// object will be included in the results.
 	Versions bool
+
+	// StartOffset restricts the results to objects whose names are
+	// lexicographically equal to or after StartOffset. With EndOffset, it
+	// divides a listing into ranges which can be read independently.
+	// Optional.
+	StartOffset string
+
+	// EndOffset restricts the results to objects whose names are
+	// lexicographically before EndOffset.
+	// Optional.
+	EndOffset string
+
+	// MatchGlob restricts the results to objects whose names match the glob
+	// pattern. In the pattern, "*" matches any sequence of characters other
+	// than "/", "**" matches any sequence of characters, "?" matches any
+	// single character other than "/", and "[...]" matches a character
+	// class, which is negated if it begins with "!" or "^". A backslash
+	// escapes the character after it. A "**/" matches any number of whole
+	// path segments, including none, so "**/*.parquet" matches every object
+	// whose name ends in ".parquet", at any depth.
+	//
+	// The service does not support globs, so objects are filtered as they
+	// are listed, after narrowing the listing with the literal prefix of the
+	// pattern. Prefixes returned because of Delimiter are not filtered.
+	// Optional.
+	MatchGlob string
+
+	// fieldSelection is the partial response selector for listing, set by
+	// SetAttrSelection.
+	fieldSelection string
+}
+
+// attrToFieldMap maps the names of the fields of ObjectAttrs to the names of
+// the fields of the objects in the JSON API.
+var attrToFieldMap = map[string]string{
+	"Bucket":             "bucket",
+	"Name":               "name",
+	"ContentType":        "contentType",
+	"ContentLanguage":    "contentLanguage",
+	"CacheControl":       "cacheControl",
+	"ACL":                "acl",
+	"Owner":              "owner",
+	"Size":               "size",
+	"ContentEncoding":    "contentEncoding",
+	"ContentDisposition": "contentDisposition",
+	"MD5":                "md5Hash",
+	"CRC32C":             "crc32c",
+	"MediaLink":          "mediaLink",
+	"Metadata":           "metadata",
+	"Generation":         "generation",
+	"MetaGeneration":     "metageneration",
+	"StorageClass":       "storageClass",
+	"Created":            "timeCreated",
+	"Deleted":            "timeDeleted",
+	"Updated":            "updated",
+	"CustomerKeySHA256":  "customerEncryption",
+	"KMSKeyName":         "kmsKeyName",
+}
+
+// SetAttrSelection makes the query populate only the given attributes of
+// the objects it lists, which reduces the size of the responses. The
+// attributes are named by the fields of ObjectAttrs, such as "Size" and
+// "Updated". Name is always populated. Passing no attributes restores the
+// default, which populates every attribute.
+func (q *Query) SetAttrSelection(attrs []string) error {
+	if len(attrs) == 0 {
+		q.fieldSelection = ""
+		return nil
+	}
+	fields := []string{"name"}
+	for _, attr := range attrs {
+		field, ok := attrToFieldMap[attr]
+		if !ok {
+			return fmt.Errorf("storage: SetAttrSelection: unknown attribute %q", attr)
+		}
+		if field != "name" {
+			fields = append(fields, field)
+		}
+	}
+	q.fieldSelection = "nextPageToken,prefixes,items(" + strings.Join(fields, ",") + ")"
+	return nil
+}
+
+// globToRegexp translates a pattern in the syntax of Query.MatchGlob into a
+// regular expression.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var buf bytes.Buffer
+	buf.WriteString("(?s)^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(glob[i:], "**/"):
+				// Any number of whole path segments, including none.
+				buf.WriteString("(?:.*/)?")
+				i += 2
+			case strings.HasPrefix(glob[i:], "**"):
+				buf.WriteString(".*")
+				i++
+			default:
+				buf.WriteString("[^/]*")
+			}
+		case '?':
+			buf.WriteString("[^/]")
+		case '[':
+			// A ']' immediately after the '[' or negation is literal.
+			j := i + 1
+			if j < len(glob) && (glob[j] == '!' || glob[j] == '^') {
+				j++
+			}
+			if j < len(glob) && glob[j] == ']' {
+				j++
+			}
+			end := strings.IndexByte(glob[j:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("storage: unterminated character class in glob %q", glob)
+			}
+			class := glob[i+1 : j+end]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + strings.Replace(class, `[`, `\[`, -1) + "]")
+			i = j + end
+		case '\\':
+			if i+1 == len(glob) {
+				return nil, fmt.Errorf("storage: trailing backslash in glob %q", glob)
+			}
+			i++
+			buf.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			buf.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	buf.WriteString("$")
+	re, err := regexp.Compile(buf.String())
+	if err != nil {
+		return nil, fmt.Errorf("storage: invalid glob %q: %v", glob, err)
+	}
+	return re, nil
+}
+
+// globPrefix returns the literal prefix of a glob pattern, which every
+// name that matches it begins with.
+func globPrefix(glob string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*', '?', '[':
+			return buf.String()
+		case '\\':
+			if i+1 == len(glob) {
+				return buf.String()
+			}
+			i++
+		}
+		buf.WriteByte(glob[i])
+	}
+	return buf.String()
 }
 
 // contentTyper implements ContentTyper to enable an
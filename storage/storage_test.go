@@ -18,6 +18,7 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"log"
@@ -667,6 +668,39 @@ func TestEmptyBucketIterator(t *testing.T) {
 	}
 }
 
+func TestEndpoints(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		opts                 []option.ClientOption
+		wantBase             string
+		wantScheme, wantHost string
+	}{
+		{nil, "https://www.googleapis.com/storage/v1/", "https", "storage.googleapis.com"},
+		// The JSON endpoint does not move the XML API.
+		{
+			[]option.ClientOption{option.WithEndpoint("https://www.googleapis.com/storage/v1/")},
+			"https://www.googleapis.com/storage/v1/", "https", "storage.googleapis.com",
+		},
+		{
+			[]option.ClientOption{option.WithEndpoint("http://localhost:8080/storage/v1/"), WithXMLEndpoint("http://localhost:8080")},
+			"http://localhost:8080/storage/v1/", "http", "localhost:8080",
+		},
+	} {
+		opts := append([]option.ClientOption{option.WithHTTPClient(&http.Client{})}, test.opts...)
+		c, err := NewClient(ctx, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.raw.BasePath != test.wantBase || c.scheme != test.wantScheme || c.readHost != test.wantHost {
+			t.Errorf("%v: got %q, %s://%s, want %q, %s://%s", test.opts,
+				c.raw.BasePath, c.scheme, c.readHost, test.wantBase, test.wantScheme, test.wantHost)
+		}
+	}
+	if _, err := NewClient(ctx, option.WithHTTPClient(&http.Client{}), WithXMLEndpoint("localhost")); err == nil {
+		t.Error("got nil error for an XML endpoint without a scheme, want one")
+	}
+}
+
 func newTestServer(handler func(w http.ResponseWriter, r *http.Request)) (*http.Client, func()) {
 	ts := httptest.NewTLSServer(http.HandlerFunc(handler))
 	tlsConf := &tls.Config{InsecureSkipVerify: true}
@@ -681,3 +715,132 @@ func newTestServer(handler func(w http.ResponseWriter, r *http.Request)) (*http.
 		ts.Close()
 	}
 }
+
+func TestReaderCRC32C(t *testing.T) {
+	const contents = "hello, world"
+	crc := crc32.Checksum([]byte(contents), crc32cTable)
+	for _, test := range []struct {
+		desc    string
+		body    string
+		header  map[string]string
+		offset  int64
+		wantErr bool
+	}{
+		{desc: "intact", body: contents},
+		{desc: "corrupted", body: "hello, World", wantErr: true},
+		{
+			desc:   "range",
+			body:   "lo, World",
+			header: map[string]string{"Content-Range": "bytes 3-11/12"},
+			offset: 3,
+		},
+		{
+			desc:   "transcoded",
+			body:   "hello, World",
+			header: map[string]string{"X-Goog-Stored-Content-Encoding": "gzip"},
+		},
+	} {
+		hc, close := newTestServer(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Goog-Hash", "crc32c="+encodeUint32(crc)+",md5=xxx")
+			for k, v := range test.header {
+				w.Header().Set(k, v)
+			}
+			if r.Header.Get("Range") != "" {
+				w.WriteHeader(http.StatusPartialContent)
+			}
+			io.WriteString(w, test.body)
+		})
+		ctx := context.Background()
+		client, err := NewClient(ctx, option.WithHTTPClient(hc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := client.Bucket("b").Object("o").NewRangeReader(ctx, test.offset, -1)
+		if err != nil {
+			t.Fatalf("%s: %v", test.desc, err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("%s: got error %v, want error: %t", test.desc, err, test.wantErr)
+		}
+		if string(b) != test.body {
+			t.Errorf("%s: got %q, want %q", test.desc, b, test.body)
+		}
+		close()
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	for _, test := range []struct {
+		glob  string
+		match []string
+		miss  []string
+	}{
+		{"*.txt", []string{"a.txt", ".txt"}, []string{"dir/a.txt", "a.txt.gz"}},
+		{"**/*.parquet", []string{"b.parquet", "a/b.parquet", "a/b/c.parquet"}, []string{"a/b.parquet/c", "b.parquet.gz"}},
+		{"logs/**", []string{"logs/a", "logs/a/b", "logs/"}, []string{"logs", "other/a"}},
+		{"a/**/b", []string{"a/b", "a/x/b", "a/x/y/b"}, []string{"a/xb", "ab"}},
+		{"file?.csv", []string{"file1.csv", "file界.csv"}, []string{"file.csv", "file/.csv", "file12.csv"}},
+		{"[a-c]*", []string{"apple", "cherry"}, []string{"date", "Apple"}},
+		{"[!a-c]*", []string{"date"}, []string{"apple"}},
+		{"[]]", []string{"]"}, []string{"["}},
+		{`a\*b`, []string{"a*b"}, []string{"axb"}},
+		{"a.b+c(d)", []string{"a.b+c(d)"}, []string{"axb+c(d)", "a.bbc(d)"}},
+		{"界/*", []string{"界/a"}, []string{"界a", "a/b"}},
+	} {
+		re, err := globToRegexp(test.glob)
+		if err != nil {
+			t.Errorf("%q: %v", test.glob, err)
+			continue
+		}
+		for _, name := range test.match {
+			if !re.MatchString(name) {
+				t.Errorf("%q does not match %q", test.glob, name)
+			}
+		}
+		for _, name := range test.miss {
+			if re.MatchString(name) {
+				t.Errorf("%q matches %q", test.glob, name)
+			}
+		}
+	}
+	for _, glob := range []string{"[abc", `abc\`, "[z-a]"} {
+		if _, err := globToRegexp(glob); err == nil {
+			t.Errorf("%q: got nil error, want one", glob)
+		}
+	}
+}
+
+func TestGlobPrefix(t *testing.T) {
+	for _, test := range []struct {
+		glob, want string
+	}{
+		{"logs/2017/*.gz", "logs/2017/"},
+		{"**/*.parquet", ""},
+		{"a?b", "a"},
+		{"data[0-9]", "data"},
+		{`a\*b*`, "a*b"},
+		{"plain", "plain"},
+	} {
+		if got := globPrefix(test.glob); got != test.want {
+			t.Errorf("globPrefix(%q) = %q, want %q", test.glob, got, test.want)
+		}
+	}
+}
+
+func TestSetAttrSelection(t *testing.T) {
+	var q Query
+	if err := q.SetAttrSelection([]string{"Size", "Updated", "Name"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "nextPageToken,prefixes,items(name,size,updated)"; q.fieldSelection != want {
+		t.Errorf("got %q, want %q", q.fieldSelection, want)
+	}
+	if err := q.SetAttrSelection([]string{"Nonexistent"}); err == nil {
+		t.Error("unknown attribute: got nil error, want one")
+	}
+	if err := q.SetAttrSelection(nil); err != nil || q.fieldSelection != "" {
+		t.Errorf("reset: got %q, %v", q.fieldSelection, err)
+	}
+}
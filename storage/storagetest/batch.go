@@ -0,0 +1,105 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+)
+
+// batchPath is the path of the batch endpoint.
+const batchPath = "/batch/storage/v1"
+
+// maxBatchSize is the largest number of requests in a batch.
+const maxBatchSize = 100
+
+// serveBatch serves a batch of requests to the JSON API, sent as the parts of
+// a multipart/mixed body, each holding an HTTP request. The responses are
+// returned in the same form.
+func (s *server) serveBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, errorf(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method))
+		return
+	}
+	mt, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mt != "multipart/mixed" {
+		writeError(w, badRequest("bad batch Content-Type %q", r.Header.Get("Content-Type")))
+		return
+	}
+	type result struct {
+		id  string
+		rec *httptest.ResponseRecorder
+	}
+	var results []result
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, badRequest("reading batch: %v", err))
+			return
+		}
+		if len(results) == maxBatchSize {
+			writeError(w, badRequest("A batch may contain at most %d requests.", maxBatchSize))
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(part))
+		if err != nil {
+			writeError(w, badRequest("reading batch request: %v", err))
+			return
+		}
+		rec := httptest.NewRecorder()
+		if !strings.HasPrefix(req.URL.Path, jsonPrefix) {
+			writeError(rec, notFound("no such API in batch: %s", req.URL.Path))
+		} else {
+			req.Host = r.Host
+			s.ServeHTTP(rec, req)
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(part.Header.Get("Content-ID"), "<"), ">")
+		results = append(results, result{id: id, rec: rec})
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, res := range results {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", "application/http")
+		h.Set("Content-ID", "<response-"+res.id+">")
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		code := res.rec.Code
+		fmt.Fprintf(pw, "HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+		res.rec.HeaderMap.Set("Content-Length", fmt.Sprint(res.rec.Body.Len()))
+		res.rec.HeaderMap.Write(pw)
+		fmt.Fprintf(pw, "\r\n")
+		pw.Write(res.rec.Body.Bytes())
+	}
+	mw.Close()
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.Write(body.Bytes())
+}
@@ -0,0 +1,536 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	raw "google.golang.org/api/storage/v1"
+)
+
+type bucket struct {
+	project string
+	meta    raw.Bucket
+
+	// objects holds the live generation of each object, keyed by name.
+	objects map[string]*object
+	// archived holds the noncurrent generations of each object, oldest
+	// first, if versioning is enabled.
+	archived map[string][]*object
+
+	// notifications holds the bucket's notification configurations, keyed
+	// by ID. Messages are not published.
+	notifications map[string]*raw.Notification
+
+	// policy is the bucket's IAM policy. Its etag encodes policyVersion,
+	// which is incremented each time the policy is set.
+	policy        raw.Policy
+	policyVersion int64
+}
+
+func (b *bucket) versioned() bool {
+	return b.meta.Versioning != nil && b.meta.Versioning.Enabled
+}
+
+// lookup returns the object with the given name and generation, or the live
+// generation if gen is negative.
+func (b *bucket) lookup(name string, gen int64) (*object, error) {
+	if obj := b.objects[name]; obj != nil && (gen < 0 || obj.meta.Generation == gen) {
+		return obj, nil
+	}
+	if gen >= 0 {
+		for _, obj := range b.archived[name] {
+			if obj.meta.Generation == gen {
+				return obj, nil
+			}
+		}
+	}
+	return nil, notFound("No such object: %s/%s", b.meta.Name, name)
+}
+
+// put makes obj the live generation of its object, archiving or discarding
+// the previous one.
+func (b *bucket) put(obj *object) {
+	name := obj.meta.Name
+	if old := b.objects[name]; old != nil {
+		b.archive(old)
+	}
+	b.objects[name] = obj
+}
+
+// remove deletes the given generation of an object.
+func (b *bucket) remove(obj *object) {
+	name := obj.meta.Name
+	if b.objects[name] == obj {
+		delete(b.objects, name)
+		b.archive(obj)
+		return
+	}
+	versions := b.archived[name]
+	for i, o := range versions {
+		if o == obj {
+			b.archived[name] = append(versions[:i:i], versions[i+1:]...)
+			break
+		}
+	}
+	if len(b.archived[name]) == 0 {
+		delete(b.archived, name)
+	}
+}
+
+func (b *bucket) archive(obj *object) {
+	if !b.versioned() {
+		return
+	}
+	obj.meta.TimeDeleted = formatTime(time.Now())
+	b.archived[obj.meta.Name] = append(b.archived[obj.meta.Name], obj)
+}
+
+func (s *server) bucket(name string) (*bucket, error) {
+	b, ok := s.buckets[name]
+	if !ok {
+		return nil, notFound("Not Found: bucket %s", name)
+	}
+	return b, nil
+}
+
+func (s *server) serveBuckets(r *http.Request) (interface{}, error) {
+	project := r.URL.Query().Get("project")
+	if project == "" {
+		return nil, badRequest("Required parameter: project")
+	}
+	switch r.Method {
+	case "GET":
+		return s.listBuckets(r, project)
+	case "POST":
+		return s.insertBucket(r, project)
+	}
+	return nil, errorf(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method)
+}
+
+func (s *server) insertBucket(r *http.Request, project string) (interface{}, error) {
+	var meta raw.Bucket
+	if err := decodeBody(r, &meta); err != nil {
+		return nil, err
+	}
+	if meta.Name == "" {
+		return nil, badRequest("Required: bucket name")
+	}
+	if _, ok := s.buckets[meta.Name]; ok {
+		return nil, errorf(http.StatusConflict, "conflict", "You already own this bucket. Please select another name.")
+	}
+	meta.Kind = "storage#bucket"
+	meta.Id = meta.Name
+	meta.Metageneration = 1
+	meta.TimeCreated = formatTime(time.Now())
+	meta.Updated = meta.TimeCreated
+	if meta.Location == "" {
+		meta.Location = "US"
+	}
+	if meta.StorageClass == "" {
+		meta.StorageClass = "STANDARD"
+	}
+	if meta.Acl == nil {
+		meta.Acl = newBucketACL(meta.Name)
+	}
+	if err := updateRetention(nil, meta.RetentionPolicy); err != nil {
+		return nil, err
+	}
+	b := &bucket{
+		project:       project,
+		meta:          meta,
+		objects:       make(map[string]*object),
+		archived:      make(map[string][]*object),
+		notifications: make(map[string]*raw.Notification),
+		policy: raw.Policy{
+			Kind:       "storage#policy",
+			ResourceId: "projects/_/buckets/" + meta.Name,
+			Etag:       policyEtag(1),
+		},
+		policyVersion: 1,
+	}
+	s.buckets[meta.Name] = b
+	return &b.meta, nil
+}
+
+func (s *server) listBuckets(r *http.Request, project string) (interface{}, error) {
+	q := r.URL.Query()
+	prefix, token := q.Get("prefix"), q.Get("pageToken")
+	max, err := maxResults(q.Get("maxResults"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name, b := range s.buckets {
+		if b.project == project && strings.HasPrefix(name, prefix) && name > token {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	resp := &raw.Buckets{Kind: "storage#buckets"}
+	for _, name := range names {
+		if len(resp.Items) == max {
+			resp.NextPageToken = resp.Items[max-1].Name
+			break
+		}
+		resp.Items = append(resp.Items, &s.buckets[name].meta)
+	}
+	return resp, nil
+}
+
+func maxResults(v string) (int, error) {
+	if v == "" {
+		return 1000, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, badRequest("invalid maxResults %q", v)
+	}
+	return n, nil
+}
+
+func (s *server) serveBucket(r *http.Request, name string) (interface{}, error) {
+	b, err := s.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	conds, err := parseConditions(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	if err := check(1, b.meta.Metageneration, nil, nil, conds.metagenMatch, conds.metagenNotMatch); err != nil {
+		return nil, err
+	}
+	switch r.Method {
+	case "GET":
+		return &b.meta, nil
+	case "PATCH", "PUT":
+		// Updates are treated as patches.
+		var patch map[string]json.RawMessage
+		if err := decodeBody(r, &patch); err != nil {
+			return nil, err
+		}
+		meta := b.meta
+		if err := mergePatch(&meta, patch); err != nil {
+			return nil, badRequest("%v", err)
+		}
+		if err := updateRetention(b.meta.RetentionPolicy, meta.RetentionPolicy); err != nil {
+			return nil, err
+		}
+		b.meta = meta
+		b.meta.Metageneration++
+		b.meta.Updated = formatTime(time.Now())
+		return &b.meta, nil
+	case "DELETE":
+		if len(b.objects) > 0 || len(b.archived) > 0 {
+			return nil, errorf(http.StatusConflict, "conflict", "The bucket you tried to delete was not empty.")
+		}
+		delete(s.buckets, name)
+		return nil, nil
+	}
+	return nil, errorf(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method)
+}
+
+// updateRetention checks a change to a bucket's retention policy from old to
+// rp, and records when the new policy takes effect.
+func updateRetention(old, rp *raw.BucketRetentionPolicy) error {
+	if old != nil && old.IsLocked {
+		if rp == nil || rp.RetentionPeriod < old.RetentionPeriod {
+			return errorf(http.StatusForbidden, "forbidden", "The retention policy of the bucket is locked, and cannot be removed or reduced.")
+		}
+	}
+	if rp == nil {
+		return nil
+	}
+	if rp.RetentionPeriod <= 0 {
+		return badRequest("Invalid retention period %d", rp.RetentionPeriod)
+	}
+	// Only locking a policy may set IsLocked.
+	rp.IsLocked = old != nil && old.IsLocked
+	if old == nil || rp.RetentionPeriod != old.RetentionPeriod {
+		rp.EffectiveTime = formatTime(time.Now())
+	}
+	return nil
+}
+
+func (s *server) lockRetentionPolicy(r *http.Request, name string) (interface{}, error) {
+	if r.Method != "POST" {
+		return nil, errorf(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method)
+	}
+	b, err := s.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	conds, err := parseConditions(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	if conds.metagenMatch == nil {
+		return nil, badRequest("Required parameter: ifMetagenerationMatch")
+	}
+	if err := check(1, b.meta.Metageneration, nil, nil, conds.metagenMatch, nil); err != nil {
+		return nil, err
+	}
+	if b.meta.RetentionPolicy == nil {
+		return nil, badRequest("The bucket has no retention policy to lock.")
+	}
+	b.meta.RetentionPolicy.IsLocked = true
+	b.meta.Metageneration++
+	b.meta.Updated = formatTime(time.Now())
+	return &b.meta, nil
+}
+
+// serveNotifications serves the notification configurations of a bucket.
+// rest holds the path segments after "notificationConfigs", which name a
+// configuration if present.
+func (s *server) serveNotifications(r *http.Request, name string, rest []string) (interface{}, error) {
+	b, err := s.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 1 {
+		return nil, notFound("no such API")
+	}
+	if len(rest) == 0 {
+		switch r.Method {
+		case "GET":
+			resp := &raw.Notifications{Kind: "storage#notifications"}
+			var ids []string
+			for id := range b.notifications {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			for _, id := range ids {
+				resp.Items = append(resp.Items, b.notifications[id])
+			}
+			return resp, nil
+		case "POST":
+			var n raw.Notification
+			if err := decodeBody(r, &n); err != nil {
+				return nil, err
+			}
+			if !strings.HasPrefix(n.Topic, "//pubsub.googleapis.com/projects/") {
+				return nil, badRequest("Invalid topic %q", n.Topic)
+			}
+			if n.PayloadFormat == "" {
+				return nil, badRequest("Required: payload_format")
+			}
+			s.lastID++
+			n.Id = strconv.FormatInt(s.lastID, 10)
+			n.Kind = "storage#notification"
+			n.Etag = n.Id
+			b.notifications[n.Id] = &n
+			return &n, nil
+		}
+		return nil, errorf(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method)
+	}
+	n, ok := b.notifications[rest[0]]
+	if !ok {
+		return nil, notFound("No such notification: %s", rest[0])
+	}
+	switch r.Method {
+	case "GET":
+		return n, nil
+	case "DELETE":
+		delete(b.notifications, n.Id)
+		return nil, nil
+	}
+	return nil, errorf(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method)
+}
+
+// serveIAM serves the IAM policy of a bucket. rest holds the path segments
+// after "iam".
+func (s *server) serveIAM(r *http.Request, name string, rest []string) (interface{}, error) {
+	b, err := s.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(rest) == 1 && rest[0] == "testPermissions" && r.Method == "GET":
+		// The fake is unauthenticated, so every permission is granted.
+		return &raw.TestIamPermissionsResponse{
+			Kind:        "storage#testIamPermissionsResponse",
+			Permissions: r.URL.Query()["permissions"],
+		}, nil
+	case len(rest) != 0:
+		return nil, notFound("no such API")
+	case r.Method == "GET":
+		p := b.policy
+		return &p, nil
+	case r.Method == "PUT":
+		var p raw.Policy
+		if err := decodeBody(r, &p); err != nil {
+			return nil, err
+		}
+		if p.Etag != "" && p.Etag != b.policy.Etag {
+			return nil, errorf(http.StatusPreconditionFailed, "conditionNotMet", "The policy has been modified since it was read.")
+		}
+		b.policyVersion++
+		b.policy.Bindings = p.Bindings
+		b.policy.Etag = policyEtag(b.policyVersion)
+		p = b.policy
+		return &p, nil
+	}
+	return nil, errorf(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method)
+}
+
+func policyEtag(version int64) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(version))
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// serveBucketACL serves the ACL, or default object ACL, of a bucket. rest
+// holds the path segments after "acl", which name an entity if present.
+func (s *server) serveBucketACL(r *http.Request, name string, isDefault bool, rest []string) (interface{}, error) {
+	b, err := s.bucket(name)
+	if err != nil {
+		return nil, err
+	}
+	if isDefault {
+		acl := &objectACL{bucket: name, rules: &b.meta.DefaultObjectAcl}
+		return serveACL(r, acl, rest)
+	}
+	return serveACL(r, &bucketACL{bucket: name, rules: &b.meta.Acl}, rest)
+}
+
+// bucketACL adapts a bucket's ACL to the acl interface.
+type bucketACL struct {
+	bucket string
+	rules  *[]*raw.BucketAccessControl
+}
+
+func (a *bucketACL) list() interface{} {
+	return &raw.BucketAccessControls{Kind: "storage#bucketAccessControls", Items: *a.rules}
+}
+
+func (a *bucketACL) get(entity string) interface{} {
+	for _, rule := range *a.rules {
+		if rule.Entity == entity {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (a *bucketACL) set(entity, role string) interface{} {
+	rule := &raw.BucketAccessControl{Kind: "storage#bucketAccessControl", Bucket: a.bucket, Entity: entity, Role: role}
+	a.delete(entity)
+	*a.rules = append(*a.rules, rule)
+	return rule
+}
+
+func (a *bucketACL) delete(entity string) bool {
+	for i, rule := range *a.rules {
+		if rule.Entity == entity {
+			*a.rules = append((*a.rules)[:i:i], (*a.rules)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// objectACL adapts an object's ACL, or a bucket's default object ACL, to the
+// acl interface.
+type objectACL struct {
+	bucket, object string
+	rules          *[]*raw.ObjectAccessControl
+}
+
+func (a *objectACL) list() interface{} {
+	return &raw.ObjectAccessControls{Kind: "storage#objectAccessControls", Items: *a.rules}
+}
+
+func (a *objectACL) get(entity string) interface{} {
+	for _, rule := range *a.rules {
+		if rule.Entity == entity {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (a *objectACL) set(entity, role string) interface{} {
+	rule := &raw.ObjectAccessControl{Kind: "storage#objectAccessControl", Bucket: a.bucket, Object: a.object, Entity: entity, Role: role}
+	a.delete(entity)
+	*a.rules = append(*a.rules, rule)
+	return rule
+}
+
+func (a *objectACL) delete(entity string) bool {
+	for i, rule := range *a.rules {
+		if rule.Entity == entity {
+			*a.rules = append((*a.rules)[:i:i], (*a.rules)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// acl is implemented by bucketACL and objectACL.
+type acl interface {
+	list() interface{}
+	get(entity string) interface{} // nil if not found
+	set(entity, role string) interface{}
+	delete(entity string) bool
+}
+
+// serveACL serves the ACL API. rest holds the path segments after "acl".
+func serveACL(r *http.Request, a acl, rest []string) (interface{}, error) {
+	if len(rest) > 1 {
+		return nil, notFound("no such API")
+	}
+	var entity string
+	if len(rest) == 1 {
+		entity = rest[0]
+	}
+	switch {
+	case r.Method == "GET" && entity == "":
+		return a.list(), nil
+	case r.Method == "GET":
+		if rule := a.get(entity); rule != nil {
+			return rule, nil
+		}
+	case r.Method == "POST" && entity == "", r.Method == "PUT" || r.Method == "PATCH":
+		var rule struct {
+			Entity string `json:"entity"`
+			Role   string `json:"role"`
+		}
+		if err := decodeBody(r, &rule); err != nil {
+			return nil, err
+		}
+		if entity == "" {
+			entity = rule.Entity
+		}
+		if entity == "" || rule.Role == "" {
+			return nil, badRequest("entity and role are required")
+		}
+		return a.set(entity, rule.Role), nil
+	case r.Method == "DELETE" && entity != "":
+		if a.delete(entity) {
+			return nil, nil
+		}
+	default:
+		return nil, errorf(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method)
+	}
+	return nil, notFound("No such ACL entry: %s", entity)
+}
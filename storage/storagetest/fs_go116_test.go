@@ -0,0 +1,44 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build go1.16
+
+package storagetest
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"golang.org/x/net/context"
+)
+
+func TestIOFS(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "dir/b.txt", "dir/sub/c.txt", "dir/sub/d.txt"} {
+		if _, err := write(t, bkt.Object(name), 0, []byte("contents of "+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := bkt.FileSystem(ctx).IOFS()
+	if err := fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/sub/c.txt", "dir/sub/d.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	raw "google.golang.org/api/storage/v1"
+)
+
+const hmacAlgorithm = "GOOG4-HMAC-SHA256"
+
+type hmacKey struct {
+	meta    raw.HmacKeyMetadata
+	secret  string
+	version int64
+}
+
+// serveHMACKeys serves requests on the HMAC keys of a project. rest is the
+// path following "projects/<project>/hmacKeys".
+func (s *server) serveHMACKeys(r *http.Request, project string, rest []string) (interface{}, error) {
+	switch {
+	case len(rest) == 0 && r.Method == "POST":
+		return s.createHMACKey(r, project)
+	case len(rest) == 0 && r.Method == "GET":
+		return s.listHMACKeys(r, project)
+	case len(rest) != 1:
+		return nil, notFound("no such API: hmacKeys/%s", strings.Join(rest, "/"))
+	}
+	k, ok := s.hmacKeys[rest[0]]
+	if !ok || k.meta.ProjectId != project {
+		return nil, notFound("Access ID not found in project %s.", project)
+	}
+	switch r.Method {
+	case "GET":
+		return &k.meta, nil
+	case "PUT":
+		var m raw.HmacKeyMetadata
+		if err := decodeBody(r, &m); err != nil {
+			return nil, err
+		}
+		if m.Etag != "" && m.Etag != k.meta.Etag {
+			return nil, errorf(http.StatusPreconditionFailed, "conditionNotMet", "Etag %q does not match %q.", m.Etag, k.meta.Etag)
+		}
+		if k.meta.State == "DELETED" {
+			return nil, badRequest("Cannot update deleted key.")
+		}
+		if m.State != "ACTIVE" && m.State != "INACTIVE" {
+			return nil, badRequest("Invalid state %q.", m.State)
+		}
+		k.meta.State = m.State
+		k.touch()
+		return &k.meta, nil
+	case "DELETE":
+		if k.meta.State != "INACTIVE" {
+			return nil, badRequest("Cannot delete keys in '%s' state.", k.meta.State)
+		}
+		k.meta.State = "DELETED"
+		k.touch()
+		return nil, nil
+	}
+	return nil, errorf(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method)
+}
+
+// touch records a change to the metadata of k.
+func (k *hmacKey) touch() {
+	k.version++
+	k.meta.Etag = strconv.FormatInt(k.version, 10)
+	k.meta.Updated = formatTime(time.Now())
+}
+
+func (s *server) createHMACKey(r *http.Request, project string) (interface{}, error) {
+	email := r.URL.Query().Get("serviceAccountEmail")
+	if email == "" {
+		return nil, badRequest("Required: serviceAccountEmail")
+	}
+	secret := make([]byte, 30)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	s.lastID++
+	accessID := fmt.Sprintf("GOOG1%020d", s.lastID)
+	k := &hmacKey{
+		meta: raw.HmacKeyMetadata{
+			Kind:                "storage#hmacKeyMetadata",
+			Id:                  project + "/" + accessID,
+			AccessId:            accessID,
+			ProjectId:           project,
+			ServiceAccountEmail: email,
+			State:               "ACTIVE",
+			TimeCreated:         formatTime(time.Now()),
+		},
+		secret: base64.StdEncoding.EncodeToString(secret),
+	}
+	k.touch()
+	s.hmacKeys[accessID] = k
+	meta := k.meta
+	return &raw.HmacKey{Kind: "storage#hmacKey", Metadata: &meta, Secret: k.secret}, nil
+}
+
+func (s *server) listHMACKeys(r *http.Request, project string) (interface{}, error) {
+	q := r.URL.Query()
+	email, token := q.Get("serviceAccountEmail"), q.Get("pageToken")
+	showDeleted := q.Get("showDeletedKeys") == "true"
+	max, err := maxResults(q.Get("maxResults"))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for id, k := range s.hmacKeys {
+		m := &k.meta
+		if m.ProjectId == project && (email == "" || m.ServiceAccountEmail == email) &&
+			(showDeleted || m.State != "DELETED") && id > token {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	resp := &raw.HmacKeysMetadata{Kind: "storage#hmacKeysMetadata"}
+	for _, id := range ids {
+		if len(resp.Items) == max {
+			resp.NextPageToken = resp.Items[max-1].AccessId
+			break
+		}
+		resp.Items = append(resp.Items, &s.hmacKeys[id].meta)
+	}
+	return resp, nil
+}
+
+// checkSignature verifies the signature of a request to the XML API that is
+// signed with an HMAC key. Other requests are not authenticated.
+func (s *server) checkSignature(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, hmacAlgorithm+" ") {
+		return nil
+	}
+	params := make(map[string]string)
+	for _, f := range strings.Split(auth[len(hmacAlgorithm)+1:], ",") {
+		f = strings.TrimSpace(f)
+		if i := strings.Index(f, "="); i > 0 {
+			params[f[:i]] = f[i+1:]
+		}
+	}
+	cred := params["Credential"]
+	i := strings.Index(cred, "/")
+	if i < 0 {
+		return badRequest("invalid Credential %q", cred)
+	}
+	accessID, scope := cred[:i], cred[i+1:]
+	k, ok := s.hmacKeys[accessID]
+	if !ok || k.meta.State != "ACTIVE" {
+		return errorf(http.StatusForbidden, "forbidden", "The HMAC key %s is not active.", accessID)
+	}
+	date := r.Header.Get("X-Goog-Date")
+	if len(date) < 8 || scope != date[:8]+"/auto/storage/goog4_request" {
+		return badRequest("invalid credential scope %q for date %q", scope, date)
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s\n%s\n%s\n", r.Method, r.URL.EscapedPath(), r.URL.RawQuery)
+	signed := params["SignedHeaders"]
+	for _, name := range strings.Split(signed, ";") {
+		value := r.Host
+		if name != "host" {
+			var values []string
+			for _, v := range r.Header[http.CanonicalHeaderKey(name)] {
+				values = append(values, strings.Join(strings.Fields(v), " "))
+			}
+			value = strings.Join(values, ",")
+		}
+		fmt.Fprintf(buf, "%s:%s\n", name, value)
+	}
+	fmt.Fprintf(buf, "\n%s\n%s", signed, r.Header.Get("X-Goog-Content-Sha256"))
+	sum := sha256.Sum256(buf.Bytes())
+	stringToSign := fmt.Sprintf("%s\n%s\n%s\n%x", hmacAlgorithm, date, scope, sum[:])
+	key := []byte("GOOG4" + k.secret)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	want := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(params["Signature"]), []byte(want)) {
+		return errorf(http.StatusForbidden, "SignatureDoesNotMatch", "The request signature does not match.")
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
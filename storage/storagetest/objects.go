@@ -0,0 +1,574 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	raw "google.golang.org/api/storage/v1"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+type object struct {
+	meta raw.Object
+	data []byte
+}
+
+// newObject creates a new generation of an object from the metadata supplied
+// by a client, and its contents. It checks any hashes in meta against data.
+func (s *server) newObject(bucket string, meta *raw.Object, data []byte) (*object, error) {
+	sum := md5.Sum(data)
+	md5Hash := base64.StdEncoding.EncodeToString(sum[:])
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(data, crc32cTable))
+	crc32c := base64.StdEncoding.EncodeToString(crc)
+	if meta.Md5Hash != "" && meta.Md5Hash != md5Hash {
+		return nil, badRequest("Provided MD5 hash %q doesn't match calculated MD5 hash %q.", meta.Md5Hash, md5Hash)
+	}
+	if meta.Crc32c != "" && meta.Crc32c != crc32c {
+		return nil, badRequest("Provided CRC32C %q doesn't match calculated CRC32C %q.", meta.Crc32c, crc32c)
+	}
+
+	obj := &object{meta: *meta, data: data}
+	m := &obj.meta
+	now := formatTime(time.Now())
+	m.Kind = "storage#object"
+	m.Bucket = bucket
+	m.Generation = s.nextGen()
+	m.Metageneration = 1
+	m.Id = fmt.Sprintf("%s/%s/%d", bucket, m.Name, m.Generation)
+	m.Size = uint64(len(data))
+	m.Md5Hash = md5Hash
+	m.Crc32c = crc32c
+	m.Etag = strconv.FormatInt(m.Generation, 10)
+	m.TimeCreated = now
+	m.Updated = now
+	m.TimeDeleted = ""
+	if m.ContentType == "" {
+		m.ContentType = "application/octet-stream"
+	}
+	if m.StorageClass == "" {
+		m.StorageClass = "STANDARD"
+	}
+	for _, rule := range m.Acl {
+		rule.Bucket, rule.Object, rule.Generation = bucket, m.Name, m.Generation
+	}
+	return obj, nil
+}
+
+// insert stores obj, if conds are satisfied by the object it replaces.
+func (s *server) insert(b *bucket, obj *object, conds *conditions) error {
+	if err := conds.checkDest(b.objects[obj.meta.Name]); err != nil {
+		return err
+	}
+	if obj.meta.Acl == nil {
+		for _, rule := range b.meta.DefaultObjectAcl {
+			r := *rule
+			r.Bucket, r.Object, r.Generation = b.meta.Name, obj.meta.Name, obj.meta.Generation
+			obj.meta.Acl = append(obj.meta.Acl, &r)
+		}
+	}
+	b.put(obj)
+	return nil
+}
+
+// findObject returns the object addressed by a request, after checking the
+// request's conditions against it.
+func (s *server) findObject(bucketName, name string, conds *conditions) (*bucket, *object, error) {
+	b, err := s.bucket(bucketName)
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, err := b.lookup(name, conds.gen)
+	if err != nil {
+		if conds.genMatch != nil && *conds.genMatch != 0 {
+			return nil, nil, errConditionNotMet
+		}
+		return nil, nil, err
+	}
+	if err := conds.checkDest(obj); err != nil {
+		return nil, nil, err
+	}
+	return b, obj, nil
+}
+
+func (s *server) serveObject(w http.ResponseWriter, r *http.Request, bucketName, name string) (interface{}, error) {
+	conds, err := parseConditions(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	b, obj, err := s.findObject(bucketName, name, conds)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Method {
+	case "GET":
+		if r.URL.Query().Get("alt") == "media" {
+			return nil, serveContents(w, r, obj)
+		}
+		return &obj.meta, nil
+	case "PATCH", "PUT":
+		// Updates are treated as patches.
+		var patch map[string]json.RawMessage
+		if err := decodeBody(r, &patch); err != nil {
+			return nil, err
+		}
+		if err := mergePatch(&obj.meta, patch); err != nil {
+			return nil, badRequest("%v", err)
+		}
+		obj.meta.Metageneration++
+		obj.meta.Updated = formatTime(time.Now())
+		return &obj.meta, nil
+	case "DELETE":
+		b.remove(obj)
+		return nil, nil
+	}
+	return nil, errorf(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method)
+}
+
+func (s *server) serveObjectACL(r *http.Request, bucketName, name string, rest []string) (interface{}, error) {
+	conds, err := parseConditions(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	_, obj, err := s.findObject(bucketName, name, conds)
+	if err != nil {
+		return nil, err
+	}
+	a := &objectACL{bucket: bucketName, object: name, rules: &obj.meta.Acl}
+	return serveACL(r, a, rest)
+}
+
+func (s *server) listObjects(r *http.Request, bucketName string) (interface{}, error) {
+	b, err := s.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	q := r.URL.Query()
+	prefix, delim, token := q.Get("prefix"), q.Get("delimiter"), q.Get("pageToken")
+	versions := q.Get("versions") == "true"
+	max, err := maxResults(q.Get("maxResults"))
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := q.Get("startOffset"), q.Get("endOffset")
+	listed := func(name string) bool {
+		return strings.HasPrefix(name, prefix) && name >= start && (end == "" || name < end)
+	}
+
+	var objs []*object
+	for name, obj := range b.objects {
+		if listed(name) {
+			objs = append(objs, obj)
+		}
+	}
+	if versions {
+		for name, archived := range b.archived {
+			if listed(name) {
+				objs = append(objs, archived...)
+			}
+		}
+	}
+	sort.Sort(byNameAndGeneration(objs))
+
+	// With a delimiter, objects whose names contain it after the prefix are
+	// replaced by a single entry for their common prefix. Page tokens are
+	// the key of the last entry returned.
+	type entry struct {
+		key    string
+		obj    *object
+		prefix string
+	}
+	var entries []entry
+	seen := make(map[string]bool)
+	for _, obj := range objs {
+		name := obj.meta.Name
+		if delim != "" {
+			if i := strings.Index(name[len(prefix):], delim); i >= 0 {
+				p := name[:len(prefix)+i+len(delim)]
+				if !seen[p] {
+					seen[p] = true
+					entries = append(entries, entry{key: pageKey(p, 0), prefix: p})
+				}
+				continue
+			}
+		}
+		entries = append(entries, entry{key: pageKey(name, obj.meta.Generation), obj: obj})
+	}
+
+	resp := &raw.Objects{Kind: "storage#objects"}
+	n := 0
+	var last string
+	for _, e := range entries {
+		if token != "" && e.key <= token {
+			continue
+		}
+		if n == max {
+			resp.NextPageToken = last
+			break
+		}
+		if e.obj != nil {
+			resp.Items = append(resp.Items, &e.obj.meta)
+		} else {
+			resp.Prefixes = append(resp.Prefixes, e.prefix)
+		}
+		last = e.key
+		n++
+	}
+	return resp, nil
+}
+
+// pageKey returns a string which sorts in the order of name, then
+// generation.
+func pageKey(name string, gen int64) string {
+	return fmt.Sprintf("%s\x00%020d", name, gen)
+}
+
+type byNameAndGeneration []*object
+
+func (b byNameAndGeneration) Len() int      { return len(b) }
+func (b byNameAndGeneration) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byNameAndGeneration) Less(i, j int) bool {
+	if b[i].meta.Name != b[j].meta.Name {
+		return b[i].meta.Name < b[j].meta.Name
+	}
+	return b[i].meta.Generation < b[j].meta.Generation
+}
+
+func (s *server) compose(r *http.Request, bucketName, name string) (interface{}, error) {
+	b, err := s.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	conds, err := parseConditions(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	var req raw.ComposeRequest
+	if err := decodeBody(r, &req); err != nil {
+		return nil, err
+	}
+	if len(req.SourceObjects) == 0 {
+		return nil, badRequest("At least one source object must be specified.")
+	}
+	if len(req.SourceObjects) > 32 {
+		return nil, badRequest("The number of source components provided (%d) exceeds the maximum (32)", len(req.SourceObjects))
+	}
+	var data []byte
+	for _, src := range req.SourceObjects {
+		gen := int64(-1)
+		if src.Generation != 0 {
+			gen = src.Generation
+		}
+		obj, err := b.lookup(src.Name, gen)
+		if err != nil {
+			return nil, err
+		}
+		if pc := src.ObjectPreconditions; pc != nil && pc.IfGenerationMatch != obj.meta.Generation {
+			return nil, errConditionNotMet
+		}
+		data = append(data, obj.data...)
+	}
+	meta := &raw.Object{}
+	if req.Destination != nil {
+		*meta = *req.Destination
+	}
+	meta.Name = name
+	meta.ComponentCount = int64(len(req.SourceObjects))
+	// Hashes supplied for the destination are not checked.
+	meta.Md5Hash, meta.Crc32c = "", ""
+	obj, err := s.newObject(bucketName, meta, data)
+	if err != nil {
+		return nil, err
+	}
+	// Composite objects have no MD5 hash.
+	obj.meta.Md5Hash = ""
+	if err := s.insert(b, obj, conds); err != nil {
+		return nil, err
+	}
+	return &obj.meta, nil
+}
+
+// rewrite copies an object, possibly over several calls. The rewrite token
+// records how many bytes have been copied, and the source generation.
+func (s *server) rewrite(r *http.Request, srcBucket, srcName, dstBucket, dstName string) (interface{}, error) {
+	q := r.URL.Query()
+	conds, err := parseConditions(q)
+	if err != nil {
+		return nil, err
+	}
+	var meta *raw.Object
+	if r.ContentLength != 0 {
+		meta = &raw.Object{}
+		if err := decodeBody(r, meta); err != nil {
+			return nil, err
+		}
+	}
+	srcGen := conds.srcGen
+	var done int64
+	if tok := q.Get("rewriteToken"); tok != "" {
+		if _, err := fmt.Sscanf(tok, "%d:%d", &done, &srcGen); err != nil {
+			return nil, badRequest("invalid rewrite token %q", tok)
+		}
+	}
+	src, err := s.source(srcBucket, srcName, srcGen, conds)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(src.data))
+	if v := q.Get("maxBytesRewrittenPerCall"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, badRequest("invalid maxBytesRewrittenPerCall %q", v)
+		}
+		if done+n < size {
+			done += n
+			return &raw.RewriteResponse{
+				Kind:                "storage#rewriteResponse",
+				TotalBytesRewritten: uint64(done),
+				ObjectSize:          uint64(size),
+				RewriteToken:        fmt.Sprintf("%d:%d", done, src.meta.Generation),
+			}, nil
+		}
+	}
+	obj, err := s.copyObject(r, src, meta, dstBucket, dstName, conds)
+	if err != nil {
+		return nil, err
+	}
+	return &raw.RewriteResponse{
+		Kind:                "storage#rewriteResponse",
+		TotalBytesRewritten: uint64(size),
+		ObjectSize:          uint64(size),
+		Done:                true,
+		Resource:            &obj.meta,
+	}, nil
+}
+
+func (s *server) copy(r *http.Request, srcBucket, srcName, dstBucket, dstName string) (interface{}, error) {
+	conds, err := parseConditions(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	var meta *raw.Object
+	if r.ContentLength != 0 {
+		meta = &raw.Object{}
+		if err := decodeBody(r, meta); err != nil {
+			return nil, err
+		}
+	}
+	src, err := s.source(srcBucket, srcName, conds.srcGen, conds)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.copyObject(r, src, meta, dstBucket, dstName, conds)
+	if err != nil {
+		return nil, err
+	}
+	return &obj.meta, nil
+}
+
+// source returns the source object of a copy or rewrite.
+func (s *server) source(bucketName, name string, gen int64, conds *conditions) (*object, error) {
+	b, err := s.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	src, err := b.lookup(name, gen)
+	if err != nil {
+		return nil, err
+	}
+	if err := conds.checkSource(src); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// copyObject copies src to a new object for the request r. If meta is nil,
+// the metadata of src is copied too.
+func (s *server) copyObject(r *http.Request, src *object, meta *raw.Object, dstBucket, dstName string, conds *conditions) (*object, error) {
+	b, err := s.bucket(dstBucket)
+	if err != nil {
+		return nil, err
+	}
+	key, err := kmsKeyName(r, b, r.URL.Query().Get("destinationKmsKeyName"))
+	if err != nil {
+		return nil, err
+	}
+	m := &raw.Object{}
+	if meta != nil {
+		*m = *meta
+	} else {
+		m.ContentType = src.meta.ContentType
+		m.ContentEncoding = src.meta.ContentEncoding
+		m.ContentLanguage = src.meta.ContentLanguage
+		m.ContentDisposition = src.meta.ContentDisposition
+		m.CacheControl = src.meta.CacheControl
+		m.Metadata = src.meta.Metadata
+	}
+	m.Name = dstName
+	m.Md5Hash, m.Crc32c = "", ""
+	m.KmsKeyName = key
+	obj, err := s.newObject(dstBucket, m, append([]byte(nil), src.data...))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.insert(b, obj, conds); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// kmsKeyName returns the name of the Cloud KMS key that encrypts an object
+// written to b by r: key, the one that r names, if any, or else the default
+// key of b. An object written with a customer-supplied key has none. Like the
+// service, the fake rejects names with a key version, and reports the version
+// it used, which is always the first.
+func kmsKeyName(r *http.Request, b *bucket, key string) (string, error) {
+	if strings.Contains(key, "/cryptoKeyVersions/") {
+		return "", badRequest("KMS key name %q must not name a key version.", key)
+	}
+	if r.Header.Get("X-Goog-Encryption-Key") != "" {
+		if key != "" {
+			return "", badRequest("A customer-supplied encryption key cannot be used with a KMS key.")
+		}
+		return "", nil
+	}
+	if key == "" && b.meta.Encryption != nil {
+		key = b.meta.Encryption.DefaultKmsKeyName
+	}
+	if key == "" {
+		return "", nil
+	}
+	return key + "/cryptoKeyVersions/1", nil
+}
+
+// serveRead serves a read of object contents from the XML API, whose paths
+// have the form /bucket/object.
+func (s *server) serveRead(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return errorf(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method)
+	}
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	i := strings.Index(p, "/")
+	if i < 0 {
+		return notFound("no object in path %q", r.URL.Path)
+	}
+	if err := s.checkUserProject(r, p[:i]); err != nil {
+		return err
+	}
+	conds, err := parseConditions(r.URL.Query())
+	if err != nil {
+		return err
+	}
+	_, obj, err := s.findObject(p[:i], p[i+1:], conds)
+	if err != nil {
+		return err
+	}
+	return serveContents(w, r, obj)
+}
+
+// serveContents writes the contents of obj, or the range of them requested
+// by r, with headers like those of the XML API.
+func serveContents(w http.ResponseWriter, r *http.Request, obj *object) error {
+	data := obj.data
+	size := int64(len(data))
+	h := w.Header()
+	h.Set("Content-Type", obj.meta.ContentType)
+	h.Set("X-Goog-Generation", strconv.FormatInt(obj.meta.Generation, 10))
+	h.Set("X-Goog-Metageneration", strconv.FormatInt(obj.meta.Metageneration, 10))
+	h.Set("X-Goog-Stored-Content-Length", strconv.FormatInt(size, 10))
+	h.Add("X-Goog-Hash", "crc32c="+obj.meta.Crc32c)
+	if obj.meta.Md5Hash != "" {
+		h.Add("X-Goog-Hash", "md5="+obj.meta.Md5Hash)
+	}
+
+	status := http.StatusOK
+	if rg := r.Header.Get("Range"); rg != "" {
+		start, end, err := parseRange(rg, size)
+		if err != nil {
+			return err
+		}
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, size))
+		data = data[start:end]
+		status = http.StatusPartialContent
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method != "HEAD" {
+		w.Write(data)
+	}
+	return nil
+}
+
+// parseRange parses a Range header of the form "bytes=first-[last]" or
+// "bytes=-suffix", returning the half-open interval of bytes to serve.
+func parseRange(rg string, size int64) (start, end int64, err error) {
+	invalid := errorf(http.StatusRequestedRangeNotSatisfiable, "invalid", "The requested range cannot be satisfied.")
+	spec := strings.TrimPrefix(rg, "bytes=")
+	i := strings.Index(spec, "-")
+	if spec == rg || i < 0 || strings.Contains(spec, ",") {
+		return 0, 0, badRequest("unsupported range %q", rg)
+	}
+	first, last := spec[:i], spec[i+1:]
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, 0, badRequest("unsupported range %q", rg)
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size, nil
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, badRequest("unsupported range %q", rg)
+	}
+	end = size
+	if last != "" {
+		l, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || l < start {
+			return 0, 0, badRequest("unsupported range %q", rg)
+		}
+		if l+1 < end {
+			end = l + 1
+		}
+	}
+	if start >= size {
+		return 0, 0, invalid
+	}
+	return start, end, nil
+}
+
+// readAll reads the body of r.
+func readAll(r *http.Request) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		return nil, badRequest("reading request body: %v", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,469 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package storagetest contains test helpers for working with the storage package.
+
+It provides an in-memory fake of Google Cloud Storage, which serves the JSON
+API, uploads and object reads over HTTP. To use a Server, create it, and then
+connect to it with the options it supplies:
+
+	srv := storagetest.NewServer()
+	defer srv.Close()
+	client, err := storage.NewClient(ctx, srv.ClientOptions()...)
+	...
+
+The fake supports buckets, objects and their generations and metagenerations,
+preconditions, simple, multipart and resumable uploads, range reads, compose,
+rewrite, ACLs, batches, requester-pays buckets and HMAC keys. It records the
+Cloud KMS keys of objects, but does not encrypt them. It accepts uploads to the
+XML API, and checks the signatures of XML requests signed with its HMAC keys,
+as those of storage.NewHMACClient are; other requests are unauthenticated. It
+is only a rough approximation of the real service.
+*/
+package storagetest // import "cloud.google.com/go/storage/storagetest"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+	raw "google.golang.org/api/storage/v1"
+)
+
+// Server is an in-memory Google Cloud Storage fake, listening on a local
+// address.
+type Server struct {
+	// URL is the base URL of the server, of the form http://ipaddr:port,
+	// with no trailing slash.
+	URL string
+
+	ts *httptest.Server
+	s  *server
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down.
+func NewServer() *Server {
+	s := newServer()
+	ts := httptest.NewServer(s)
+	return &Server{URL: ts.URL, ts: ts, s: s}
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.ts.Close()
+}
+
+// ClientOptions returns the options with which to create a storage.Client
+// that talks to the server.
+func (s *Server) ClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithEndpoint(s.URL + jsonPrefix),
+		storage.WithXMLEndpoint(s.URL),
+		option.WithHTTPClient(&http.Client{}),
+	}
+}
+
+const (
+	jsonPrefix   = "/storage/v1/"
+	uploadPrefix = "/upload/storage/v1/"
+)
+
+// server is the real implementation of the fake.
+// It is a separate and unexported type so the API won't be cluttered with
+// methods that are only relevant to the fake's implementation.
+type server struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket  // keyed by name
+	uploads  map[string]*upload  // resumable uploads, keyed by upload ID
+	hmacKeys map[string]*hmacKey // keyed by access ID
+	lastGen  int64               // the most recently assigned generation
+	lastID   int64               // the most recently assigned upload or HMAC key ID
+}
+
+func newServer() *server {
+	return &server{
+		buckets:  make(map[string]*bucket),
+		uploads:  make(map[string]*upload),
+		hmacKeys: make(map[string]*hmacKey),
+	}
+}
+
+// nextGen returns a new object generation. Like those of the real service,
+// generations increase over time.
+func (s *server) nextGen() int64 {
+	gen := time.Now().UnixNano() / 1000
+	if gen <= s.lastGen {
+		gen = s.lastGen + 1
+	}
+	s.lastGen = gen
+	return gen
+}
+
+// httpError is an error which is reported to the client with a status code,
+// in the format used by the JSON API.
+type httpError struct {
+	code    int
+	reason  string
+	message string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.code, e.reason, e.message)
+}
+
+func errorf(code int, reason, format string, args ...interface{}) error {
+	return &httpError{code: code, reason: reason, message: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return errorf(http.StatusNotFound, "notFound", format, args...)
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return errorf(http.StatusBadRequest, "invalid", format, args...)
+}
+
+var errConditionNotMet = errorf(http.StatusPreconditionFailed, "conditionNotMet", "Precondition Failed")
+
+func writeError(w http.ResponseWriter, err error) {
+	he, ok := err.(*httpError)
+	if !ok {
+		he = &httpError{code: http.StatusInternalServerError, reason: "backendError", message: err.Error()}
+	}
+	type errorItem struct {
+		Domain  string `json:"domain"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}
+	var body struct {
+		Error struct {
+			Code    int         `json:"code"`
+			Message string      `json:"message"`
+			Errors  []errorItem `json:"errors"`
+		} `json:"error"`
+	}
+	body.Error.Code = he.code
+	body.Error.Message = he.message
+	body.Error.Errors = []errorItem{{Domain: "global", Reason: he.reason, Message: he.message}}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(he.code)
+	json.NewEncoder(w).Encode(&body)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+// ServeHTTP routes requests to the JSON API, batches, uploads, and object
+// reads and writes through the XML API.
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == batchPath {
+		// The requests of a batch are served by calls to ServeHTTP.
+		s.serveBatch(w, r)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		resp interface{}
+		err  error
+	)
+	p := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(p, uploadPrefix):
+		resp, err = s.serveUpload(w, r, p[len(uploadPrefix):])
+	case strings.HasPrefix(p, jsonPrefix):
+		resp, err = s.serveJSON(w, r, p[len(jsonPrefix):])
+	default:
+		if err = s.checkSignature(r); err != nil {
+			break
+		}
+		if r.Method == "PUT" {
+			err = s.serveXMLUpload(w, r)
+		} else {
+			err = s.serveRead(w, r)
+		}
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if resp != nil {
+		writeJSON(w, resp)
+	}
+}
+
+// splitPath splits an escaped path into its unescaped segments.
+func splitPath(p string) ([]string, error) {
+	segs := strings.Split(strings.Trim(p, "/"), "/")
+	for i, seg := range segs {
+		// Unescape with url.Parse rather than url.QueryUnescape, which
+		// would turn '+' into a space.
+		u, err := url.Parse("/" + seg)
+		if err != nil {
+			return nil, badRequest("bad path segment %q", seg)
+		}
+		segs[i] = u.Path[1:]
+	}
+	return segs, nil
+}
+
+// serveJSON serves a request to the JSON API. It returns the value to be
+// written as the JSON response, if any.
+func (s *server) serveJSON(w http.ResponseWriter, r *http.Request, p string) (interface{}, error) {
+	segs, err := splitPath(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(segs) >= 3 && segs[0] == "projects" && segs[2] == "hmacKeys" {
+		return s.serveHMACKeys(r, segs[1], segs[3:])
+	}
+	if len(segs) == 0 || segs[0] != "b" {
+		return nil, notFound("no such API: %s", p)
+	}
+	segs = segs[1:]
+	if len(segs) == 0 {
+		return s.serveBuckets(r)
+	}
+	b := segs[0]
+	if err := s.checkUserProject(r, b); err != nil {
+		return nil, err
+	}
+	switch {
+	case len(segs) == 1:
+		return s.serveBucket(r, b)
+	case len(segs) == 2 && segs[1] == "lockRetentionPolicy":
+		return s.lockRetentionPolicy(r, b)
+	case segs[1] == "notificationConfigs":
+		return s.serveNotifications(r, b, segs[2:])
+	case segs[1] == "iam":
+		return s.serveIAM(r, b, segs[2:])
+	case segs[1] == "acl" || segs[1] == "defaultObjectAcl":
+		return s.serveBucketACL(r, b, segs[1] == "defaultObjectAcl", segs[2:])
+	case segs[1] == "o" && len(segs) == 2:
+		if r.Method == "POST" {
+			return s.serveUpload(w, r, p)
+		}
+		return s.listObjects(r, b)
+	case segs[1] == "o" && len(segs) == 3:
+		return s.serveObject(w, r, b, segs[2])
+	case segs[1] == "o" && len(segs) == 4 && segs[3] == "compose":
+		return s.compose(r, b, segs[2])
+	case segs[1] == "o" && segs[3] == "acl":
+		return s.serveObjectACL(r, b, segs[2], segs[4:])
+	case segs[1] == "o" && len(segs) == 8 && segs[4] == "b" && segs[6] == "o":
+		if err := s.checkUserProject(r, segs[5]); err != nil {
+			return nil, err
+		}
+		switch segs[3] {
+		case "rewriteTo":
+			return s.rewrite(r, b, segs[2], segs[5], segs[7])
+		case "copyTo":
+			return s.copy(r, b, segs[2], segs[5], segs[7])
+		}
+	}
+	return nil, notFound("no such API: %s", p)
+}
+
+// checkUserProject returns an error if the named bucket is a requester-pays
+// bucket, and r does not name a project to bill. The fake does not know who
+// owns buckets, so it requires a project even of their owners.
+func (s *server) checkUserProject(r *http.Request, bucketName string) error {
+	b, ok := s.buckets[bucketName]
+	if !ok || b.meta.Billing == nil || !b.meta.Billing.RequesterPays {
+		return nil
+	}
+	if r.URL.Query().Get("userProject") == "" {
+		return badRequest("Bucket is a requester pays bucket but no user project provided.")
+	}
+	return nil
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return badRequest("parsing request body: %v", err)
+	}
+	return nil
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to dst, which must be a
+// pointer to a struct that can be marshalled to and from JSON. Fields which
+// are null in the patch are cleared, and objects are merged recursively. The
+// struct is replaced, so it shares no pointers with its previous value.
+func mergePatch(dst interface{}, patch map[string]json.RawMessage) error {
+	var doc, p interface{}
+	if err := roundTrip(dst, &doc); err != nil {
+		return err
+	}
+	if err := roundTrip(patch, &p); err != nil {
+		return err
+	}
+	b, err := json.Marshal(mergeValue(doc, p))
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(dst).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	return json.Unmarshal(b, dst)
+}
+
+// roundTrip marshals v to JSON, and unmarshals it into dst, preserving
+// numbers exactly.
+func roundTrip(v interface{}, dst *interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(dst)
+}
+
+func mergeValue(doc, patch interface{}) interface{} {
+	pm, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	dm, ok := doc.(map[string]interface{})
+	if !ok {
+		dm = map[string]interface{}{}
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(dm, k)
+		} else {
+			dm[k] = mergeValue(dm[k], v)
+		}
+	}
+	return dm
+}
+
+// conditions holds the preconditions and generation of a request.
+type conditions struct {
+	gen                                 int64 // -1 if unset
+	genMatch, genNotMatch               *int64
+	metagenMatch, metagenNotMatch       *int64
+	srcGen                              int64 // -1 if unset
+	srcGenMatch, srcGenNotMatch         *int64
+	srcMetagenMatch, srcMetagenNotMatch *int64
+}
+
+func parseConditions(q url.Values) (*conditions, error) {
+	c := &conditions{gen: -1, srcGen: -1}
+	for _, f := range []struct {
+		name string
+		dst  **int64
+	}{
+		{"ifGenerationMatch", &c.genMatch},
+		{"ifGenerationNotMatch", &c.genNotMatch},
+		{"ifMetagenerationMatch", &c.metagenMatch},
+		{"ifMetagenerationNotMatch", &c.metagenNotMatch},
+		{"ifSourceGenerationMatch", &c.srcGenMatch},
+		{"ifSourceGenerationNotMatch", &c.srcGenNotMatch},
+		{"ifSourceMetagenerationMatch", &c.srcMetagenMatch},
+		{"ifSourceMetagenerationNotMatch", &c.srcMetagenNotMatch},
+	} {
+		v := q.Get(f.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, badRequest("invalid %s %q", f.name, v)
+		}
+		*f.dst = &n
+	}
+	for _, f := range []struct {
+		name string
+		dst  *int64
+	}{
+		{"generation", &c.gen},
+		{"sourceGeneration", &c.srcGen},
+	} {
+		v := q.Get(f.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, badRequest("invalid %s %q", f.name, v)
+		}
+		*f.dst = n
+	}
+	return c, nil
+}
+
+// check reports whether an object with the given generation and
+// metageneration satisfies the conditions. A generation of zero means that
+// the object does not exist.
+func check(gen, metagen int64, genMatch, genNotMatch, metagenMatch, metagenNotMatch *int64) error {
+	if genMatch != nil && *genMatch != gen {
+		return errConditionNotMet
+	}
+	if genNotMatch != nil && *genNotMatch == gen {
+		return errConditionNotMet
+	}
+	if gen == 0 {
+		if metagenMatch != nil {
+			return errConditionNotMet
+		}
+		return nil
+	}
+	if metagenMatch != nil && *metagenMatch != metagen {
+		return errConditionNotMet
+	}
+	if metagenNotMatch != nil && *metagenNotMatch == metagen {
+		return errConditionNotMet
+	}
+	return nil
+}
+
+// checkDest checks the destination conditions against obj, which may be nil.
+func (c *conditions) checkDest(obj *object) error {
+	var gen, metagen int64
+	if obj != nil {
+		gen, metagen = obj.meta.Generation, obj.meta.Metageneration
+	}
+	return check(gen, metagen, c.genMatch, c.genNotMatch, c.metagenMatch, c.metagenNotMatch)
+}
+
+// checkSource checks the source conditions against obj, which must exist.
+func (c *conditions) checkSource(obj *object) error {
+	return check(obj.meta.Generation, obj.meta.Metageneration, c.srcGenMatch, c.srcGenNotMatch, c.srcMetagenMatch, c.srcMetagenNotMatch)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// newBucketACL returns the default ACL of a new bucket.
+func newBucketACL(bucket string) []*raw.BucketAccessControl {
+	return []*raw.BucketAccessControl{
+		{Bucket: bucket, Entity: "project-owners-0", Role: "OWNER"},
+	}
+}
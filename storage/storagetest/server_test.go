@@ -0,0 +1,1600 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/iam"
+	"cloud.google.com/go/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+func newTestClient(t *testing.T) (*storage.Client, func()) {
+	srv := NewServer()
+	client, err := storage.NewClient(context.Background(), srv.ClientOptions()...)
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	return client, func() {
+		client.Close()
+		srv.Close()
+	}
+}
+
+func write(t *testing.T, obj *storage.ObjectHandle, chunkSize int, data []byte) (*storage.ObjectAttrs, error) {
+	w := obj.NewWriter(context.Background())
+	w.ChunkSize = chunkSize
+	w.ContentType = "text/plain"
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return w.Attrs(), nil
+}
+
+func read(t *testing.T, obj *storage.ObjectHandle, offset, length int64) []byte {
+	r, err := obj.NewRangeReader(context.Background(), offset, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func isStatus(err error, code int) bool {
+	e, ok := err.(*googleapi.Error)
+	return ok && e.Code == code
+}
+
+func TestBuckets(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	for _, name := range []string{"b1", "b2", "c1"} {
+		if err := client.Bucket(name).Create(ctx, "proj", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.Bucket("b1").Create(ctx, "proj", nil); !isStatus(err, http.StatusConflict) {
+		t.Errorf("creating existing bucket: got %v, want 409", err)
+	}
+	attrs, err := client.Bucket("b1").Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Name != "b1" || attrs.MetaGeneration != 1 || attrs.Location != "US" {
+		t.Errorf("got %+v", attrs)
+	}
+
+	it := client.Buckets(ctx, "proj")
+	it.Prefix = "b"
+	var got []string
+	for {
+		b, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, b.Name)
+	}
+	if want := []string{"b1", "b2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listed buckets %v, want %v", got, want)
+	}
+
+	if err := client.Bucket("b2").Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Bucket("b2").Attrs(ctx); err != storage.ErrBucketNotExist {
+		t.Errorf("deleted bucket: got %v, want %v", err, storage.ErrBucketNotExist)
+	}
+}
+
+func TestObjects(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	obj := bkt.Object("dir/obj+1")
+
+	// A small object is sent in one request; a large one is sent in chunks
+	// with a resumable upload.
+	small := []byte("hello, world")
+	large := bytes.Repeat([]byte("0123456789"), 60000)
+	for _, data := range [][]byte{small, large} {
+		attrs, err := write(t, obj, 256*1024, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Size != int64(len(data)) || attrs.ContentType != "text/plain" {
+			t.Errorf("got size %d, content type %q", attrs.Size, attrs.ContentType)
+		}
+		if got := read(t, obj, 0, -1); !bytes.Equal(got, data) {
+			t.Errorf("read %d bytes, want %d", len(got), len(data))
+		}
+	}
+	if got, want := read(t, obj, 5, 3), large[5:8]; !bytes.Equal(got, want) {
+		t.Errorf("range read: got %q, want %q", got, want)
+	}
+	if got, want := read(t, obj, 599990, -1), large[599990:]; !bytes.Equal(got, want) {
+		t.Errorf("range read to end: got %q, want %q", got, want)
+	}
+
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Preconditions.
+	if _, err := write(t, obj.If(storage.Conditions{DoesNotExist: true}), 0, small); !isStatus(err, http.StatusPreconditionFailed) {
+		t.Errorf("DoesNotExist on existing object: got %v, want 412", err)
+	}
+	if _, err := obj.If(storage.Conditions{MetagenerationMatch: 2}).Update(ctx, storage.ObjectAttrsToUpdate{ContentType: "text/html"}); !isStatus(err, http.StatusPreconditionFailed) {
+		t.Errorf("MetagenerationMatch: got %v, want 412", err)
+	}
+	updated, err := obj.If(storage.Conditions{GenerationMatch: attrs.Generation, MetagenerationMatch: 1}).
+		Update(ctx, storage.ObjectAttrsToUpdate{ContentType: "text/html", Metadata: map[string]string{"k": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.MetaGeneration != 2 || updated.ContentType != "text/html" || updated.Metadata["k"] != "v" {
+		t.Errorf("after update: got %+v", updated)
+	}
+
+	// Compose and copy.
+	if _, err := write(t, bkt.Object("a"), 0, []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	composed, err := bkt.Object("ab").ComposerFrom(bkt.Object("a"), bkt.Object("a")).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if composed.Size != 6 {
+		t.Errorf("composed size: got %d, want 6", composed.Size)
+	}
+	if _, err := bkt.Object("ab2").CopierFrom(bkt.Object("ab")).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(read(t, bkt.Object("ab2"), 0, -1)); got != "abcabc" {
+		t.Errorf("copy: got %q, want %q", got, "abcabc")
+	}
+
+	// Listing.
+	var names, prefixes []string
+	it := bkt.Objects(ctx, &storage.Query{Delimiter: "/"})
+	it.PageInfo().MaxSize = 1
+	for {
+		a, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Prefix != "" {
+			prefixes = append(prefixes, a.Prefix)
+		} else {
+			names = append(names, a.Name)
+		}
+	}
+	if want := []string{"a", "ab", "ab2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("listed objects %v, want %v", names, want)
+	}
+	if want := []string{"dir/"}; !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("listed prefixes %v, want %v", prefixes, want)
+	}
+
+	if err := obj.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := obj.Attrs(ctx); err != storage.ErrObjectNotExist {
+		t.Errorf("deleted object: got %v, want %v", err, storage.ErrObjectNotExist)
+	}
+	if _, err := obj.NewReader(ctx); err != storage.ErrObjectNotExist {
+		t.Errorf("reading deleted object: got %v, want %v", err, storage.ErrObjectNotExist)
+	}
+}
+
+func TestACL(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := bkt.DefaultObjectACL().Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
+		t.Fatal(err)
+	}
+	obj := bkt.Object("obj")
+	if _, err := write(t, obj, 0, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	want := []storage.ACLRule{{Entity: storage.AllUsers, Role: storage.RoleReader}}
+	rules, err := obj.ACL().List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("object ACL: got %v, want %v", rules, want)
+	}
+	if err := obj.ACL().Delete(ctx, storage.AllUsers); err != nil {
+		t.Fatal(err)
+	}
+	if rules, err = obj.ACL().List(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 0 {
+		t.Errorf("object ACL after delete: got %v, want none", rules)
+	}
+	if err := bkt.ACL().Set(ctx, storage.AllAuthenticatedUsers, storage.RoleReader); err != nil {
+		t.Fatal(err)
+	}
+	if rules, err = bkt.ACL().List(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Errorf("bucket ACL: got %v, want 2 rules", rules)
+	}
+}
+
+func TestResumableWriter(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	obj := bkt.Object("backup")
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	const chunk = 256 * 1024
+
+	// Write part of the object and abandon the Writer, as a crashed process
+	// would.
+	var (
+		uri    string
+		offset int64
+	)
+	w := obj.NewWriter(ctx)
+	w.Resumable = true
+	w.ChunkSize = chunk
+	w.Checkpoint = func(u string, off int64) { uri, offset = u, off }
+	if _, err := w.Write(data[:chunk+100]); err != nil {
+		t.Fatal(err)
+	}
+	if uri == "" || uri != w.SessionURI() {
+		t.Fatalf("checkpoint URI %q, SessionURI %q", uri, w.SessionURI())
+	}
+	if offset != chunk || w.Offset() != chunk {
+		t.Fatalf("checkpoint offset %d, Offset %d, want %d", offset, w.Offset(), chunk)
+	}
+	if _, err := obj.Attrs(ctx); err != storage.ErrObjectNotExist {
+		t.Fatalf("object exists before upload completed: %v", err)
+	}
+
+	w, err := obj.ResumeWriter(ctx, uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Offset() != chunk {
+		t.Fatalf("resumed at offset %d, want %d", w.Offset(), chunk)
+	}
+	w.ChunkSize = chunk
+	if _, err := w.Write(data[w.Offset():]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Attrs().Size; got != int64(len(data)) {
+		t.Errorf("got size %d, want %d", got, len(data))
+	}
+	if got := read(t, obj, 0, -1); !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes, want %d", len(got), len(data))
+	}
+
+	// The session of a finished upload reports the object.
+	if w, err = obj.ResumeWriter(ctx, uri); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Attrs().Size; w.Offset() != got || got != int64(len(data)) {
+		t.Errorf("finished upload: got size %d, offset %d, want %d", got, w.Offset(), len(data))
+	}
+
+	// Small objects are sent on Close.
+	w = obj.NewWriter(ctx)
+	w.Resumable = true
+	if _, err := w.Write([]byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	if w.SessionURI() != "" {
+		t.Errorf("session began before Close")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(read(t, obj, 0, -1)); got != "small" {
+		t.Errorf("got %q, want %q", got, "small")
+	}
+}
+
+// bufferAt is an io.WriterAt that grows as needed.
+type bufferAt struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *bufferAt) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if end := int(off) + len(p); end > len(b.buf) {
+		b.buf = append(b.buf, make([]byte, end-len(b.buf))...)
+	}
+	return copy(b.buf[off:], p), nil
+}
+
+func TestParallelTransfer(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	obj := bkt.Object("big")
+	// 70 parts need two tiers of composition.
+	data := bytes.Repeat([]byte("0123456789"), 7000)
+	u := obj.ParallelUploaderFrom(bytes.NewReader(data), int64(len(data)))
+	u.PartSize = 1000
+	u.ContentType = "text/plain"
+	attrs, err := u.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Size != int64(len(data)) || attrs.ContentType != "text/plain" {
+		t.Errorf("got size %d, content type %q", attrs.Size, attrs.ContentType)
+	}
+	if got := read(t, obj, 0, -1); !bytes.Equal(got, data) {
+		t.Errorf("read %d bytes, want %d", len(got), len(data))
+	}
+
+	// Only the destination remains.
+	var names []string
+	it := bkt.Objects(ctx, nil)
+	for {
+		a, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, a.Name)
+	}
+	if want := []string{"big"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("objects after upload: got %v, want %v", names, want)
+	}
+
+	var buf bufferAt
+	d := obj.ParallelDownloaderTo(&buf)
+	d.PartSize = 999
+	if _, err := d.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.buf, data) {
+		t.Errorf("downloaded %d bytes, want %d", len(buf.buf), len(data))
+	}
+}
+
+func TestWriterChecksums(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	obj := bkt.Object("obj")
+	data := []byte("hello, world")
+	sum := md5.Sum(data)
+	crc := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+
+	for _, test := range []struct {
+		desc  string
+		setup func(w *storage.Writer)
+		ok    bool
+	}{
+		{"MD5", func(w *storage.Writer) { w.MD5 = sum[:] }, true},
+		{"bad MD5", func(w *storage.Writer) { w.MD5 = []byte("0123456789abcdef") }, false},
+		{"CRC32C", func(w *storage.Writer) { w.CRC32C, w.SendCRC32C = crc, true }, true},
+		{"bad CRC32C", func(w *storage.Writer) { w.CRC32C, w.SendCRC32C = crc+1, true }, false},
+		{"unsent CRC32C", func(w *storage.Writer) { w.CRC32C = crc + 1 }, true},
+		{"computed", func(w *storage.Writer) { w.ComputeChecksums = true }, true},
+		{"computed resumable", func(w *storage.Writer) { w.ComputeChecksums, w.Resumable = true, true }, true},
+	} {
+		w := obj.NewWriter(ctx)
+		test.setup(w)
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("%s: %v", test.desc, err)
+		}
+		err := w.Close()
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.desc, err)
+		}
+		if !test.ok && !isStatus(err, http.StatusBadRequest) {
+			t.Errorf("%s: got %v, want 400", test.desc, err)
+		}
+	}
+
+	// Computed hashes don't make the Writer hold the whole object: full
+	// chunks are sent as they are written.
+	w := obj.NewWriter(ctx)
+	w.ComputeChecksums = true
+	w.ChunkSize = 256 << 10
+	var offset int64
+	w.Checkpoint = func(_ string, off int64) { offset = off }
+	big := bytes.Repeat([]byte("x"), 3*w.ChunkSize/2)
+	if _, err := w.Write(big); err != nil {
+		t.Fatal(err)
+	}
+	if offset != int64(w.ChunkSize) {
+		t.Errorf("before Close: %d bytes persisted, want %d", offset, w.ChunkSize)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Attrs().Size; got != int64(len(big)) {
+		t.Errorf("got size %d, want %d", got, len(big))
+	}
+}
+
+// corruptingTransport changes the first "hello" in the body of each request
+// to "jello", as a faulty network might. Since the length of the body is
+// unchanged, so is its framing: chunked requests keep their trailers.
+type corruptingTransport struct{}
+
+func (corruptingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.Replace(body, []byte("hello"), []byte("jello"), 1)
+		r2 := *req
+		r2.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req = &r2
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestWriterComputedChecksumsRejectCorruption(t *testing.T) {
+	ctx := context.Background()
+	srv := NewServer()
+	defer srv.Close()
+	client, err := storage.NewClient(ctx, srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := write(t, bkt.Object("obj"), 0, []byte("original")); err != nil {
+		t.Fatal(err)
+	}
+	key, err := client.CreateHMACKey(ctx, "proj", "robot@proj.iam.gserviceaccount.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := append(srv.ClientOptions(), option.WithHTTPClient(&http.Client{Transport: corruptingTransport{}}))
+	bad, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bad.Close()
+	badHMAC, err := storage.NewHMACClient(ctx, key.AccessID, key.Secret, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer badHMAC.Close()
+	for _, test := range []struct {
+		desc      string
+		client    *storage.Client
+		resumable bool
+	}{
+		{"simple", bad, false},
+		{"resumable", bad, true},
+		{"XML", badHMAC, false},
+	} {
+		w := test.client.Bucket("bucket").Object("obj").NewWriter(ctx)
+		w.ComputeChecksums = true
+		w.Resumable = test.resumable
+		if _, err := w.Write([]byte("hello, world")); err != nil {
+			t.Fatalf("%s: %v", test.desc, err)
+		}
+		if err := w.Close(); !isStatus(err, http.StatusBadRequest) {
+			t.Errorf("%s: got %v, want 400", test.desc, err)
+		}
+		// The service rejected the upload, so the object is unchanged.
+		if got := read(t, bkt.Object("obj"), 0, -1); string(got) != "original" {
+			t.Errorf("%s: got contents %q, want %q", test.desc, got, "original")
+		}
+	}
+}
+
+func TestBucketUpdate(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	lifecycle := storage.Lifecycle{Rules: []storage.LifecycleRule{{
+		Action:    storage.LifecycleAction{Type: storage.SetStorageClassAction, StorageClass: "NEARLINE"},
+		Condition: storage.LifecycleCondition{AgeInDays: 30, Liveness: storage.Live},
+	}}}
+	err := bkt.Create(ctx, "proj", &storage.BucketAttrs{
+		Labels:    map[string]string{"a": "1", "b": "2"},
+		Lifecycle: lifecycle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := bkt.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attrs.Lifecycle, lifecycle) || len(attrs.Labels) != 2 {
+		t.Errorf("created with lifecycle %+v, labels %v", attrs.Lifecycle, attrs.Labels)
+	}
+
+	ua := storage.BucketAttrsToUpdate{
+		VersioningEnabled: true,
+		CORS:              []storage.CORS{{MaxAge: time.Hour, Methods: []string{"GET"}, Origins: []string{"*"}}},
+		Lifecycle:         &storage.Lifecycle{},
+		Website:           &storage.BucketWebsite{MainPageSuffix: "index.html"},
+		Logging:           &storage.BucketLogging{LogBucket: "logs"},
+		RetentionPolicy:   &storage.RetentionPolicy{RetentionPeriod: time.Hour},
+	}
+	ua.SetLabel("c", "3")
+	ua.DeleteLabel("a")
+	if _, err := bkt.If(storage.BucketConditions{MetagenerationMatch: 2}).Update(ctx, ua); !isStatus(err, http.StatusPreconditionFailed) {
+		t.Errorf("MetagenerationMatch: got %v, want 412", err)
+	}
+	attrs, err = bkt.If(storage.BucketConditions{MetagenerationMatch: 1}).Update(ctx, ua)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"b": "2", "c": "3"}; !reflect.DeepEqual(attrs.Labels, want) {
+		t.Errorf("labels: got %v, want %v", attrs.Labels, want)
+	}
+	if !attrs.VersioningEnabled || len(attrs.Lifecycle.Rules) != 0 || attrs.MetaGeneration != 2 {
+		t.Errorf("got versioning %t, lifecycle %+v, metageneration %d", attrs.VersioningEnabled, attrs.Lifecycle, attrs.MetaGeneration)
+	}
+	if !reflect.DeepEqual(attrs.CORS, ua.CORS) || *attrs.Website != *ua.Website || *attrs.Logging != *ua.Logging {
+		t.Errorf("got CORS %+v, website %+v, logging %+v", attrs.CORS, attrs.Website, attrs.Logging)
+	}
+	rp := attrs.RetentionPolicy
+	if rp == nil || rp.RetentionPeriod != time.Hour || rp.EffectiveTime.IsZero() || rp.IsLocked {
+		t.Errorf("retention policy: got %+v", rp)
+	}
+
+	// Removing configuration.
+	attrs, err = bkt.Update(ctx, storage.BucketAttrsToUpdate{
+		VersioningEnabled: false,
+		CORS:              []storage.CORS{},
+		Website:           &storage.BucketWebsite{},
+		Logging:           &storage.BucketLogging{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.VersioningEnabled || attrs.CORS != nil || attrs.Website != nil || attrs.Logging != nil {
+		t.Errorf("after removal: got %+v", attrs)
+	}
+
+	// A locked retention policy can't be removed.
+	if err := bkt.LockRetentionPolicy(ctx); err == nil {
+		t.Error("locking without a condition: got nil, want error")
+	}
+	if err := bkt.If(storage.BucketConditions{MetagenerationMatch: attrs.MetaGeneration}).LockRetentionPolicy(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, err = bkt.Update(ctx, storage.BucketAttrsToUpdate{RetentionPolicy: &storage.RetentionPolicy{}})
+	if !isStatus(err, http.StatusForbidden) {
+		t.Errorf("removing locked policy: got %v, want 403", err)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	n, err := bkt.AddNotification(ctx, &storage.Notification{
+		TopicProjectID:   "proj",
+		TopicID:          "topic",
+		EventTypes:       []string{storage.ObjectFinalizeEvent},
+		ObjectNamePrefix: "logs/",
+		PayloadFormat:    storage.JSONPayload,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ID == "" || n.TopicProjectID != "proj" || n.TopicID != "topic" || n.ObjectNamePrefix != "logs/" {
+		t.Errorf("got %+v", n)
+	}
+	ns, err := bkt.Notifications(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]*storage.Notification{n.ID: n}; !reflect.DeepEqual(ns, want) {
+		t.Errorf("got %v, want %v", ns, want)
+	}
+	if err := bkt.DeleteNotification(ctx, n.ID); err != nil {
+		t.Fatal(err)
+	}
+	if ns, err = bkt.Notifications(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 0 {
+		t.Errorf("after delete: got %v", ns)
+	}
+	if err := bkt.DeleteNotification(ctx, n.ID); !isStatus(err, http.StatusNotFound) {
+		t.Errorf("deleting again: got %v, want 404", err)
+	}
+}
+
+func TestBucketIAM(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	h := bkt.IAM()
+	p, err := h.Policy(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roles := p.Roles(); len(roles) != 0 {
+		t.Errorf("new bucket: got roles %v, want none", roles)
+	}
+	stale, err := h.Policy(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const member = "user:alice@example.com"
+	p.Add(member, iam.Viewer)
+	if err := h.SetPolicy(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if p, err = h.Policy(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !p.HasRole(member, iam.Viewer) {
+		t.Errorf("got members %v for %s, want %s", p.Members(iam.Viewer), iam.Viewer, member)
+	}
+	// A policy read before the change must not overwrite it.
+	stale.Add(member, iam.Owner)
+	if err := h.SetPolicy(ctx, stale); !isStatus(err, http.StatusPreconditionFailed) {
+		t.Errorf("setting stale policy: got %v, want 412", err)
+	}
+
+	perms := []string{"storage.buckets.get", "storage.objects.list"}
+	got, err := h.TestPermissions(ctx, perms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, perms) {
+		t.Errorf("TestPermissions: got %v, want %v", got, perms)
+	}
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	src, err := ioutil.TempDir("", "storagetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	put := func(obj *storage.ObjectHandle, contents string) {
+		if _, err := write(t, obj, 0, []byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(obj *storage.ObjectHandle) string {
+		return string(read(t, obj, 0, -1))
+	}
+	writeFile := func(dir, name, contents string) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(src, "a.txt", "alpha")
+	writeFile(src, "dir/b.txt", "bravo")
+	writeFile(src, "tmp/scratch", "ignored")
+	put(bkt.Object("out/dir/b.txt"), "BRAVO") // same size, different contents
+	put(bkt.Object("out/stale"), "stale")
+
+	s := bkt.Syncer(src, "out/")
+	s.Delete = true
+	s.Exclude = []string{"tmp"}
+	s.DryRun = true
+	want := []storage.SyncAction{
+		{Op: storage.SyncUpload, Path: "a.txt", Size: 5},
+		{Op: storage.SyncUpload, Path: "dir/b.txt", Size: 5},
+		{Op: storage.SyncDeleteObject, Path: "stale"},
+	}
+	got, err := s.Upload(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dry run: got %+v, want %+v", got, want)
+	}
+	if got := get(bkt.Object("out/dir/b.txt")); got != "BRAVO" {
+		t.Errorf("dry run changed object: got %q", got)
+	}
+
+	s.DryRun = false
+	if got, err = s.Upload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("upload: got %+v, want %+v", got, want)
+	}
+	if got := get(bkt.Object("out/dir/b.txt")); got != "bravo" {
+		t.Errorf("after upload: got %q, want %q", got, "bravo")
+	}
+	if _, err := bkt.Object("out/stale").Attrs(ctx); err != storage.ErrObjectNotExist {
+		t.Errorf("stale object: got %v, want ErrObjectNotExist", err)
+	}
+	if _, err := bkt.Object("out/tmp/scratch").Attrs(ctx); err != storage.ErrObjectNotExist {
+		t.Errorf("excluded file: got %v, want ErrObjectNotExist", err)
+	}
+	if got, err = s.Upload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("second upload: got %+v, want no actions", got)
+	}
+
+	// Download into a new directory, then again after a change.
+	dst, err := ioutil.TempDir("", "storagetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	writeFile(dst, "extra", "x")
+	d := bkt.Syncer(filepath.Join(dst, "mirror"), "out/")
+	if got, err = d.Download(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want = []storage.SyncAction{
+		{Op: storage.SyncDownload, Path: "a.txt", Size: 5},
+		{Op: storage.SyncDownload, Path: "dir/b.txt", Size: 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("download: got %+v, want %+v", got, want)
+	}
+	put(bkt.Object("out/a.txt"), "ALPHA")
+	writeFile(filepath.Join(dst, "mirror"), "local-only", "x")
+	d.Delete = true
+	if got, err = d.Download(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want = []storage.SyncAction{
+		{Op: storage.SyncDownload, Path: "a.txt", Size: 5},
+		{Op: storage.SyncDeleteFile, Path: "local-only"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("second download: got %+v, want %+v", got, want)
+	}
+	for name, want := range map[string]string{"a.txt": "ALPHA", "dir/b.txt": "bravo"} {
+		b, err := ioutil.ReadFile(filepath.Join(dst, "mirror", filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("%s: got %q, want %q", name, b, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "mirror", "local-only")); !os.IsNotExist(err) {
+		t.Errorf("local-only file: got %v, want not exist", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := write(t, bkt.Object(name), 0, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch := client.Batch()
+	del := batch.Delete(bkt.Object("a"))
+	missing := batch.Delete(bkt.Object("missing"))
+	upd := batch.Update(bkt.Object("b"), storage.ObjectAttrsToUpdate{ContentType: "text/csv"})
+	acl := batch.SetACL(bkt.Object("c"), storage.AllUsers, storage.RoleReader)
+	invalid := batch.Delete(bkt.Object(""))
+	if err := del.Err(); err == nil {
+		t.Error("before Run: got nil error, want one")
+	}
+	if err := batch.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := del.Err(); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+	if _, err := bkt.Object("a").Attrs(ctx); err != storage.ErrObjectNotExist {
+		t.Errorf("deleted object: got %v, want ErrObjectNotExist", err)
+	}
+	if err := missing.Err(); err != storage.ErrObjectNotExist {
+		t.Errorf("delete missing: got %v, want ErrObjectNotExist", err)
+	}
+	if err := upd.Err(); err != nil {
+		t.Errorf("update: %v", err)
+	} else if got := upd.Attrs().ContentType; got != "text/csv" {
+		t.Errorf("update: got ContentType %q, want text/csv", got)
+	}
+	if err := acl.Err(); err != nil {
+		t.Errorf("set ACL: %v", err)
+	}
+	rules, err := bkt.Object("c").ACL().List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, r := range rules {
+		if r.Entity == storage.AllUsers && r.Role == storage.RoleReader {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("set ACL: got rules %v", rules)
+	}
+	if err := invalid.Err(); err == nil {
+		t.Error("invalid object name: got nil error, want one")
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	// More objects than fit in one batch.
+	const n = 150
+	for i := 0; i < n; i++ {
+		if _, err := write(t, bkt.Object(fmt.Sprintf("logs/%03d", i)), 0, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := write(t, bkt.Object("keep"), 0, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := bkt.DeletePrefix(ctx, "logs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != n {
+		t.Errorf("got %d deleted, want %d", deleted, n)
+	}
+	it := bkt.Objects(ctx, nil)
+	var names []string
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, attrs.Name)
+	}
+	if want := []string{"keep"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("remaining objects: got %v, want %v", names, want)
+	}
+}
+
+func TestListFiltering(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.parquet", "b", "c/d.parquet", "c/e.csv", "c/f/g.parquet", "h"} {
+		if _, err := write(t, bkt.Object(name), 0, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func(q *storage.Query) []string {
+		it := bkt.Objects(ctx, q)
+		var names []string
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				return names
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, attrs.Name)
+		}
+	}
+	for _, test := range []struct {
+		q    *storage.Query
+		want []string
+	}{
+		{&storage.Query{StartOffset: "b", EndOffset: "c/f"}, []string{"b", "c/d.parquet", "c/e.csv"}},
+		{&storage.Query{StartOffset: "c/f"}, []string{"c/f/g.parquet", "h"}},
+		{&storage.Query{MatchGlob: "**/*.parquet"}, []string{"a.parquet", "c/d.parquet", "c/f/g.parquet"}},
+		{&storage.Query{MatchGlob: "c/*"}, []string{"c/d.parquet", "c/e.csv"}},
+		{&storage.Query{Prefix: "c/", MatchGlob: "**.parquet"}, []string{"c/d.parquet", "c/f/g.parquet"}},
+	} {
+		if got := list(test.q); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v: got %v, want %v", test.q, got, test.want)
+		}
+	}
+
+	if _, err := bkt.Objects(ctx, &storage.Query{MatchGlob: "[a"}).Next(); err == nil {
+		t.Error("bad glob: got nil error, want one")
+	}
+}
+
+func TestFileSystem(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	fsys := bkt.FileSystem(ctx)
+	for _, name := range []string{"a.txt", "dir/b.txt", "dir/sub/c.txt"} {
+		w, err := fsys.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("contents of " + name)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Objects whose names are not valid paths are not files.
+	for _, name := range []string{"dir/", "bad//name"} {
+		if _, err := write(t, bkt.Object(name), 0, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{".", "../x", "dir/"} {
+		if _, err := fsys.Create(name); err == nil {
+			t.Errorf("Create(%q): got nil error, want one", name)
+		}
+	}
+
+	readDir := func(name string) []string {
+		infos, err := fsys.ReadDir(name)
+		if err != nil {
+			t.Fatalf("ReadDir(%q): %v", name, err)
+		}
+		var names []string
+		for _, info := range infos {
+			n := info.Name()
+			if info.IsDir() {
+				n += "/"
+			}
+			names = append(names, n)
+		}
+		return names
+	}
+	if got, want := readDir("."), []string{"a.txt", "bad/", "dir/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDir(.): got %v, want %v", got, want)
+	}
+	if got, want := readDir("dir"), []string{"b.txt", "sub/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDir(dir): got %v, want %v", got, want)
+	}
+	if got := readDir("bad"); len(got) != 0 {
+		t.Errorf("ReadDir(bad): got %v, want none", got)
+	}
+	for _, name := range []string{"missing", "a.txt", "/dir", "dir/"} {
+		if _, err := fsys.ReadDir(name); err == nil {
+			t.Errorf("ReadDir(%q): got nil error, want one", name)
+		}
+	}
+
+	info, err := fsys.Stat("dir/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() || info.Name() != "sub" {
+		t.Errorf("Stat(dir/sub): got %s, dir %t", info.Name(), info.IsDir())
+	}
+	info, err = fsys.Stat("dir/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.IsDir() || info.Name() != "b.txt" || info.Size() != int64(len("contents of dir/b.txt")) {
+		t.Errorf("Stat(dir/b.txt): got %s, dir %t, size %d", info.Name(), info.IsDir(), info.Size())
+	}
+	if attrs, ok := info.Sys().(*storage.ObjectAttrs); !ok || attrs.Name != "dir/b.txt" {
+		t.Errorf("Stat(dir/b.txt).Sys(): got %v", info.Sys())
+	}
+	if _, err := fsys.Stat("dir/missing"); !os.IsNotExist(err) {
+		t.Errorf("Stat(dir/missing): got %v, want not exist", err)
+	}
+
+	// The contents of dir/sub/c.txt are "contents of dir/sub/c.txt".
+	f, err := fsys.Open("dir/sub/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(f, buf); err != nil || string(buf) != "contents" {
+		t.Errorf("Read: got %q, %v", buf, err)
+	}
+	if off, err := f.Seek(-5, os.SEEK_END); err != nil || off != 20 {
+		t.Errorf("Seek: got %d, %v", off, err)
+	}
+	if b, err := ioutil.ReadAll(f); err != nil || string(b) != "c.txt" {
+		t.Errorf("Read after Seek: got %q, %v", b, err)
+	}
+	if n, err := f.ReadAt(buf, 9); err != nil || string(buf[:n]) != "of dir/s" {
+		t.Errorf("ReadAt: got %q, %v", buf[:n], err)
+	}
+	if n, err := f.ReadAt(buf, 20); err != io.EOF || string(buf[:n]) != "c.txt" {
+		t.Errorf("ReadAt near end: got %q, %v", buf[:n], err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(buf); err == nil {
+		t.Error("Read after Close: got nil error, want one")
+	}
+
+	d, err := fsys.Open("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err := d.Read(buf); err == nil {
+		t.Error("Read of directory: got nil error, want one")
+	}
+	infos, err := d.Readdir(1)
+	if err != nil || len(infos) != 1 || infos[0].Name() != "b.txt" {
+		t.Errorf("Readdir(1): got %v, %v", infos, err)
+	}
+	infos, err = d.Readdir(-1)
+	if err != nil || len(infos) != 1 || infos[0].Name() != "sub" {
+		t.Errorf("Readdir(-1): got %v, %v", infos, err)
+	}
+	if _, err := d.Readdir(1); err != io.EOF {
+		t.Errorf("Readdir at end: got %v, want io.EOF", err)
+	}
+}
+
+func TestKMS(t *testing.T) {
+	const (
+		key1 = "projects/p/locations/us/keyRings/r/cryptoKeys/k1"
+		key2 = "projects/p/locations/us/keyRings/r/cryptoKeys/k2"
+		key3 = "projects/p/locations/us/keyRings/r/cryptoKeys/k3"
+
+		// The service reports the key version it used.
+		v1 = "/cryptoKeyVersions/1"
+	)
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	err := bkt.Create(ctx, "proj", &storage.BucketAttrs{
+		Encryption: &storage.BucketEncryption{DefaultKMSKeyName: key1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	battrs, err := bkt.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if battrs.Encryption == nil || battrs.Encryption.DefaultKMSKeyName != key1 {
+		t.Errorf("got bucket encryption %+v, want default key %q", battrs.Encryption, key1)
+	}
+
+	// Objects are encrypted with the bucket's default key, unless the Writer
+	// names another.
+	attrs, err := write(t, bkt.Object("a"), 0, []byte("aaa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.KMSKeyName != key1+v1 {
+		t.Errorf("a: got KMS key %q, want %q", attrs.KMSKeyName, key1+v1)
+	}
+	for _, resumable := range []bool{false, true} {
+		w := bkt.Object("b").NewWriter(ctx)
+		w.KMSKeyName = key2
+		w.Resumable = resumable
+		if _, err := w.Write([]byte("bbb")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Attrs().KMSKeyName; got != key2+v1 {
+			t.Errorf("b, resumable %t: got KMS key %q, want %q", resumable, got, key2+v1)
+		}
+	}
+	w := bkt.Object("c").Key(make([]byte, 32)).NewWriter(ctx)
+	w.KMSKeyName = key2
+	if _, err := w.Write([]byte("ccc")); err == nil {
+		t.Error("KMS key with customer-supplied key: got nil error, want one")
+	}
+
+	// Rotate every object to a new key by rewriting it in place.
+	it := bkt.Objects(ctx, nil)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := bkt.Object(attrs.Name)
+		c := obj.If(storage.Conditions{GenerationMatch: attrs.Generation}).CopierFrom(obj)
+		c.DestinationKMSKeyName = key3
+		got, err := c.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.KMSKeyName != key3+v1 {
+			t.Errorf("rotated %s: got KMS key %q, want %q", attrs.Name, got.KMSKeyName, key3+v1)
+		}
+		if got.Generation == attrs.Generation || got.ContentType != attrs.ContentType {
+			t.Errorf("rotated %s: got generation %d, content type %q", attrs.Name, got.Generation, got.ContentType)
+		}
+	}
+	if got := read(t, bkt.Object("b"), 0, -1); string(got) != "bbb" {
+		t.Errorf("b after rotation: got %q", got)
+	}
+
+	// Attributes read from an object encrypted with a KMS key, which name
+	// its key version, can be reused to copy or write another object under
+	// the same key.
+	battrs2, err := bkt.Object("b").Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := bkt.Object("b2").CopierFrom(bkt.Object("b"))
+	c.ObjectAttrs = *battrs2
+	if got, err := c.Run(ctx); err != nil || got.KMSKeyName != key3+v1 {
+		t.Errorf("copy with source attributes: got %+v, %v", got, err)
+	}
+	w = bkt.Object("b3").NewWriter(ctx)
+	w.ObjectAttrs = *battrs2
+	w.Name = "b3"
+	if _, err := w.Write([]byte("bbb")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Attrs().KMSKeyName; got != key3+v1 {
+		t.Errorf("write with attributes of b: got KMS key %q, want %q", got, key3+v1)
+	}
+
+	// Objects with a customer-supplied key can be rewritten to a KMS key,
+	// but not encrypted with both.
+	csek := bkt.Object("d").Key(make([]byte, 32))
+	if _, err := write(t, csek, 0, []byte("ddd")); err != nil {
+		t.Fatal(err)
+	}
+	c = bkt.Object("d").CopierFrom(csek)
+	c.DestinationKMSKeyName = key2
+	if attrs, err := c.Run(ctx); err != nil || attrs.KMSKeyName != key2+v1 {
+		t.Errorf("rewrite from customer-supplied key: got %+v, %v", attrs, err)
+	}
+	c = csek.CopierFrom(bkt.Object("d"))
+	c.DestinationKMSKeyName = key2
+	if _, err := c.Run(ctx); err == nil {
+		t.Error("Copier with both keys: got nil error, want one")
+	}
+
+	battrs, err = bkt.Update(ctx, storage.BucketAttrsToUpdate{Encryption: &storage.BucketEncryption{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if battrs.Encryption != nil {
+		t.Errorf("got bucket encryption %+v, want none", battrs.Encryption)
+	}
+	if attrs, err := write(t, bkt.Object("e"), 0, []byte("eee")); err != nil || attrs.KMSKeyName != "" {
+		t.Errorf("write without default key: got %+v, %v", attrs, err)
+	}
+}
+
+func TestCopierResume(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("bucket")
+	if err := bkt.Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	const mib = 1 << 20
+	data := bytes.Repeat([]byte("0123456789"), 5*mib/20)
+	size := uint64(len(data))
+	src := bkt.Object("src")
+	if _, err := write(t, src, 0, data); err != nil {
+		t.Fatal(err)
+	}
+
+	type progress struct {
+		copied, total uint64
+		token         string
+	}
+	var (
+		got   []progress
+		after func()
+	)
+	newCopier := func(token string) *storage.Copier {
+		c := bkt.Object("dst").CopierFrom(src)
+		c.RewriteToken = token
+		c.MaxBytesRewrittenPerCall = mib
+		c.ProgressFunc = func(copied, total uint64) {
+			got = append(got, progress{copied, total, c.RewriteToken})
+			if after != nil {
+				after()
+			}
+		}
+		return c
+	}
+
+	// Stop the copy after its first call, as if the process had crashed.
+	cctx, cancel := context.WithCancel(ctx)
+	after = cancel
+	c := newCopier("")
+	if _, err := c.Run(cctx); err == nil {
+		t.Fatal("Run with canceled context: got nil error, want one")
+	}
+	if len(got) != 1 || got[0].copied != mib || got[0].total != size || got[0].token == "" {
+		t.Fatalf("first call: got progress %+v", got)
+	}
+	if c.RewriteToken != got[0].token {
+		t.Errorf("after error: got RewriteToken %q, want %q", c.RewriteToken, got[0].token)
+	}
+
+	// Resume it with the saved token.
+	saved := got[0].token
+	got, after = nil, nil
+	c = newCopier(saved)
+	attrs, err := c.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].copied != 2*mib || got[0].token == "" || got[1].copied != size || got[1].token != "" {
+		t.Errorf("resumed copy: got progress %+v", got)
+	}
+	if c.RewriteToken != "" {
+		t.Errorf("after success: got RewriteToken %q, want empty", c.RewriteToken)
+	}
+	if attrs.Size != int64(size) || !bytes.Equal(read(t, bkt.Object("dst"), 0, -1), data) {
+		t.Error("resumed copy: contents differ")
+	}
+}
+
+func TestRequesterPays(t *testing.T) {
+	ctx := context.Background()
+	client, done := newTestClient(t)
+	defer done()
+
+	bkt := client.Bucket("shared")
+	if err := bkt.Create(ctx, "owner", &storage.BucketAttrs{RequesterPays: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Bucket("mine").Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+	paid := bkt.UserProject("proj")
+	attrs, err := paid.Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !attrs.RequesterPays {
+		t.Error("got RequesterPays false, want true")
+	}
+
+	// Every kind of request made through paid names the project.
+	obj := paid.Object("obj")
+	if _, err := write(t, obj, 0, []byte("contents")); err != nil {
+		t.Fatal(err)
+	}
+	w := paid.Object("big").NewWriter(ctx)
+	w.Resumable = true
+	if _, err := w.Write([]byte("resumable contents")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, obj, 0, -1); string(got) != "contents" {
+		t.Errorf("got contents %q", got)
+	}
+	if _, err := obj.Update(ctx, storage.ObjectAttrsToUpdate{ContentType: "text/csv"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := obj.ACL().List(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := paid.DefaultObjectACL().List(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := paid.IAM().Policy(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := paid.Notifications(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := paid.Object("both").ComposerFrom(obj, paid.Object("big")).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// A copy out of the bucket is billed to the project of its source.
+	if _, err := client.Bucket("mine").Object("copy").CopierFrom(obj).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	it := paid.Objects(ctx, nil)
+	var names []string
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, attrs.Name)
+	}
+	if want := []string{"big", "both", "obj"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got objects %v, want %v", names, want)
+	}
+	batch := client.Batch()
+	del := batch.Delete(paid.Object("both"))
+	if err := batch.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := del.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests without a project fail.
+	if _, err := bkt.Attrs(ctx); !isStatus(err, http.StatusBadRequest) {
+		t.Errorf("Attrs: got %v, want status 400", err)
+	}
+	if _, err := bkt.Object("obj").NewReader(ctx); !isStatus(err, http.StatusBadRequest) {
+		t.Errorf("NewReader: got %v, want status 400", err)
+	}
+	if _, err := write(t, bkt.Object("obj2"), 0, []byte("x")); !isStatus(err, http.StatusBadRequest) {
+		t.Errorf("write: got %v, want status 400", err)
+	}
+	if _, err := bkt.Objects(ctx, nil).Next(); !isStatus(err, http.StatusBadRequest) {
+		t.Errorf("Objects: got %v, want status 400", err)
+	}
+
+	if _, err := paid.Update(ctx, storage.BucketAttrsToUpdate{RequesterPays: false}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bkt.Object("obj").Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	ctx := context.Background()
+	srv := NewServer()
+	defer srv.Close()
+	client, err := storage.NewClient(ctx, srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Bucket("bucket").Create(ctx, "proj", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	const email = "robot@proj.iam.gserviceaccount.com"
+	key, err := client.CreateHMACKey(ctx, "proj", email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Secret == "" || key.AccessID == "" || key.State != storage.Active || key.ServiceAccountEmail != email {
+		t.Fatalf("got key %+v", key)
+	}
+	other, err := client.CreateHMACKey(ctx, "proj", "other@proj.iam.gserviceaccount.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := client.HMACKeyHandle("proj", key.AccessID)
+	got, err := h.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Secret != "" || got.AccessID != key.AccessID || got.Etag != key.Etag {
+		t.Errorf("Get: got %+v, want the metadata of %+v", got, key)
+	}
+	listKeys := func(it *storage.HMACKeyIterator) []string {
+		var ids []string
+		for {
+			k, err := it.Next()
+			if err == iterator.Done {
+				return ids
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, k.AccessID)
+		}
+	}
+	if got, want := listKeys(client.HMACKeys(ctx, "proj")), []string{key.AccessID, other.AccessID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("HMACKeys: got %q, want %q", got, want)
+	}
+	it := client.HMACKeys(ctx, "proj")
+	it.ServiceAccountEmail = email
+	if got, want := listKeys(it), []string{key.AccessID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("HMACKeys of %s: got %q, want %q", email, got, want)
+	}
+
+	// Requests to the XML API signed with the key are accepted.
+	hc, err := storage.NewHMACClient(ctx, key.AccessID, key.Secret, srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hc.Close()
+	obj := hc.Bucket("bucket").Object("dir/a file+name é")
+	w := obj.NewWriter(ctx)
+	w.ContentType = "text/plain"
+	w.Metadata = map[string]string{"color": "blue"}
+	w.CRC32C = crc32.Checksum([]byte("signed contents"), crc32.MakeTable(crc32.Castagnoli))
+	w.SendCRC32C = true
+	if _, err := w.Write([]byte("signed contents")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if a := w.Attrs(); a.Size != 15 || a.Generation == 0 || a.CRC32C != w.CRC32C {
+		t.Errorf("got attrs %+v", a)
+	}
+	if got := read(t, obj, 0, -1); string(got) != "signed contents" {
+		t.Errorf("got contents %q", got)
+	}
+	attrs, err := client.Bucket("bucket").Object("dir/a file+name é").Attrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != "text/plain" || attrs.Metadata["color"] != "blue" {
+		t.Errorf("got attrs %+v", attrs)
+	}
+
+	// Requests signed with a wrong secret or an inactive key are refused.
+	bad, err := storage.NewHMACClient(ctx, key.AccessID, other.Secret, srv.ClientOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bad.Close()
+	if _, err := bad.Bucket("bucket").Object("dir/a file+name é").NewReader(ctx); !isStatus(err, http.StatusForbidden) {
+		t.Errorf("NewReader with wrong secret: got %v, want status 403", err)
+	}
+	if _, err := h.Update(ctx, storage.HMACKeyAttrsToUpdate{State: storage.Inactive, Etag: "stale"}); !isStatus(err, http.StatusPreconditionFailed) {
+		t.Errorf("Update with stale etag: got %v, want status 412", err)
+	}
+	if err := h.Delete(ctx); !isStatus(err, http.StatusBadRequest) {
+		t.Errorf("Delete of active key: got %v, want status 400", err)
+	}
+	got, err = h.Update(ctx, storage.HMACKeyAttrsToUpdate{State: storage.Inactive, Etag: key.Etag})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != storage.Inactive || got.Etag == key.Etag {
+		t.Errorf("Update: got %+v", got)
+	}
+	if _, err := write(t, obj, 0, []byte("x")); !isStatus(err, http.StatusForbidden) {
+		t.Errorf("write with inactive key: got %v, want status 403", err)
+	}
+
+	if err := h.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listKeys(client.HMACKeys(ctx, "proj")), []string{other.AccessID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("HMACKeys after Delete: got %q, want %q", got, want)
+	}
+	it = client.HMACKeys(ctx, "proj")
+	it.ShowDeletedKeys = true
+	if got, want := listKeys(it), []string{key.AccessID, other.AccessID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("HMACKeys with deleted keys: got %q, want %q", got, want)
+	}
+}
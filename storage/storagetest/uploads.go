@@ -0,0 +1,364 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	raw "google.golang.org/api/storage/v1"
+)
+
+// upload is a resumable upload in progress.
+type upload struct {
+	bucket string
+	meta   raw.Object
+	conds  *conditions
+	data   []byte
+	done   *raw.Object // the object, once the upload is complete
+}
+
+// statusResumeIncomplete is the status with which the service acknowledges
+// a chunk of a resumable upload which is not the last.
+const statusResumeIncomplete = 308
+
+// serveUpload serves an object upload. p is the path of the request relative
+// to the API root, which should be "b/<bucket>/o".
+func (s *server) serveUpload(w http.ResponseWriter, r *http.Request, p string) (interface{}, error) {
+	segs, err := splitPath(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(segs) != 3 || segs[0] != "b" || segs[2] != "o" {
+		return nil, notFound("no such upload API: %s", p)
+	}
+	bucketName := segs[1]
+	q := r.URL.Query()
+	if id := q.Get("upload_id"); id != "" {
+		return s.continueUpload(w, r, id)
+	}
+	if r.Method != "POST" {
+		return nil, errorf(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method)
+	}
+	if err := s.checkUserProject(r, bucketName); err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	conds, err := parseConditions(q)
+	if err != nil {
+		return nil, err
+	}
+	key, err := kmsKeyName(r, b, q.Get("kmsKeyName"))
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		meta raw.Object
+		data []byte
+	)
+	switch q.Get("uploadType") {
+	case "media":
+		if data, err = readAll(r); err != nil {
+			return nil, err
+		}
+		meta.ContentType = r.Header.Get("Content-Type")
+	case "multipart":
+		if data, err = readMultipart(r, &meta); err != nil {
+			return nil, err
+		}
+	case "resumable":
+		return s.startUpload(w, r, bucketName, key, conds)
+	default:
+		return nil, badRequest("unsupported uploadType %q", q.Get("uploadType"))
+	}
+	if name := q.Get("name"); name != "" {
+		meta.Name = name
+	}
+	if meta.Name == "" {
+		return nil, badRequest("Required: object name")
+	}
+	meta.KmsKeyName = key
+	obj, err := s.newObject(bucketName, &meta, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.insert(b, obj, conds); err != nil {
+		return nil, err
+	}
+	return &obj.meta, nil
+}
+
+// readMultipart reads a multipart/related upload, whose first part holds the
+// object's metadata in JSON, and second its contents.
+func readMultipart(r *http.Request, meta *raw.Object) ([]byte, error) {
+	mt, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mt, "multipart/") {
+		return nil, badRequest("bad multipart Content-Type %q", r.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		return nil, badRequest("reading metadata part: %v", err)
+	}
+	if err := json.NewDecoder(part).Decode(meta); err != nil {
+		return nil, badRequest("parsing metadata: %v", err)
+	}
+	if part, err = mr.NextPart(); err != nil {
+		return nil, badRequest("reading media part: %v", err)
+	}
+	data, err := ioutil.ReadAll(part)
+	if err != nil {
+		return nil, badRequest("reading media part: %v", err)
+	}
+	if meta.ContentType == "" {
+		meta.ContentType = part.Header.Get("Content-Type")
+	}
+	return data, nil
+}
+
+// startUpload begins a resumable upload of an object encrypted with the KMS
+// key kmsKey, if any, and returns its URL in the Location header.
+func (s *server) startUpload(w http.ResponseWriter, r *http.Request, bucketName, kmsKey string, conds *conditions) (interface{}, error) {
+	u := &upload{bucket: bucketName, conds: conds}
+	if r.ContentLength != 0 {
+		if err := decodeBody(r, &u.meta); err != nil {
+			return nil, err
+		}
+	}
+	if name := r.URL.Query().Get("name"); name != "" {
+		u.meta.Name = name
+	}
+	if u.meta.Name == "" {
+		return nil, badRequest("Required: object name")
+	}
+	if u.meta.ContentType == "" {
+		u.meta.ContentType = r.Header.Get("X-Upload-Content-Type")
+	}
+	u.meta.KmsKeyName = kmsKey
+	s.lastID++
+	id := strconv.FormatInt(s.lastID, 10)
+	s.uploads[id] = u
+
+	loc := &url.URL{
+		Scheme:   "http",
+		Host:     r.Host,
+		Path:     uploadPrefix + "b/" + bucketName + "/o",
+		RawQuery: url.Values{"uploadType": {"resumable"}, "upload_id": {id}}.Encode(),
+	}
+	w.Header().Set("Location", loc.String())
+	w.WriteHeader(http.StatusOK)
+	return nil, nil
+}
+
+// continueUpload accepts a chunk of a resumable upload. If it is the last, the
+// object is created.
+func (s *server) continueUpload(w http.ResponseWriter, r *http.Request, id string) (interface{}, error) {
+	u, ok := s.uploads[id]
+	if !ok {
+		return nil, notFound("No such upload: %s", id)
+	}
+	if r.Method == "DELETE" {
+		delete(s.uploads, id)
+		return nil, errorf(499, "canceled", "upload canceled")
+	}
+	if r.Method != "PUT" && r.Method != "POST" {
+		return nil, errorf(http.StatusMethodNotAllowed, "methodNotAllowed", "method %s not allowed", r.Method)
+	}
+	if u.done != nil {
+		// Like the service, report the object to requests made after
+		// the upload has completed.
+		return u.done, nil
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+	start, total, err := parseContentRange(r.Header.Get("Content-Range"), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	if start >= 0 {
+		have := int64(len(u.data))
+		if start > have {
+			return nil, badRequest("chunk starts at %d, but only %d bytes have been received", start, have)
+		}
+		// Discard any part of the chunk that was already received.
+		if skip := have - start; skip < int64(len(data)) {
+			u.data = append(u.data, data[skip:]...)
+		}
+	}
+	if total < 0 || int64(len(u.data)) < total {
+		if len(u.data) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(u.data)-1))
+		}
+		w.WriteHeader(statusResumeIncomplete)
+		return nil, nil
+	}
+	if int64(len(u.data)) > total {
+		return nil, badRequest("received %d bytes, but the upload size is %d", len(u.data), total)
+	}
+	b, err := s.bucket(u.bucket)
+	if err != nil {
+		return nil, err
+	}
+	// Hashes may accompany the last chunk, and are checked by newObject.
+	parseHashes(r.Header, &u.meta)
+	obj, err := s.newObject(u.bucket, &u.meta, u.data)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.insert(b, obj, u.conds); err != nil {
+		delete(s.uploads, id)
+		return nil, err
+	}
+	meta := obj.meta
+	u.done, u.data = &meta, nil
+	return &obj.meta, nil
+}
+
+// parseHashes sets the hashes of meta from any X-Goog-Hash headers in h.
+func parseHashes(h http.Header, meta *raw.Object) {
+	for _, v := range h["X-Goog-Hash"] {
+		for _, kv := range strings.Split(v, ",") {
+			kv = strings.TrimSpace(kv)
+			switch {
+			case strings.HasPrefix(kv, "crc32c="):
+				meta.Crc32c = kv[len("crc32c="):]
+			case strings.HasPrefix(kv, "md5="):
+				meta.Md5Hash = kv[len("md5="):]
+			}
+		}
+	}
+}
+
+// serveXMLUpload serves an upload of an object with a single PUT request to
+// the XML API, whose path has the form /bucket/object. The object's metadata
+// is taken from the request's headers.
+func (s *server) serveXMLUpload(w http.ResponseWriter, r *http.Request) error {
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	i := strings.Index(p, "/")
+	if i < 0 || i == len(p)-1 {
+		return notFound("no object in path %q", r.URL.Path)
+	}
+	bucketName := p[:i]
+	if err := s.checkUserProject(r, bucketName); err != nil {
+		return err
+	}
+	b, err := s.bucket(bucketName)
+	if err != nil {
+		return err
+	}
+	conds, err := parseConditions(r.URL.Query())
+	if err != nil {
+		return err
+	}
+	key, err := kmsKeyName(r, b, r.Header.Get("X-Goog-Encryption-Kms-Key-Name"))
+	if err != nil {
+		return err
+	}
+	data, err := readAll(r)
+	if err != nil {
+		return err
+	}
+
+	h := r.Header
+	meta := raw.Object{
+		Name:               p[i+1:],
+		ContentType:        h.Get("Content-Type"),
+		ContentEncoding:    h.Get("Content-Encoding"),
+		ContentLanguage:    h.Get("Content-Language"),
+		ContentDisposition: h.Get("Content-Disposition"),
+		CacheControl:       h.Get("Cache-Control"),
+		KmsKeyName:         key,
+	}
+	for k, v := range h {
+		// Like the service, the fake lowercases the keys of metadata
+		// sent as headers.
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-goog-meta-") && len(v) > 0 {
+			if meta.Metadata == nil {
+				meta.Metadata = make(map[string]string)
+			}
+			meta.Metadata[lk[len("x-goog-meta-"):]] = v[0]
+		}
+	}
+	meta.Md5Hash = h.Get("Content-Md5")
+	parseHashes(h, &meta)
+	// Hashes may also follow the body, in the trailer of a chunked request.
+	parseHashes(r.Trailer, &meta)
+	obj, err := s.newObject(bucketName, &meta, data)
+	if err != nil {
+		return err
+	}
+	if err := s.insert(b, obj, conds); err != nil {
+		return err
+	}
+
+	rh := w.Header()
+	rh.Set("ETag", fmt.Sprintf("%q", obj.meta.Etag))
+	rh.Set("X-Goog-Generation", strconv.FormatInt(obj.meta.Generation, 10))
+	rh.Set("X-Goog-Metageneration", strconv.FormatInt(obj.meta.Metageneration, 10))
+	rh.Add("X-Goog-Hash", "crc32c="+obj.meta.Crc32c)
+	rh.Add("X-Goog-Hash", "md5="+obj.meta.Md5Hash)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// parseContentRange parses the Content-Range header of a chunk of a
+// resumable upload, of the form "bytes first-last/total" or "bytes */total",
+// where total may be "*" if it is not yet known. It returns the offset of the
+// chunk, or -1 if it is empty, and the total size, or -1 if it is unknown. If
+// the header is absent, the chunk is the whole object.
+func parseContentRange(cr string, n int64) (start, total int64, err error) {
+	if cr == "" {
+		return 0, n, nil
+	}
+	bad := badRequest("bad Content-Range %q", cr)
+	spec := strings.TrimPrefix(cr, "bytes ")
+	i := strings.Index(spec, "/")
+	if spec == cr || i < 0 {
+		return 0, 0, bad
+	}
+	rng, size := spec[:i], spec[i+1:]
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, bad
+		}
+	}
+	if rng == "*" {
+		return -1, total, nil
+	}
+	j := strings.Index(rng, "-")
+	if j < 0 {
+		return 0, 0, bad
+	}
+	first, err1 := strconv.ParseInt(rng[:j], 10, 64)
+	last, err2 := strconv.ParseInt(rng[j+1:], 10, 64)
+	if err1 != nil || err2 != nil || last-first+1 != n {
+		return 0, 0, bad
+	}
+	return first, total, nil
+}
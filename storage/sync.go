@@ -0,0 +1,383 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+// Syncer creates a Syncer that mirrors the local directory dir and the
+// objects in b whose names begin with prefix. The name of each object is
+// prefix followed by the slash-separated path of its file relative to dir, so
+// prefix usually ends in "/". You can immediately call Upload or Download on
+// the returned Syncer, or you can configure it first.
+func (b *BucketHandle) Syncer(dir, prefix string) *Syncer {
+	return &Syncer{b: b, dir: dir, prefix: prefix}
+}
+
+// A Syncer makes a local directory tree and a set of objects the same, in
+// either direction, by copying only the files that are missing or differ.
+//
+// A file and an object are the same if they have the same size and CRC32C
+// checksum. Files of the same size as their objects are read in full to
+// compute their checksums.
+//
+// Only regular files are synchronized; symbolic links and other special files
+// are ignored, as are objects whose names end in "/".
+type Syncer struct {
+	// Delete determines whether files or objects in the destination that
+	// are absent from the source are deleted.
+	Delete bool
+
+	// DryRun determines whether Upload and Download only report the actions
+	// they would take, without copying or deleting anything.
+	DryRun bool
+
+	// Exclude holds patterns, in the syntax of path.Match, for paths
+	// relative to the directory or prefix that are neither copied nor
+	// deleted. A pattern that matches a directory, such as "tmp" or
+	// "build/*", excludes everything beneath it.
+	Exclude []string
+
+	// Parallelism is the number of files that are copied, deleted or
+	// compared at once. If zero, it defaults to 4.
+	Parallelism int
+
+	b      *BucketHandle
+	dir    string
+	prefix string
+}
+
+// A SyncOp is an action taken by a Syncer.
+type SyncOp int
+
+const (
+	// SyncUpload writes a file to an object.
+	SyncUpload SyncOp = iota
+
+	// SyncDownload writes an object to a file.
+	SyncDownload
+
+	// SyncDeleteObject deletes an object which has no file.
+	SyncDeleteObject
+
+	// SyncDeleteFile deletes a file which has no object.
+	SyncDeleteFile
+)
+
+func (op SyncOp) String() string {
+	switch op {
+	case SyncUpload:
+		return "upload"
+	case SyncDownload:
+		return "download"
+	case SyncDeleteObject:
+		return "delete object"
+	case SyncDeleteFile:
+		return "delete file"
+	}
+	return fmt.Sprintf("SyncOp(%d)", int(op))
+}
+
+// A SyncAction describes a file or object that a Syncer copies or deletes.
+type SyncAction struct {
+	Op SyncOp
+
+	// Path is the slash-separated path of the file relative to the
+	// directory, and of the object relative to the prefix.
+	Path string
+
+	// Size is the number of bytes copied, or zero for deletions.
+	Size int64
+}
+
+// syncEntry is a file or object, keyed by its relative path.
+type syncEntry struct {
+	size int64
+	crc  uint32 // objects only
+}
+
+// Upload makes the objects under the prefix the same as the files in the
+// directory. It returns the actions it took, in order of path, or would take
+// if DryRun is set. If an action fails, Upload returns the error and the
+// plan; some of its actions may have been taken.
+func (s *Syncer) Upload(ctx context.Context) ([]SyncAction, error) {
+	return s.run(ctx, true)
+}
+
+// Download makes the files in the directory the same as the objects under
+// the prefix, creating the directory and its subdirectories as needed. It
+// returns the actions it took, in order of path, or would take if DryRun is
+// set. If an action fails, Download returns the error and the plan; some of
+// its actions may have been taken.
+func (s *Syncer) Download(ctx context.Context) ([]SyncAction, error) {
+	return s.run(ctx, false)
+}
+
+func (s *Syncer) run(ctx context.Context, upload bool) ([]SyncAction, error) {
+	for _, p := range s.Exclude {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("storage: bad Exclude pattern %q: %v", p, err)
+		}
+	}
+	parallelism := s.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultParallelism
+	}
+	files, err := s.listFiles(upload)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := s.listObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	src, dst := files, objects
+	copyOp, deleteOp := SyncUpload, SyncDeleteObject
+	if !upload {
+		src, dst = objects, files
+		copyOp, deleteOp = SyncDownload, SyncDeleteFile
+		for p := range objects {
+			if !isLocalPath(p) {
+				return nil, fmt.Errorf("storage: object %q cannot be written within the directory", s.prefix+p)
+			}
+		}
+	}
+
+	var plan, same []SyncAction
+	for p, e := range src {
+		d, ok := dst[p]
+		switch {
+		case !ok || d.size != e.size:
+			plan = append(plan, SyncAction{Op: copyOp, Path: p, Size: e.size})
+		default:
+			same = append(same, SyncAction{Op: copyOp, Path: p, Size: e.size})
+		}
+	}
+	if s.Delete {
+		for p := range dst {
+			if _, ok := src[p]; !ok {
+				plan = append(plan, SyncAction{Op: deleteOp, Path: p})
+			}
+		}
+	}
+	// Files and objects of the same size are copied only if their contents
+	// differ.
+	differ := make([]bool, len(same))
+	err = forEach(ctx, len(same), parallelism, func(ctx context.Context, i int) error {
+		p := same[i].Path
+		eq, err := s.fileMatches(p, objects[p])
+		differ[i] = !eq
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range same {
+		if differ[i] {
+			plan = append(plan, a)
+		}
+	}
+	sort.Sort(byPath(plan))
+	if s.DryRun {
+		return plan, nil
+	}
+	err = forEach(ctx, len(plan), parallelism, func(ctx context.Context, i int) error {
+		return s.do(ctx, plan[i])
+	})
+	return plan, err
+}
+
+type byPath []SyncAction
+
+func (a byPath) Len() int           { return len(a) }
+func (a byPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPath) Less(i, j int) bool { return a[i].Path < a[j].Path }
+
+// excluded reports whether p, or a directory containing it, matches an
+// Exclude pattern.
+func (s *Syncer) excluded(p string) bool {
+	for {
+		for _, pat := range s.Exclude {
+			if ok, _ := path.Match(pat, p); ok {
+				return true
+			}
+		}
+		i := strings.LastIndex(p, "/")
+		if i < 0 {
+			return false
+		}
+		p = p[:i]
+	}
+}
+
+// listFiles returns the regular files in the directory that are not
+// excluded. A missing directory is empty, unless it is the source.
+func (s *Syncer) listFiles(mustExist bool) (map[string]*syncEntry, error) {
+	files := map[string]*syncEntry{}
+	if _, err := os.Stat(s.dir); os.IsNotExist(err) && !mustExist {
+		return files, nil
+	}
+	err := filepath.Walk(s.dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, name)
+		if err != nil {
+			return err
+		}
+		p := filepath.ToSlash(rel)
+		if info.IsDir() {
+			if p != "." && s.excluded(p) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && !s.excluded(p) {
+			files[p] = &syncEntry{size: info.Size()}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// listObjects returns the objects under the prefix that are not excluded.
+func (s *Syncer) listObjects(ctx context.Context) (map[string]*syncEntry, error) {
+	objects := map[string]*syncEntry{}
+	it := s.b.Objects(ctx, &Query{Prefix: s.prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		p := strings.TrimPrefix(attrs.Name, s.prefix)
+		if p == "" || strings.HasSuffix(p, "/") || s.excluded(p) {
+			continue
+		}
+		objects[p] = &syncEntry{size: attrs.Size, crc: attrs.CRC32C}
+	}
+	return objects, nil
+}
+
+// fileMatches reports whether the contents of the file with path p match the
+// checksum of obj.
+func (s *Syncer) fileMatches(p string, obj *syncEntry) (bool, error) {
+	f, err := os.Open(s.localPath(p))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h := crc32.New(crc32cTable)
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	return h.Sum32() == obj.crc, nil
+}
+
+// isLocalPath reports whether the slash-separated path p names a file within
+// a directory, and has no redundant elements.
+func isLocalPath(p string) bool {
+	return p == path.Clean(p) && !path.IsAbs(p) && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+func (s *Syncer) localPath(p string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(p))
+}
+
+// do takes a single action.
+func (s *Syncer) do(ctx context.Context, a SyncAction) error {
+	obj := s.b.Object(s.prefix + a.Path)
+	switch a.Op {
+	case SyncUpload:
+		return s.upload(ctx, obj, a.Path)
+	case SyncDownload:
+		return s.download(ctx, obj, a.Path)
+	case SyncDeleteObject:
+		err := obj.Delete(ctx)
+		if err == ErrObjectNotExist {
+			err = nil
+		}
+		return err
+	case SyncDeleteFile:
+		err := os.Remove(s.localPath(a.Path))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+	return fmt.Errorf("storage: unknown SyncOp %v", a.Op)
+}
+
+func (s *Syncer) upload(ctx context.Context, obj *ObjectHandle, p string) error {
+	f, err := os.Open(s.localPath(p))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := obj.NewWriter(ctx)
+	w.ComputeChecksums = true
+	if _, err := io.Copy(w, f); err != nil {
+		w.CloseWithError(err)
+		return err
+	}
+	return w.Close()
+}
+
+// download writes the object to a temporary file, which replaces the file
+// with path p once the object has been read in full.
+func (s *Syncer) download(ctx context.Context, obj *ObjectHandle, p string) error {
+	name := s.localPath(p)
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import "testing"
+
+func TestSyncExcluded(t *testing.T) {
+	s := &Syncer{Exclude: []string{"tmp", "*.o", "build/*"}}
+	for _, test := range []struct {
+		path string
+		want bool
+	}{
+		{"a.txt", false},
+		{"tmp", true},
+		{"tmp/x", true},
+		{"src/tmp/x", false},
+		{"main.o", true},
+		{"src/main.o", false},
+		{"build", false},
+		{"build/out", true},
+		{"build/out/deep", true},
+	} {
+		if got := s.excluded(test.path); got != test.want {
+			t.Errorf("excluded(%q) = %t, want %t", test.path, got, test.want)
+		}
+	}
+}
+
+func TestIsLocalPath(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		want bool
+	}{
+		{"a", true},
+		{"a/b", true},
+		{"a..b", true},
+		{"..", false},
+		{"../a", false},
+		{"a/../b", false},
+		{"/a", false},
+		{"a//b", false},
+		{"./a", false},
+	} {
+		if got := isLocalPath(test.path); got != test.want {
+			t.Errorf("isLocalPath(%q) = %t, want %t", test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+const (
+	// defaultPartSize is the default size of the parts of a parallel
+	// transfer.
+	defaultPartSize = 32 << 20
+
+	// defaultParallelism is the default number of parts of a parallel
+	// transfer that are in flight at once.
+	defaultParallelism = 4
+
+	// maxComposeSources is the largest number of objects that can be
+	// composed in one request.
+	maxComposeSources = 32
+
+	// maxComponents is the largest number of parts a composite object may
+	// be built from, however many tiers of composition are used.
+	maxComponents = 1024
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ParallelUploaderFrom creates a ParallelUploader that uploads the first size
+// bytes of r, such as an *os.File, to dst. You can immediately call Run on the
+// returned ParallelUploader, or you can configure it first.
+func (dst *ObjectHandle) ParallelUploaderFrom(r io.ReaderAt, size int64) *ParallelUploader {
+	return &ParallelUploader{dst: dst, r: r, size: size}
+}
+
+// A ParallelUploader uploads a large object by writing its parts concurrently
+// as temporary objects, and composing them into the destination. Since
+// composition is limited to 32 sources, the parts of objects with more are
+// composed in tiers.
+//
+// The destination is a composite object, which has a CRC32C checksum but no
+// MD5 hash. Run verifies the checksum against the data it read.
+type ParallelUploader struct {
+	// ObjectAttrs are optional attributes to set on the destination object.
+	// Any attributes must be initialized before any calls on the
+	// ParallelUploader. Nil or zero-valued attributes are ignored.
+	ObjectAttrs
+
+	// PartSize is the size in bytes of each part. If zero, it defaults to
+	// 32 MiB. It is increased if necessary to keep the number of parts
+	// within the service's limit of 1024 components per object. Objects no
+	// larger than PartSize are written directly to the destination.
+	PartSize int64
+
+	// Parallelism is the number of requests that are in flight at once. If
+	// zero, it defaults to 4.
+	Parallelism int
+
+	// TempPrefix is the prefix of the names of the temporary objects, which
+	// are written to the destination's bucket. If empty, it is derived from
+	// the destination's name and a random string.
+	//
+	// The temporary objects are deleted when Run returns. If that fails,
+	// perhaps because ctx is done, they can be found by listing the prefix.
+	TempPrefix string
+
+	dst  *ObjectHandle
+	r    io.ReaderAt
+	size int64
+}
+
+// Run performs the upload.
+func (u *ParallelUploader) Run(ctx context.Context) (*ObjectAttrs, error) {
+	if err := u.dst.validate(); err != nil {
+		return nil, err
+	}
+	if u.size < 0 {
+		return nil, fmt.Errorf("storage: invalid upload size %d", u.size)
+	}
+	partSize := u.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	if min := (u.size + maxComponents - 1) / maxComponents; partSize < min {
+		partSize = min
+	}
+	if u.size <= partSize {
+		attrs, err := u.uploadPart(ctx, u.dst, u.ObjectAttrs, 0, u.size)
+		if err != nil {
+			return nil, err
+		}
+		return attrs, nil
+	}
+	prefix := u.TempPrefix
+	if prefix == "" {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		prefix = fmt.Sprintf("%s.parts-%x/", u.dst.object, id)
+	}
+
+	var (
+		mu    sync.Mutex
+		temps []*ObjectHandle // temporary objects to delete
+	)
+	created := func(o *ObjectHandle) {
+		mu.Lock()
+		temps = append(temps, o)
+		mu.Unlock()
+	}
+	defer func() {
+		forEach(ctx, len(temps), u.parallelism(), func(ctx context.Context, i int) error {
+			return temps[i].Delete(ctx)
+		})
+	}()
+	// Temporary objects are billed and retried like the destination.
+	bkt := &BucketHandle{c: u.dst.c, name: u.dst.bucket, retry: u.dst.retry, userProject: u.dst.userProject}
+	temp := func(name string) *ObjectHandle {
+		return bkt.Object(prefix + name)
+	}
+
+	n := int((u.size + partSize - 1) / partSize)
+	srcs := make([]*ObjectHandle, n)
+	crcs := make([]uint32, n)
+	err := forEach(ctx, n, u.parallelism(), func(ctx context.Context, i int) error {
+		off := int64(i) * partSize
+		length := partSize
+		if off+length > u.size {
+			length = u.size - off
+		}
+		part := temp(fmt.Sprint(i))
+		// Parts are encrypted with the destination's key, which the
+		// compose request supplies for the sources.
+		attrs, err := u.uploadPart(ctx, part.Key(u.dst.encryptionKey), ObjectAttrs{}, off, length)
+		if attrs != nil {
+			created(part)
+		}
+		if err != nil {
+			return err
+		}
+		srcs[i], crcs[i] = part, attrs.CRC32C
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for tier := 1; len(srcs) > maxComposeSources; tier++ {
+		next := make([]*ObjectHandle, (len(srcs)+maxComposeSources-1)/maxComposeSources)
+		err := forEach(ctx, len(next), u.parallelism(), func(ctx context.Context, i int) error {
+			group := srcs[i*maxComposeSources:]
+			if len(group) > maxComposeSources {
+				group = group[:maxComposeSources]
+			}
+			o := temp(fmt.Sprintf("tier%d-%d", tier, i))
+			if _, err := o.Key(u.dst.encryptionKey).ComposerFrom(group...).Run(ctx); err != nil {
+				return err
+			}
+			created(o)
+			next[i] = o
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		srcs = next
+	}
+	c := u.dst.ComposerFrom(srcs...)
+	c.ObjectAttrs = u.ObjectAttrs
+	attrs, err := c.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var want uint32
+	for i, crc := range crcs {
+		length := partSize
+		if i == n-1 {
+			length = u.size - int64(i)*partSize
+		}
+		want = crc32cCombine(want, crc, length)
+	}
+	if attrs.CRC32C != want {
+		return nil, fmt.Errorf("storage: composed object has CRC32C %08x, want %08x", attrs.CRC32C, want)
+	}
+	return attrs, nil
+}
+
+// uploadPart writes length bytes of the source, starting at off, to o. It
+// returns an error if the object's checksum doesn't match the data read, but
+// returns the attributes of the object it created in that case too.
+func (u *ParallelUploader) uploadPart(ctx context.Context, o *ObjectHandle, attrs ObjectAttrs, off, length int64) (*ObjectAttrs, error) {
+	w := o.NewWriter(ctx)
+	w.ObjectAttrs = attrs
+	w.Name = o.object
+	crc := crc32.New(crc32cTable)
+	if _, err := io.Copy(w, io.TeeReader(io.NewSectionReader(u.r, off, length), crc)); err != nil {
+		w.CloseWithError(err)
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if got, want := w.Attrs().CRC32C, crc.Sum32(); got != want {
+		return w.Attrs(), fmt.Errorf("storage: uploaded object %q has CRC32C %08x, want %08x", o.object, got, want)
+	}
+	return w.Attrs(), nil
+}
+
+func (u *ParallelUploader) parallelism() int {
+	if u.Parallelism <= 0 {
+		return defaultParallelism
+	}
+	return u.Parallelism
+}
+
+// ParallelDownloaderTo creates a ParallelDownloader that downloads src to w,
+// such as an *os.File. You can immediately call Run on the returned
+// ParallelDownloader, or you can configure it first.
+func (src *ObjectHandle) ParallelDownloaderTo(w io.WriterAt) *ParallelDownloader {
+	return &ParallelDownloader{src: src, w: w}
+}
+
+// A ParallelDownloader downloads a large object by reading ranges of it
+// concurrently. It reads a single generation of the object, and verifies the
+// CRC32C checksum of the whole once every range has been written.
+//
+// Objects stored with gzip content encoding are not supported, since the
+// service decompresses them as they are read.
+type ParallelDownloader struct {
+	// PartSize is the size in bytes of each range. If zero, it defaults to
+	// 32 MiB.
+	PartSize int64
+
+	// Parallelism is the number of ranges that are read at once. If zero, it
+	// defaults to 4.
+	Parallelism int
+
+	src *ObjectHandle
+	w   io.WriterAt
+}
+
+// Run performs the download, and returns the attributes of the object read.
+func (d *ParallelDownloader) Run(ctx context.Context) (*ObjectAttrs, error) {
+	attrs, err := d.src.Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if attrs.ContentEncoding == "gzip" {
+		return nil, errors.New("storage: ParallelDownloader does not support gzip-encoded objects")
+	}
+	// Read the same generation throughout, even if the object is replaced.
+	src := d.src.Generation(attrs.Generation)
+	partSize := d.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	parallelism := d.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultParallelism
+	}
+	n := int((attrs.Size + partSize - 1) / partSize)
+	crcs := make([]uint32, n)
+	lengths := make([]int64, n)
+	err = forEach(ctx, n, parallelism, func(ctx context.Context, i int) error {
+		off := int64(i) * partSize
+		length := partSize
+		if off+length > attrs.Size {
+			length = attrs.Size - off
+		}
+		r, err := src.NewRangeReader(ctx, off, length)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		crc := crc32.New(crc32cTable)
+		m, err := io.Copy(io.MultiWriter(&offsetWriter{w: d.w, off: off}, crc), r)
+		if err != nil {
+			return err
+		}
+		if m != length {
+			return io.ErrUnexpectedEOF
+		}
+		crcs[i], lengths[i] = crc.Sum32(), length
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var got uint32
+	for i, crc := range crcs {
+		got = crc32cCombine(got, crc, lengths[i])
+	}
+	if got != attrs.CRC32C {
+		return nil, fmt.Errorf("storage: downloaded data has CRC32C %08x, want %08x", got, attrs.CRC32C)
+	}
+	return attrs, nil
+}
+
+// offsetWriter writes to an io.WriterAt sequentially from an offset.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// forEach calls f for each integer in [0, n), with at most parallelism calls
+// running at once. It returns the first error. Once there is an error, the
+// context passed to f is canceled and no more calls are made.
+func forEach(ctx context.Context, n, parallelism int, f func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+	)
+	sem := make(chan struct{}, parallelism)
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := f(ctx, i); err != nil {
+				mu.Lock()
+				if first == nil {
+					first = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if first == nil {
+		first = ctx.Err()
+	}
+	return first
+}
+
+// crc32cCombine returns the CRC32C checksum of the concatenation of two byte
+// sequences, given the checksum of each and the length of the second. It is
+// adapted from crc32_combine in zlib.
+func crc32cCombine(crc1, crc2 uint32, len2 int64) uint32 {
+	if len2 <= 0 {
+		return crc1
+	}
+	var even, odd [32]uint32 // operators for even and odd powers of two zero bits
+
+	// Put the operator for one zero bit in odd.
+	odd[0] = crc32.Castagnoli
+	row := uint32(1)
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+	gf2MatrixSquare(&even, &odd) // two zero bits
+	gf2MatrixSquare(&odd, &even) // four zero bits
+
+	// Apply len2 zeros to crc1. The first squaring puts the operator for one
+	// zero byte, eight zero bits, in even.
+	for {
+		gf2MatrixSquare(&even, &odd)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&even, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+		gf2MatrixSquare(&odd, &even)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&odd, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+	}
+	return crc1 ^ crc2
+}
+
+func gf2MatrixTimes(mat *[32]uint32, vec uint32) uint32 {
+	var sum uint32
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square, mat *[32]uint32) {
+	for n := range mat {
+		square[n] = gf2MatrixTimes(mat, mat[n])
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"hash/crc32"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestCRC32CCombine(t *testing.T) {
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i*7 + i/13)
+	}
+	want := crc32.Checksum(data, crc32cTable)
+	for _, cut := range []int{0, 1, 7, 1000, 65536, 99999, 100000} {
+		a, b := data[:cut], data[cut:]
+		got := crc32cCombine(crc32.Checksum(a, crc32cTable), crc32.Checksum(b, crc32cTable), int64(len(b)))
+		if got != want {
+			t.Errorf("cut at %d: got %08x, want %08x", cut, got, want)
+		}
+	}
+}
+
+func TestForEach(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		running, peak int
+		seen          = map[int]bool{}
+	)
+	err := forEach(context.Background(), 20, 3, func(ctx context.Context, i int) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		seen[i] = true
+		mu.Unlock()
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 20 || peak > 3 {
+		t.Errorf("saw %d calls with at most %d at once, want 20 and 3", len(seen), peak)
+	}
+
+	errBoom := errors.New("boom")
+	err = forEach(context.Background(), 100, 1, func(ctx context.Context, i int) error {
+		if i == 5 {
+			return errBoom
+		}
+		if i > 5 {
+			t.Errorf("call %d made after an error", i)
+		}
+		return nil
+	})
+	if err != errBoom {
+		t.Errorf("got %v, want %v", err, errBoom)
+	}
+}
@@ -15,8 +15,14 @@
 package storage
 
 import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"unicode/utf8"
 
@@ -43,6 +49,41 @@ type Writer struct {
 	// must be done before the first Write call.
 	ChunkSize int
 
+	// Resumable makes the Writer upload the object in a resumable upload
+	// session that outlives the process, and which can be continued with
+	// ObjectHandle.ResumeWriter. The Writer buffers up to ChunkSize bytes,
+	// which defaults to googleapi.DefaultUploadChunkSize if zero, and sends
+	// them from the calling goroutine.
+	//
+	// If an error occurs, or the Writer is closed with CloseWithError, the
+	// session is left intact, and its URI can be read with SessionURI.
+	Resumable bool
+
+	// Checkpoint, if non-nil, is called during a resumable write when the
+	// upload session begins and whenever the service persists another chunk
+	// of the object, with the session URI and the number of bytes persisted.
+	// Recording them lets a later process resume the upload.
+	Checkpoint func(sessionURI string, offset int64)
+
+	// SendCRC32C specifies whether ObjectAttrs.CRC32C is sent to the service
+	// with the object's attributes. Since zero is a valid checksum, it is
+	// not sent otherwise. ObjectAttrs.MD5 is sent whenever it is non-empty.
+	SendCRC32C bool
+
+	// ComputeChecksums makes the Writer compute the CRC32C and MD5 hashes of
+	// the data written, for when they aren't known in advance, and send them
+	// to the service after the object's contents, so that it rejects a
+	// corrupted upload rather than replace the object. The hashes are sent
+	// with the last chunk of a resumable upload, which the Writer uses even
+	// if Resumable is not set, buffering up to ChunkSize bytes. Writers of
+	// HMAC clients, which do not support resumable uploads, send them in the
+	// trailer of their request instead. In any case, Close returns an error
+	// if the hashes don't match those the service reports for the new object.
+	//
+	// ComputeChecksums is ignored by Writers returned by
+	// ObjectHandle.ResumeWriter, which don't see the whole object.
+	ComputeChecksums bool
+
 	ctx context.Context
 	o   *ObjectHandle
 
@@ -52,6 +93,16 @@ type Writer struct {
 	donec chan struct{} // closed after err and obj are set.
 	err   error
 	obj   *ObjectAttrs
+
+	// The state of a resumable write.
+	resumable  bool // set if Resumable or ComputeChecksums is
+	sessionURI string
+	offset     int64  // bytes persisted by the service
+	buf        []byte // bytes following offset not yet persisted
+
+	// Hashes of the data written, if ComputeChecksums is set.
+	crc hash.Hash32
+	md5 hash.Hash
 }
 
 func (w *Writer) open() error {
@@ -64,47 +115,82 @@ func (w *Writer) open() error {
 	if !utf8.ValidString(attrs.Name) {
 		return fmt.Errorf("storage: object name %q is not valid UTF-8", attrs.Name)
 	}
-	pr, pw := io.Pipe()
-	w.pw = pw
-	w.opened = true
-
-	if w.ChunkSize < 0 {
-		return errors.New("storage: Writer.ChunkSize must non-negative")
+	if attrs.KMSKeyName != "" && w.o.encryptionKey != nil {
+		return errors.New("storage: cannot use a KMS key with a customer-supplied encryption key")
 	}
-	mediaOpts := []googleapi.MediaOption{
-		googleapi.ChunkSize(w.ChunkSize),
+	if w.ComputeChecksums {
+		w.crc = crc32.New(crc32cTable)
+		w.md5 = md5.New()
 	}
-	if c := attrs.ContentType; c != "" {
-		mediaOpts = append(mediaOpts, googleapi.ContentType(c))
+	if w.Resumable && w.o.c.xmlWrites {
+		return errors.New("storage: Writer.Resumable is not supported by HMAC clients")
+	}
+	// Unlike a single request, a resumable upload can send the computed
+	// hashes after the contents of the object.
+	if w.Resumable || (w.ComputeChecksums && !w.o.c.xmlWrites) {
+		w.resumable = true
+		return w.openResumable()
 	}
+	if w.ChunkSize < 0 {
+		return errors.New("storage: Writer.ChunkSize must non-negative")
+	}
+	pr, pw := io.Pipe()
+	w.pw = pw
+	w.opened = true
 
 	go func() {
 		defer close(w.donec)
 
-		call := w.o.c.raw.Objects.Insert(w.o.bucket, attrs.toRawObject(w.o.bucket)).
-			Media(pr, mediaOpts...).
-			Projection("full").
-			Context(w.ctx)
-		if err := setEncryptionHeaders(call.Header(), w.o.encryptionKey, false); err != nil {
-			w.err = err
-			pr.CloseWithError(w.err)
-			return
-		}
-		var resp *raw.Object
-		err := applyConds("NewWriter", w.o.gen, w.o.conds, call)
-		if err == nil {
-			resp, err = call.Do()
-		}
-		if err != nil {
+		if err := w.upload(pr); err != nil {
 			w.err = err
 			pr.CloseWithError(w.err)
-			return
 		}
-		w.obj = newObject(resp)
 	}()
 	return nil
 }
 
+// upload sends the object, whose contents are read from r.
+func (w *Writer) upload(r io.Reader) error {
+	if w.o.c.xmlWrites {
+		return w.insertXML(r)
+	}
+	return w.insert(r)
+}
+
+// insert uploads the object with the JSON API.
+func (w *Writer) insert(r io.Reader) error {
+	attrs := w.ObjectAttrs
+	mediaOpts := []googleapi.MediaOption{
+		googleapi.ChunkSize(w.ChunkSize),
+	}
+	if c := attrs.ContentType; c != "" {
+		mediaOpts = append(mediaOpts, googleapi.ContentType(c))
+	}
+	call := w.o.c.raw.Objects.Insert(w.o.bucket, w.rawObject()).
+		Media(r, mediaOpts...).
+		Projection("full").
+		Context(w.ctx)
+	if k := kmsKeyWithoutVersion(attrs.KMSKeyName); k != "" {
+		call.KmsKeyName(k)
+	}
+	if w.o.userProject != "" {
+		call.UserProject(w.o.userProject)
+	}
+	if err := setEncryptionHeaders(call.Header(), w.o.encryptionKey, false); err != nil {
+		return err
+	}
+	var resp *raw.Object
+	err := applyConds("NewWriter", w.o.gen, w.o.conds, call)
+	if err == nil {
+		resp, err = call.Do()
+	}
+	if err != nil {
+		return err
+	}
+	w.obj = newObject(resp)
+	return nil
+}
+
 // Write appends to w. It implements the io.Writer interface.
 func (w *Writer) Write(p []byte) (n int, err error) {
 	if w.err != nil {
@@ -115,7 +201,16 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 			return 0, err
 		}
 	}
-	return w.pw.Write(p)
+	if w.resumable {
+		n, err = w.writeResumable(p)
+	} else {
+		n, err = w.pw.Write(p)
+	}
+	if w.crc != nil {
+		w.crc.Write(p[:n])
+		w.md5.Write(p[:n])
+	}
+	return n, err
 }
 
 // Close completes the write operation and flushes any buffered data.
@@ -127,10 +222,16 @@ func (w *Writer) Close() error {
 			return err
 		}
 	}
+	if w.resumable {
+		return w.closeResumable()
+	}
 	if err := w.pw.Close(); err != nil {
 		return err
 	}
 	<-w.donec
+	if w.err == nil {
+		w.err = w.checkChecksums()
+	}
 	return w.err
 }
 
@@ -140,6 +241,12 @@ func (w *Writer) CloseWithError(err error) error {
 	if !w.opened {
 		return nil
 	}
+	if w.resumable {
+		if w.err == nil {
+			w.err = err
+		}
+		return nil
+	}
 	return w.pw.CloseWithError(err)
 }
 
@@ -148,3 +255,45 @@ func (w *Writer) CloseWithError(err error) error {
 func (w *Writer) Attrs() *ObjectAttrs {
 	return w.obj
 }
+
+// rawObject returns the attributes of the object to send to the service.
+func (w *Writer) rawObject() *raw.Object {
+	obj := w.ObjectAttrs.toRawObject(w.o.bucket)
+	if w.SendCRC32C {
+		obj.Crc32c = encodeUint32(w.CRC32C)
+	}
+	if len(w.MD5) > 0 {
+		obj.Md5Hash = base64.StdEncoding.EncodeToString(w.MD5)
+	}
+	return obj
+}
+
+// checksumHeader returns the hashes computed by the Writer in the form of
+// the X-Goog-Hash header, or the empty string if there are none.
+func (w *Writer) checksumHeader() string {
+	if w.crc == nil {
+		return ""
+	}
+	return "crc32c=" + encodeUint32(w.crc.Sum32()) + ",md5=" + base64.StdEncoding.EncodeToString(w.md5.Sum(nil))
+}
+
+// checkChecksums compares the hashes computed by the Writer, if any, with
+// those the service reports for the new object.
+func (w *Writer) checkChecksums() error {
+	if w.crc == nil {
+		return nil
+	}
+	if got, want := w.obj.CRC32C, w.crc.Sum32(); got != want {
+		return fmt.Errorf("storage: object %q has CRC32C %08x, but the data written has %08x", w.o.object, got, want)
+	}
+	if got, want := w.obj.MD5, w.md5.Sum(nil); len(got) > 0 && !bytes.Equal(got, want) {
+		return fmt.Errorf("storage: object %q has MD5 %x, but the data written has %x", w.o.object, got, want)
+	}
+	return nil
+}
+
+func encodeUint32(u uint32) string {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, u)
+	return base64.StdEncoding.EncodeToString(b)
+}
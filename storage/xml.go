@@ -0,0 +1,270 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+const hmacAlgorithmV4 = "GOOG4-HMAC-SHA256"
+
+// NewHMACClient creates a Client that signs its requests with the HMAC key
+// with the given access ID and secret, as described at
+// https://cloud.google.com/storage/docs/authentication/hmackeys, rather than
+// authenticating them with OAuth2.
+//
+// Only the XML API accepts HMAC signatures, so the Client can only read
+// objects, with ObjectHandle.NewReader and NewRangeReader, and write them,
+// with Writers, which upload each object with a single request to the XML
+// API. Writers of the Client do not support Resumable, ChunkSize or
+// ObjectAttrs.ACL, and do not detect the content type of objects. Other
+// operations fail.
+//
+// The options are as for NewClient, except that any credentials are ignored.
+func NewHMACClient(ctx context.Context, accessID, secret string, opts ...option.ClientOption) (*Client, error) {
+	if accessID == "" || secret == "" {
+		return nil, errors.New("storage: NewHMACClient: missing access ID or secret")
+	}
+	opts = append(opts, option.WithoutAuthentication())
+	c, err := NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.hc = &http.Client{
+		Transport: &HMACTransport{AccessID: accessID, Secret: secret, Base: c.hc.Transport},
+	}
+	c.xmlWrites = true
+	return c, nil
+}
+
+// HMACTransport is an http.RoundTripper that signs requests to the XML API
+// with an HMAC key, with the V4 signing process described at
+// https://cloud.google.com/storage/docs/authentication/signatures. The
+// payloads of requests are not signed.
+//
+// NewHMACClient uses an HMACTransport. It may also be used to sign requests
+// made to the XML API with other tools.
+type HMACTransport struct {
+	// AccessID and Secret are those of the HMAC key.
+	AccessID string
+	Secret   string
+
+	// Base is the RoundTripper that sends the signed requests. If nil,
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+}
+
+// RoundTrip signs a copy of req, and sends it with t.Base.
+func (t *HMACTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r2 := new(http.Request)
+	*r2 = *req
+	u := *req.URL
+	r2.URL = &u
+	r2.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r2.Header[k] = append([]string(nil), v...)
+	}
+	signHMAC(r2, t.AccessID, t.Secret, now().UTC())
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(r2)
+}
+
+// signHMAC adds the headers that sign req at time t, which is in UTC. It
+// rewrites the path and query of req.URL in their canonical forms, so that
+// they are sent as they were signed.
+func signHMAC(req *http.Request, accessID, secret string, t time.Time) {
+	req.Header.Set("X-Goog-Date", t.Format(timestampV4))
+	req.Header.Set("X-Goog-Content-Sha256", "UNSIGNED-PAYLOAD")
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, vs := range req.Header {
+		name = strings.ToLower(name)
+		if name != "content-type" && name != "content-md5" && !strings.HasPrefix(name, "x-goog-") {
+			continue
+		}
+		values := make([]string, len(vs))
+		for i, v := range vs {
+			values[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[name] = strings.Join(values, ",")
+	}
+	var names []string
+	for n := range headers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	signedHeaders := strings.Join(names, ";")
+
+	req.URL.RawPath = escapePathV4(req.URL.Path)
+	req.URL.RawQuery = strings.Replace(req.URL.Query().Encode(), "+", "%20", -1)
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s\n", req.Method)
+	fmt.Fprintf(buf, "%s\n", req.URL.RawPath)
+	fmt.Fprintf(buf, "%s\n", req.URL.RawQuery)
+	for _, n := range names {
+		fmt.Fprintf(buf, "%s:%s\n", n, headers[n])
+	}
+	fmt.Fprintf(buf, "\n%s\n", signedHeaders)
+	fmt.Fprint(buf, "UNSIGNED-PAYLOAD")
+	sum := sha256.Sum256(buf.Bytes())
+	scope := t.Format(dateV4) + "/auto/storage/goog4_request"
+	stringToSign := fmt.Sprintf("%s\n%s\n%s\n%x", hmacAlgorithmV4, t.Format(timestampV4), scope, sum[:])
+
+	key := []byte("GOOG4" + secret)
+	for _, s := range []string{t.Format(dateV4), "auto", "storage", "goog4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		hmacAlgorithmV4, accessID, scope, signedHeaders, sig))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// insertXML uploads the object with a single PUT request to the XML API, for
+// Clients that can't use the JSON API.
+func (w *Writer) insertXML(r io.Reader) error {
+	attrs := w.ObjectAttrs
+	o := w.o
+	u := &url.URL{
+		Scheme:   o.c.scheme,
+		Host:     o.c.readHost,
+		Path:     fmt.Sprintf("/%s/%s", o.bucket, o.object),
+		RawQuery: conditionsQuery(-1, o.conds),
+	}
+	if o.userProject != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += "userProject=" + url.QueryEscape(o.userProject)
+	}
+	body := &countingReader{r: r}
+	// With an unknown length, the body is sent with chunked encoding.
+	req, err := http.NewRequest("PUT", u.String(), body)
+	if err != nil {
+		return err
+	}
+	h := req.Header
+	setNonEmpty := func(name, value string) {
+		if value != "" {
+			h.Set(name, value)
+		}
+	}
+	setNonEmpty("Content-Type", attrs.ContentType)
+	setNonEmpty("Content-Encoding", attrs.ContentEncoding)
+	setNonEmpty("Content-Language", attrs.ContentLanguage)
+	setNonEmpty("Cache-Control", attrs.CacheControl)
+	setNonEmpty("Content-Disposition", attrs.ContentDisposition)
+	setNonEmpty("X-Goog-Encryption-Kms-Key-Name", kmsKeyWithoutVersion(attrs.KMSKeyName))
+	for k, v := range attrs.Metadata {
+		h.Set("X-Goog-Meta-"+k, v)
+	}
+	if len(attrs.MD5) > 0 {
+		h.Set("Content-Md5", base64.StdEncoding.EncodeToString(attrs.MD5))
+	}
+	if w.SendCRC32C {
+		h.Set("X-Goog-Hash", "crc32c="+encodeUint32(attrs.CRC32C))
+	}
+	if w.crc != nil {
+		// The computed hashes are only known once the whole object has
+		// been read, so they follow it in the trailer of the request.
+		req.Trailer = http.Header{"X-Goog-Hash": nil}
+		body.atEOF = func() { req.Trailer.Set("X-Goog-Hash", w.checksumHeader()) }
+	}
+	if err := setEncryptionHeaders(h, o.encryptionKey, false); err != nil {
+		return err
+	}
+	res, err := ctxhttp.Do(w.ctx, o.c.hc, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return err
+	}
+	w.obj = w.xmlObjectAttrs(res.Header, body.n)
+	return nil
+}
+
+// xmlObjectAttrs returns the attributes of an object written with the XML
+// API, from those the Writer sent and the headers of the response.
+func (w *Writer) xmlObjectAttrs(h http.Header, size int64) *ObjectAttrs {
+	attrs := w.ObjectAttrs
+	attrs.Bucket = w.o.bucket
+	attrs.Size = size
+	attrs.Generation, _ = strconv.ParseInt(h.Get("X-Goog-Generation"), 10, 64)
+	attrs.MetaGeneration, _ = strconv.ParseInt(h.Get("X-Goog-Metageneration"), 10, 64)
+	attrs.CRC32C, _ = parseCRC32C(h)
+	attrs.MD5 = nil
+	for _, v := range h["X-Goog-Hash"] {
+		for _, kv := range strings.Split(v, ",") {
+			kv = strings.TrimSpace(kv)
+			if strings.HasPrefix(kv, "md5=") {
+				attrs.MD5, _ = base64.StdEncoding.DecodeString(kv[len("md5="):])
+			}
+		}
+	}
+	if attrs.ContentType == "" {
+		attrs.ContentType = "application/octet-stream"
+	}
+	return &attrs
+}
+
+// countingReader counts the bytes read from r. If atEOF is not nil, it is
+// called when r returns io.EOF, before the caller sees it.
+type countingReader struct {
+	r     io.Reader
+	n     int64
+	atEOF func()
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err == io.EOF && c.atEOF != nil {
+		c.atEOF()
+		c.atEOF = nil
+	}
+	return n, err
+}